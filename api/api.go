@@ -20,6 +20,8 @@ type Client interface {
 	JobStatus(context.Context, job.ID) (job.Status, error)
 	SyncStatus(ctx context.Context, ref string) ([]string, error)
 	UpdatePolicies(context.Context, policy.Updates, update.Cause) (job.ID, error)
+	Promote(context.Context, update.PromoteSpec, update.Cause) (job.ID, error)
+	ApplyPlan(context.Context, string, update.Cause) (job.ID, error)
 	Export(context.Context) ([]byte, error)
 	PublicSSHKey(ctx context.Context, regenerate bool) (ssh.PublicKey, error)
 }
@@ -35,10 +35,12 @@ type Controller struct {
 
 // A Container represents a container specification in a pod. The Name
 // identifies it within the pod, and the Image says which image it's
-// configured to run.
+// configured to run. Init is true for init containers, which are run
+// to completion before the other containers are started.
 type Container struct {
 	Name  string
 	Image string
+	Init  bool
 }
 
 // Sometimes we care if we can't find the containers for a service,
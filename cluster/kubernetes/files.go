@@ -4,27 +4,37 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux"
-
+	"github.com/weaveworks/flux/cluster"
 	"github.com/weaveworks/flux/cluster/kubernetes/resource"
 )
 
 // FindDefinedServices finds all the services defined under the
 // directory given, and returns a map of service IDs (from its
-// specified namespace and name) to the paths of resource definition
-// files.
-func (c *Manifests) FindDefinedServices(path string) (map[flux.ResourceID][]string, error) {
+// specified namespace and name) to the locations of their resource
+// definitions, i.e., the file, and the document in the file.
+func (c *Manifests) FindDefinedServices(path string) (map[flux.ResourceID][]cluster.ManifestLocation, error) {
 	objects, err := resource.Load(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "loading resources")
 	}
 
-	var result = map[flux.ResourceID][]string{}
+	var result = map[flux.ResourceID][]cluster.ManifestLocation{}
 	for _, obj := range objects {
 		id := obj.ResourceID()
 		_, kind, _ := id.Components()
 		if _, ok := resourceKinds[kind]; ok {
-			result[id] = append(result[id], obj.Source())
+			location := cluster.ManifestLocation{Path: obj.Source(), Item: -1}
+			if p, ok := obj.(positioned); ok {
+				location.Document, location.Item = p.Position()
+			}
+			result[id] = append(result[id], location)
 		}
 	}
 	return result, nil
 }
+
+// positioned is implemented by resources loaded from files, to say
+// where in the file they are defined.
+type positioned interface {
+	Position() (document, item int)
+}
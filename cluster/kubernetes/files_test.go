@@ -1,9 +1,13 @@
 package kubernetes
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"reflect"
 	"testing"
 
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
 	"github.com/weaveworks/flux/cluster/kubernetes/testfiles"
 )
 
@@ -24,3 +28,54 @@ func TestDefinedServices(t *testing.T) {
 		t.Errorf("Expected:\n%#v\ngot:\n%#v\n", testfiles.ServiceMap(dir), services)
 	}
 }
+
+func TestDefinedServicesMultidoc(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "bundle.yaml")
+	if err := ioutil.WriteFile(path, []byte(`---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: extensions/v1beta1
+  kind: Deployment
+  metadata:
+    name: worker
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: worker
+- apiVersion: extensions/v1beta1
+  kind: DaemonSet
+  metadata:
+    name: agent
+    namespace: monitoring
+`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := (&Manifests{}).FindDefinedServices(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[flux.ResourceID][]cluster.ManifestLocation{
+		flux.MustParseResourceID("default:deployment/web"):     {{Path: path, Document: 1, Item: -1}},
+		flux.MustParseResourceID("default:deployment/worker"):  {{Path: path, Document: 2, Item: 0}},
+		flux.MustParseResourceID("monitoring:daemonset/agent"): {{Path: path, Document: 2, Item: 2}},
+	}
+	if !reflect.DeepEqual(expected, services) {
+		t.Errorf("Expected:\n%#v\ngot:\n%#v\n", expected, services)
+	}
+}
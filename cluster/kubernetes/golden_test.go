@@ -0,0 +1,159 @@
+package kubernetes
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster/kubernetes/yamledit"
+	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/policy"
+)
+
+// The golden file tests run each input file `testdata/*/<name>.yaml`
+// through an update, and compare the result with the file
+// `<name>.golden`. The update to make is given in comments at the top
+// of the input file, along with the resource to update if it's not
+// the first one in the file. If the update fails, the golden file contains
+// the error message instead, prefixed with "error: ".
+//
+// To regenerate the golden files after changing how updates are
+// made, run
+//
+//	go test ./cluster/kubernetes/ -run Golden -update
+//
+// and check the differences carefully.
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// headers returns the values of comment lines like `# <key>: <value>`
+// at the top of a file.
+func headers(def []byte, key string) []string {
+	var values []string
+	prefix := "# " + key + ":"
+	for _, line := range strings.Split(string(def), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		if strings.HasPrefix(line, prefix) {
+			values = append(values, strings.TrimSpace(strings.TrimPrefix(line, prefix)))
+		}
+	}
+	return values
+}
+
+// resourceHeader returns the resource to update, given in a comment
+// `# resource: <id>`, or otherwise the resource defined by the first
+// document.
+func resourceHeader(t *testing.T, def []byte) flux.ResourceID {
+	if ids := headers(def, "resource"); len(ids) > 0 {
+		return flux.MustParseResourceID(ids[0])
+	}
+	doc, err := yamledit.Parse(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resourceIDOf(doc.Root())
+}
+
+func testGolden(t *testing.T, dir string, update func(t *testing.T, def []byte) ([]byte, error)) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", dir, "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatalf("no test cases found in testdata/%s", dir)
+	}
+	for _, input := range inputs {
+		def, err := ioutil.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := update(t, def)
+		if err != nil {
+			out = []byte("error: " + err.Error() + "\n")
+		}
+
+		golden := strings.TrimSuffix(input, ".yaml") + ".golden"
+		if *updateGolden {
+			if err := ioutil.WriteFile(golden, out, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, expected) {
+			t.Errorf("%s: did not get expected result:\n\n%s\n\ninstead got:\n\n%s", input, expected, out)
+		}
+	}
+}
+
+func TestUpdateImagesGolden(t *testing.T) {
+	testGolden(t, "images", goldenImageUpdate)
+}
+
+func goldenImageUpdate(t *testing.T, def []byte) ([]byte, error) {
+	updates := headers(def, "update")
+	if len(updates) == 0 {
+		t.Fatal("no updates given in test case")
+	}
+	for _, u := range updates {
+		fields := strings.Fields(u)
+		if len(fields) != 2 {
+			t.Fatalf("expected '<container> <image>', got %q", u)
+		}
+		ref, err := image.ParseRef(fields[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if def, err = updatePodController(def, resourceHeader(t, def), fields[0], ref); err != nil {
+			return nil, err
+		}
+	}
+	return def, nil
+}
+
+func TestUpdatePoliciesGolden(t *testing.T) {
+	testGolden(t, "policies", goldenPolicyUpdate)
+}
+
+func goldenPolicyUpdate(t *testing.T, def []byte) ([]byte, error) {
+	update := policy.Update{Add: policy.Set{}, Remove: policy.Set{}}
+	for _, add := range headers(def, "add") {
+		parts := strings.SplitN(add, "=", 2)
+		if len(parts) != 2 {
+			t.Fatalf("expected '<policy>=<value>', got %q", add)
+		}
+		value := parts[1]
+		if strings.HasPrefix(value, `"`) {
+			var err error
+			if value, err = strconv.Unquote(value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		update.Add[policy.Policy(parts[0])] = value
+	}
+	for _, remove := range headers(def, "remove") {
+		update.Remove[policy.Policy(remove)] = "true"
+	}
+	return (&Manifests{}).UpdatePolicies(def, resourceHeader(t, def), update)
+}
+
+// The multidoc test cases are files with several resources in them,
+// which may be either image or policy updates.
+func TestUpdateMultidocGolden(t *testing.T) {
+	testGolden(t, "multidoc", func(t *testing.T, def []byte) ([]byte, error) {
+		if len(headers(def, "update")) > 0 {
+			return goldenImageUpdate(t, def)
+		}
+		return goldenPolicyUpdate(t, def)
+	})
+}
@@ -350,13 +350,15 @@ func mergeCredentials(c *Cluster, namespace string, podTemplate apiv1.PodTemplat
 	}
 
 	// Now create the service and attach the credentials
-	for _, container := range podTemplate.Spec.Containers {
-		r, err := image.ParseRef(container.Image)
-		if err != nil {
-			c.logger.Log("err", err.Error())
-			continue
+	for _, containers := range [][]apiv1.Container{podTemplate.Spec.Containers, podTemplate.Spec.InitContainers} {
+		for _, container := range containers {
+			r, err := image.ParseRef(container.Image)
+			if err != nil {
+				c.logger.Log("err", err.Error())
+				continue
+			}
+			imageCreds[r.Name] = creds
 		}
-		imageCreds[r.Name] = creds
 	}
 }
 
@@ -1,6 +1,7 @@
 package kubernetes
 
 import (
+	"github.com/weaveworks/flux"
 	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
 	"github.com/weaveworks/flux/image"
 	"github.com/weaveworks/flux/resource"
@@ -19,8 +20,8 @@ func (c *Manifests) ParseManifests(allDefs []byte) (map[string]resource.Resource
 	return kresource.ParseMultidoc(allDefs, "exported")
 }
 
-func (c *Manifests) UpdateDefinition(def []byte, container string, image image.Ref) ([]byte, error) {
-	return updatePodController(def, container, image)
+func (c *Manifests) UpdateDefinition(def []byte, id flux.ResourceID, container string, image image.Ref) ([]byte, error) {
+	return updatePodController(def, id, container, image)
 }
 
 // UpdatePolicies and ServicesWithPolicies in policies.go
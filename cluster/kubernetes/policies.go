@@ -2,20 +2,21 @@ package kubernetes
 
 import (
 	"io/ioutil"
-	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
-	yaml "gopkg.in/yaml.v2"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
 	"github.com/weaveworks/flux/cluster/kubernetes/resource"
+	"github.com/weaveworks/flux/cluster/kubernetes/yamledit"
 	"github.com/weaveworks/flux/policy"
 )
 
-func (m *Manifests) UpdatePolicies(in []byte, update policy.Update) ([]byte, error) {
+func (m *Manifests) UpdatePolicies(in []byte, id flux.ResourceID, update policy.Update) ([]byte, error) {
 	tagAll, _ := update.Add.Get(policy.TagAll)
-	return updateAnnotations(in, tagAll, func(a map[string]string) map[string]string {
+	return updateAnnotations(in, id, tagAll, func(a map[string]string) map[string]string {
 		for p, v := range update.Add {
 			if p == policy.TagAll {
 				continue
@@ -29,17 +30,22 @@ func (m *Manifests) UpdatePolicies(in []byte, update policy.Update) ([]byte, err
 	})
 }
 
-func updateAnnotations(def []byte, tagAll string, f func(map[string]string) map[string]string) ([]byte, error) {
-	manifest, err := parseManifest(def)
+func updateAnnotations(def []byte, id flux.ResourceID, tagAll string, f func(map[string]string) map[string]string) ([]byte, error) {
+	doc, manifest, err := findResource(def, id)
 	if err != nil {
 		return nil, err
 	}
-	annotations := manifest.Metadata.AnnotationsOrNil()
+	metadata := manifest.Get("metadata")
+	existing := metadata.Get("annotations")
+
+	annotations := map[string]string{}
+	for _, k := range existing.Keys() {
+		annotations[k] = existing.Get(k).Value()
+	}
 	if tagAll != "" {
-		containers := manifest.Spec.Template.Spec.Containers
-		for _, c := range containers {
-			p := resource.PolicyPrefix + string(policy.TagPrefix(c.Name))
-			if tagAll != "glob:*" {
+		for _, c := range containerNodes(manifest) {
+			p := resource.PolicyPrefix + string(policy.TagPrefix(c.Get("name").Value()))
+			if tagAll != policy.PatternAll.String() {
 				annotations[p] = tagAll
 			} else {
 				delete(annotations, p)
@@ -48,81 +54,80 @@ func updateAnnotations(def []byte, tagAll string, f func(map[string]string) map[
 	}
 	newAnnotations := f(annotations)
 
-	// Write the new annotations back into the manifest
-	// Generate a fragment of the new annotations.
-	var fragment string
-	if len(newAnnotations) > 0 {
-		fragmentB, err := yaml.Marshal(map[string]map[string]string{
-			"annotations": newAnnotations,
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		fragment = string(fragmentB)
-
-		// Remove the last newline, so it fits in better
-		fragment = strings.TrimSuffix(fragment, "\n")
-
-		// indent the fragment 2 spaces
-		fragment = regexp.MustCompile(`(.+)`).ReplaceAllString(fragment, "  $1")
-
-		// Add a newline if it's not blank
-		if len(fragment) > 0 {
-			fragment = "\n" + fragment
-		}
+	// Write the new annotations back into the manifest, changing
+	// only the entries that differ.
+	if !metadata.IsMapping() {
+		return nil, errors.New("Could not update resource annotations")
+	}
+	switch {
+	case len(newAnnotations) == 0:
+		err = metadata.Remove("annotations")
+	case existing.IsNull():
+		err = metadata.SetMap("annotations", newAnnotations)
+	case existing.IsMapping():
+		err = updateMapping(existing, newAnnotations)
+	default:
+		err = errors.New("Could not update resource annotations")
+	}
+	if err != nil {
+		return nil, err
 	}
+	return doc.Bytes()
+}
 
-	// Find where to insert the fragment.
-	// TODO: This should handle potentially different indentation.
-	// TODO: There's probably a more elegant regex-ey way to do this in one pass.
-	replaced := false
-	annotationsRE := regexp.MustCompile(`(?m:\n  annotations:\s*(?:#.*)*(?:\n    .*)*$)`)
-	newDef := annotationsRE.ReplaceAllStringFunc(string(def), func(found string) string {
-		if !replaced {
-			replaced = true
-			return fragment
-		}
-		return found
-	})
-	if !replaced {
-		metadataRE := multilineRE(`(metadata:\s*(?:#.*)*)`)
-		newDef = metadataRE.ReplaceAllStringFunc(string(def), func(found string) string {
-			if !replaced {
-				replaced = true
-				f := found + fragment
-				return f
+// updateMapping makes the entries in a mapping match the map given,
+// removing, adding and changing only those that differ.
+func updateMapping(m yamledit.Node, values map[string]string) error {
+	for _, k := range m.Keys() {
+		if _, ok := values[k]; !ok {
+			if err := m.Remove(k); err != nil {
+				return err
 			}
-			return found
-		})
+		}
 	}
-	if !replaced {
-		return nil, errors.New("Could not update resource annotations")
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
 	}
-
-	return []byte(newDef), err
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v := m.Get(k); v.IsScalar() && v.Value() == values[k] {
+			continue
+		}
+		if err := m.Set(k, values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type Manifest struct {
 	Metadata Metadata `yaml:"metadata"`
 	Spec     struct {
 		Template struct {
-			Spec struct {
-				Containers []Container `yaml:"containers"`
-			} `yaml:"spec"`
+			Spec PodSpec `yaml:"spec"`
 		} `yaml:"template"`
 		JobTemplate struct {
 			Spec struct {
 				Template struct {
-					Spec struct {
-						Containers []Container `yaml:"containers"`
-					} `yaml:"spec"`
+					Spec PodSpec `yaml:"spec"`
 				} `yaml:"template"`
 			} `yaml:"spec"`
 		} `yaml:"jobTemplate"`
 	} `yaml:"spec"`
 }
 
+// The keys under which containers are listed in a pod spec.
+const (
+	containersKey     = "containers"
+	initContainersKey = "initContainers"
+)
+
+type PodSpec struct {
+	InitContainers []Container `yaml:"initContainers"`
+	Containers     []Container `yaml:"containers"`
+}
+
 func (m Metadata) AnnotationsOrNil() map[string]string {
 	if m.Annotations == nil {
 		return map[string]string{}
@@ -140,46 +145,123 @@ type Container struct {
 	Image string `yaml:"image"`
 }
 
-func parseManifest(def []byte) (Manifest, error) {
+// parseManifest decodes the definition of the resource given, from
+// the bytes of a manifest file.
+func parseManifest(def []byte, id flux.ResourceID) (Manifest, error) {
 	var m Manifest
-	if err := yaml.Unmarshal(def, &m); err != nil {
+	_, resource, err := findResource(def, id)
+	if err != nil {
+		return m, err
+	}
+	if err := resource.Decode(&m); err != nil {
 		return m, errors.Wrap(err, "decoding annotations")
 	}
 	return m, nil
 }
 
 func (m *Manifests) ServicesWithPolicies(root string) (policy.ResourceMap, error) {
+	result, _, err := m.ServicesWithPolicySources(root)
+	return result, err
+}
+
+// ServicesWithPolicySources returns the policies in effect for all
+// services, including those given in the policy file and those
+// inherited from the defaults given on namespaces, and where each
+// came from.
+func (m *Manifests) ServicesWithPolicySources(root string) (policy.ResourceMap, policy.SourceMap, error) {
 	all, err := m.FindDefinedServices(root)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	defaults, err := namespaceDefaults(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := loadPolicyFile(root)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	result := map[flux.ResourceID]policy.Set{}
+	sources := policy.SourceMap{}
 	err = iterateManifests(all, func(s flux.ResourceID, m Manifest) error {
-		ps, err := policiesFrom(m)
-		if err != nil {
-			return err
-		}
-		result[s] = ps
+		ns, _, _ := s.Components()
+		result[s], sources[s] = policy.Effective(map[policy.Source]policy.Set{
+			policy.SourceController: policiesFrom(m),
+			policy.SourcePolicyFile: file.policiesFor(s),
+			policy.SourceNamespace:  defaults[ns],
+		})
 		return nil
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, sources, nil
+}
+
+// InheritedPolicies returns the policies each service gets from the
+// namespace it's in, and from patterns in the policy file; that is,
+// those it would have without its own annotations or its own entry
+// in the policy file.
+func (m *Manifests) InheritedPolicies(root string) (policy.ResourceMap, error) {
+	all, err := m.FindDefinedServices(root)
 	if err != nil {
 		return nil, err
 	}
+	defaults, err := namespaceDefaults(root)
+	if err != nil {
+		return nil, err
+	}
+	file, err := loadPolicyFile(root)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[flux.ResourceID]policy.Set{}
+	for s := range all {
+		ns, _, _ := s.Components()
+		result[s], _ = policy.Effective(map[policy.Source]policy.Set{
+			policy.SourcePolicyFile: file.fromPatterns(s),
+			policy.SourceNamespace:  defaults[ns],
+		})
+	}
 	return result, nil
 }
 
-func iterateManifests(services map[flux.ResourceID][]string, f func(flux.ResourceID, Manifest) error) error {
-	for serviceID, paths := range services {
-		if len(paths) != 1 {
+// namespaceDefaults returns the policies given on each of the
+// namespaces defined under the directory given, by namespace name;
+// these are the defaults for the controllers in the namespace.
+func namespaceDefaults(root string) (map[string]policy.Set, error) {
+	objects, err := resource.Load(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading resources")
+	}
+	defaults := map[string]policy.Set{}
+	for _, obj := range objects {
+		if ns, ok := obj.(*resource.Namespace); ok {
+			defaults[ns.Meta.Name] = ns.Policy()
+		}
+	}
+	return defaults, nil
+}
+
+func iterateManifests(services map[flux.ResourceID][]cluster.ManifestLocation, f func(flux.ResourceID, Manifest) error) error {
+	files := map[string][]byte{}
+	for serviceID, locations := range services {
+		if len(locations) != 1 {
 			continue
 		}
 
-		def, err := ioutil.ReadFile(paths[0])
-		if err != nil {
-			return err
+		path := locations[0].Path
+		def, ok := files[path]
+		if !ok {
+			var err error
+			if def, err = ioutil.ReadFile(path); err != nil {
+				return err
+			}
+			files[path] = def
 		}
-		manifest, err := parseManifest(def)
+		manifest, err := parseManifest(def, serviceID)
 		if err != nil {
 			return err
 		}
@@ -191,21 +273,16 @@ func iterateManifests(services map[flux.ResourceID][]string, f func(flux.Resourc
 	return nil
 }
 
-func policiesFrom(m Manifest) (policy.Set, error) {
-	var policies policy.Set
+// policiesFrom returns the policies given in the annotations of a
+// manifest. Boolean policies keep whatever value they're given, so
+// that e.g., "false" can override a default (see policy.Inherit).
+func policiesFrom(m Manifest) policy.Set {
+	policies := policy.Set{}
 	for k, v := range m.Metadata.AnnotationsOrNil() {
 		if !strings.HasPrefix(k, resource.PolicyPrefix) {
 			continue
 		}
-		p := policy.Policy(strings.TrimPrefix(k, resource.PolicyPrefix))
-		if policy.Boolean(p) {
-			if v != "true" {
-				continue
-			}
-			policies = policies.Add(p)
-		} else {
-			policies = policies.Set(p, v)
-		}
+		policies[policy.Policy(strings.TrimPrefix(k, resource.PolicyPrefix))] = v
 	}
-	return policies, nil
+	return policies
 }
@@ -2,9 +2,14 @@ package kubernetes
 
 import (
 	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"text/template"
 
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster/kubernetes/testfiles"
 	"github.com/weaveworks/flux/policy"
 )
 
@@ -99,7 +104,7 @@ func TestUpdatePolicies(t *testing.T) {
 	} {
 		caseIn := templToString(t, annotationsTemplate, c.in)
 		caseOut := templToString(t, annotationsTemplate, c.out)
-		out, err := (&Manifests{}).UpdatePolicies([]byte(caseIn), c.update)
+		out, err := (&Manifests{}).UpdatePolicies([]byte(caseIn), flux.MustParseResourceID("default:deployment/nginx"), c.update)
 		if err != nil {
 			t.Errorf("[%s] %v", c.name, err)
 		} else if string(out) != caseOut {
@@ -108,6 +113,41 @@ func TestUpdatePolicies(t *testing.T) {
 	}
 }
 
+func TestUpdatePolicies_TagAllIncludesInitContainers(t *testing.T) {
+	in := `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: accounts
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: quay.io/weaveworks/accounts:v1
+      containers:
+      - name: accounts
+        image: quay.io/weaveworks/accounts:v1
+`
+	id := flux.MustParseResourceID("default:deployment/accounts")
+	out, err := (&Manifests{}).UpdatePolicies([]byte(in), id, policy.Update{
+		Add: policy.Set{policy.TagAll: "glob:master-*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := parseManifest(out, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, container := range []string{"migrate", "accounts"} {
+		key := "flux.weave.works/tag." + container
+		if v := manifest.Metadata.Annotations[key]; v != "glob:master-*" {
+			t.Errorf("expected %s to be %q, got %q", key, "glob:master-*", v)
+		}
+	}
+}
+
 var annotationsTemplate = template.Must(template.New("").Parse(`---
 apiVersion: extensions/v1beta1
 kind: Deployment
@@ -137,3 +177,128 @@ func templToString(t *testing.T, templ *template.Template, data interface{}) str
 	}
 	return out.String()
 }
+
+func TestServicesWithPolicies_NamespaceDefaults(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "payments.yaml"), []byte(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: payments
+  annotations:
+    flux.weave.works/automated: "true"
+    flux.weave.works/tag.web: glob:1.*
+    flux.weave.works/locked: "true"
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+  namespace: payments
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: worker
+  namespace: payments
+  annotations:
+    flux.weave.works/automated: "false"
+    flux.weave.works/tag.web: semver:~2
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+  namespace: other
+`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	services, sources, err := (&Manifests{}).ServicesWithPolicySources(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web := flux.MustParseResourceID("payments:deployment/web")
+	worker := flux.MustParseResourceID("payments:deployment/worker")
+	other := flux.MustParseResourceID("other:deployment/web")
+	for id, expected := range map[flux.ResourceID]policy.Set{
+		web:    policy.Set{policy.Automated: "true", policy.TagPrefix("web"): "glob:1.*"},
+		worker: policy.Set{policy.TagPrefix("web"): "semver:~2"},
+		other:  policy.Set{},
+	} {
+		if !reflect.DeepEqual(expected, services[id]) {
+			t.Errorf("%s: expected policies %v, got %v", id, expected, services[id])
+		}
+	}
+	if source := sources[web][policy.Automated]; source != policy.SourceNamespace {
+		t.Errorf("expected automated to come from the namespace, got %q", source)
+	}
+	if source := sources[worker][policy.TagPrefix("web")]; source != policy.SourceController {
+		t.Errorf("expected tag filter to come from the controller, got %q", source)
+	}
+}
+
+// Deautomating a controller that's automated by default, from its
+// namespace, needs to switch automation off rather than just remove
+// the controller's annotation.
+func TestUpdatePolicies_InheritedFromNamespace(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "payments.yaml")
+	if err := ioutil.WriteFile(path, []byte(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: payments
+  annotations:
+    flux.weave.works/automated: "true"
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+  namespace: payments
+  annotations:
+    flux.weave.works/automated: "true"
+`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manifests{}
+	web := flux.MustParseResourceID("payments:deployment/web")
+	inherited, err := m.InheritedPolicies(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inherited[web].Contains(policy.Automated) {
+		t.Fatalf("expected automated to be inherited from the namespace, got %v", inherited[web])
+	}
+
+	deautomate := policy.Update{Remove: policy.Set{}.Add(policy.Automated)}
+	def, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err = m.UpdatePolicies(def, web, deautomate.Overriding(inherited[web]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, def, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := m.ServicesWithPolicies(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if services[web].Contains(policy.Automated) {
+		t.Errorf("expected controller to be deautomated, got policies %v", services[web])
+	}
+	if !bytes.Contains(def, []byte(`flux.weave.works/automated: "false"`)) {
+		t.Errorf("expected automation to be switched off in the manifest, got:\n%s", def)
+	}
+}
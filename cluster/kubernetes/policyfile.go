@@ -0,0 +1,255 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+	"github.com/weaveworks/flux/cluster/kubernetes/yamledit"
+	"github.com/weaveworks/flux/policy"
+)
+
+// The policy file gives policies for resources, by resource ID or by
+// a glob pattern matching resource IDs, e.g.,
+//
+//     policies:
+//       default:deployment/helloworld:
+//         automated: "true"
+//         tag.greeter: glob:master-*
+//       payments:deployment/*:
+//         automated: "true"
+//
+// For each resource, the policies given for patterns it matches are
+// applied in the order they appear in the file, so a later pattern
+// overrides an earlier one; then the policies given for its ID, which
+// override them all.
+//
+// The ignore policy can't be given in the file, since syncing only
+// looks at the resources themselves; it has to be an annotation.
+
+const policyFileKey = "policies"
+
+var errIgnoreInPolicyFile = errors.New("ignore cannot be given in the policy file, since it is not consulted when syncing; annotate the resource instead")
+
+// policyFileEntry is the policies given in the policy file for a
+// resource ID or pattern.
+type policyFileEntry struct {
+	pattern  string
+	policies policy.Set
+}
+
+type policyFile []policyFileEntry
+
+// loadPolicyFile reads the policy file at the root of the manifests
+// given, if there is one.
+func loadPolicyFile(root string) (policyFile, error) {
+	def, err := ioutil.ReadFile(filepath.Join(root, cluster.PolicyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parsePolicyFile(def)
+}
+
+func parsePolicyFile(def []byte) (policyFile, error) {
+	doc, err := yamledit.Parse(def)
+	if errors.Cause(err) == yamledit.ErrNoDocument {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing policy file")
+	}
+	entries := doc.Root().Get(policyFileKey)
+	if entries.IsNull() {
+		return nil, nil
+	}
+	if !entries.IsMapping() {
+		return nil, errors.Errorf("parsing policy file: expected %q to be a mapping of resource IDs or patterns to policies", policyFileKey)
+	}
+	var file policyFile
+	for _, pattern := range entries.Keys() {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "parsing policy file: pattern %q", pattern)
+		}
+		policies := entries.Get(pattern)
+		if !policies.IsMapping() && !policies.IsNull() {
+			return nil, errors.Errorf("parsing policy file: expected the policies for %q to be a mapping", pattern)
+		}
+		entry := policyFileEntry{pattern: pattern, policies: policy.Set{}}
+		for _, p := range policies.Keys() {
+			value := policies.Get(p)
+			if !value.IsScalar() {
+				return nil, errors.Errorf("parsing policy file: expected the value of %q for %q to be a string", p, pattern)
+			}
+			if policy.Policy(p) == policy.Ignore {
+				return nil, errors.Wrapf(errIgnoreInPolicyFile, "parsing policy file: %q", pattern)
+			}
+			entry.policies[policy.Policy(p)] = value.Value()
+		}
+		file = append(file, entry)
+	}
+	return file, nil
+}
+
+// isPattern reports whether an entry in the policy file is a pattern,
+// rather than a resource ID.
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
+
+// policiesFor gives the policies from the policy file for the
+// resource given. As with annotations, boolean policies keep whatever
+// value they're given.
+func (f policyFile) policiesFor(id flux.ResourceID) policy.Set {
+	policies := policy.Set{}
+	for _, entry := range f {
+		if !isPattern(entry.pattern) {
+			continue
+		}
+		if ok, _ := path.Match(entry.pattern, id.String()); ok {
+			for p, v := range entry.policies {
+				policies[p] = v
+			}
+		}
+	}
+	for _, entry := range f {
+		if entry.pattern == id.String() {
+			for p, v := range entry.policies {
+				policies[p] = v
+			}
+		}
+	}
+	return policies
+}
+
+// fromPatterns gives the policies that the patterns in the policy
+// file give the resource, leaving out the entry for the resource
+// itself.
+func (f policyFile) fromPatterns(id flux.ResourceID) policy.Set {
+	var patterns policyFile
+	for _, entry := range f {
+		if isPattern(entry.pattern) {
+			patterns = append(patterns, entry)
+		}
+	}
+	return patterns.policiesFor(id)
+}
+
+// UpdatePolicyFile applies the policy update given to the entry for
+// the resource given in the policy file. Removing a boolean policy
+// that the resource would still get from a pattern sets it to
+// "false" instead, so that it's switched off.
+func (m *Manifests) UpdatePolicyFile(def []byte, id flux.ResourceID, update policy.Update) ([]byte, error) {
+	file, err := parsePolicyFile(def)
+	if err != nil {
+		return nil, err
+	}
+
+	current := policy.Set{}
+	for _, entry := range file {
+		if entry.pattern == id.String() {
+			current = entry.policies
+		}
+	}
+	fromPatterns := file.fromPatterns(id)
+	updated := policy.Set{}
+	for p, v := range current {
+		updated[p] = v
+	}
+	for p, v := range update.Add {
+		if p == policy.TagAll {
+			return nil, errors.New("tag_all cannot be given in the policy file; give a tag filter for each container instead")
+		}
+		if p == policy.Ignore {
+			return nil, errIgnoreInPolicyFile
+		}
+		updated[p] = v
+	}
+	for p := range update.Remove {
+		if v, ok := fromPatterns[p]; ok && policy.Boolean(p) && v == "true" {
+			updated[p] = "false"
+		} else {
+			delete(updated, p)
+		}
+	}
+
+	doc, err := yamledit.Parse(def)
+	if err != nil && errors.Cause(err) != yamledit.ErrNoDocument {
+		return nil, errors.Wrap(err, "parsing policy file")
+	}
+	var entries yamledit.Node
+	if doc != nil {
+		entries = doc.Root().Get(policyFileKey)
+	}
+	if !entries.IsMapping() {
+		// There's nothing to keep, besides perhaps comments, so
+		// start afresh
+		if doc != nil {
+			for _, k := range doc.Root().Keys() {
+				if k != policyFileKey {
+					return nil, errors.Errorf("updating policy file: expected only %q at the top level", policyFileKey)
+				}
+			}
+		}
+		if len(updated) == 0 {
+			return def, nil
+		}
+		return renderPolicyFile(id, updated), nil
+	}
+
+	entry := entries.Get(id.String())
+	switch {
+	case len(updated) == 0:
+		err = entries.Remove(id.String())
+	case entry.IsNull():
+		err = entries.SetMap(id.String(), updated.ToStringMap())
+	default:
+		var keys []string
+		for p := range updated {
+			keys = append(keys, string(p))
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err = entry.Set(k, updated[policy.Policy(k)]); err != nil {
+				break
+			}
+		}
+		for p := range current {
+			if _, ok := updated[p]; !ok && err == nil {
+				err = entry.Remove(string(p))
+			}
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "updating policy file")
+	}
+	return doc.Bytes()
+}
+
+// renderPolicyFile gives a new policy file, with an entry for just
+// the resource given.
+func renderPolicyFile(id flux.ResourceID, policies policy.Set) []byte {
+	var keys []string
+	for p := range policies {
+		keys = append(keys, string(p))
+	}
+	sort.Strings(keys)
+	buf := &bytes.Buffer{}
+	buf.WriteString(policyFileKey + ":\n")
+	fmt.Fprintf(buf, "  %q:\n", id.String())
+	for _, k := range keys {
+		fmt.Fprintf(buf, "    %s: %q\n", k, policies[policy.Policy(k)])
+	}
+	return buf.Bytes()
+}
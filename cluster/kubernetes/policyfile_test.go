@@ -0,0 +1,180 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+	"github.com/weaveworks/flux/cluster/kubernetes/testfiles"
+	"github.com/weaveworks/flux/policy"
+)
+
+const testPolicyFile = `# Policies for generated manifests
+policies:
+  "*:deployment/*":
+    automated: "true"
+  default:deployment/*:
+    tag.greeter: glob:master-*
+  default:deployment/helloworld:
+    # pinned for now
+    locked: "true"
+`
+
+func TestPolicyFilePrecedence(t *testing.T) {
+	file, err := parsePolicyFile([]byte(testPolicyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, expected := range map[string]policy.Set{
+		"default:deployment/helloworld": policy.Set{
+			policy.Automated:            "true",
+			policy.TagPrefix("greeter"): "glob:master-*",
+			policy.Locked:               "true",
+		},
+		"other:deployment/helloworld": policy.Set{
+			policy.Automated: "true",
+		},
+		"default:service/helloworld": policy.Set{},
+	} {
+		if got := file.policiesFor(flux.MustParseResourceID(id)); !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: expected %v, got %v", id, expected, got)
+		}
+	}
+}
+
+func TestPolicyFileIgnore(t *testing.T) {
+	if _, err := parsePolicyFile([]byte("policies:\n  default:deployment/helloworld:\n    ignore: \"true\"\n")); err == nil {
+		t.Error("expected error parsing policy file with ignore")
+	}
+	id := flux.MustParseResourceID("default:deployment/helloworld")
+	if _, err := (&Manifests{}).UpdatePolicyFile(nil, id, policy.Update{Add: policy.Set{policy.Ignore: "true"}}); err == nil {
+		t.Error("expected error adding ignore to policy file")
+	}
+}
+
+func TestUpdatePolicyFile(t *testing.T) {
+	helloworld := flux.MustParseResourceID("default:deployment/helloworld")
+	for _, c := range []struct {
+		name    string
+		id      flux.ResourceID
+		in, out string
+		update  policy.Update
+	}{
+		{
+			name: "new file",
+			id:   helloworld,
+			in:   "",
+			out: `policies:
+  "default:deployment/helloworld":
+    automated: "true"
+`,
+			update: policy.Update{Add: policy.Set{policy.Automated: "true"}},
+		},
+		{
+			name: "adding to an existing entry",
+			id:   helloworld,
+			in:   testPolicyFile,
+			out: `# Policies for generated manifests
+policies:
+  "*:deployment/*":
+    automated: "true"
+  default:deployment/*:
+    tag.greeter: glob:master-*
+  default:deployment/helloworld:
+    # pinned for now
+    locked: "true"
+    pin_digest: "true"
+`,
+			update: policy.Update{Add: policy.Set{policy.PinDigest: "true"}},
+		},
+		{
+			name: "new entry",
+			id:   flux.MustParseResourceID("default:deployment/sidecar"),
+			in:   testPolicyFile,
+			out: `# Policies for generated manifests
+policies:
+  "*:deployment/*":
+    automated: "true"
+  default:deployment/*:
+    tag.greeter: glob:master-*
+  default:deployment/helloworld:
+    # pinned for now
+    locked: "true"
+  default:deployment/sidecar:
+    tag.sidecar: semver:~1
+`,
+			update: policy.Update{Add: policy.Set{policy.TagPrefix("sidecar"): "semver:~1"}},
+		},
+		{
+			name: "removing the last policy removes the entry",
+			id:   helloworld,
+			in:   testPolicyFile,
+			out: `# Policies for generated manifests
+policies:
+  "*:deployment/*":
+    automated: "true"
+  default:deployment/*:
+    tag.greeter: glob:master-*
+`,
+			update: policy.Update{Remove: policy.Set{policy.Locked: "true"}},
+		},
+		{
+			name: "removing a policy given by a pattern switches it off",
+			id:   helloworld,
+			in:   testPolicyFile,
+			out: `# Policies for generated manifests
+policies:
+  "*:deployment/*":
+    automated: "true"
+  default:deployment/*:
+    tag.greeter: glob:master-*
+  default:deployment/helloworld:
+    automated: "false"
+    # pinned for now
+    locked: "true"
+`,
+			update: policy.Update{Remove: policy.Set{policy.Automated: "true"}},
+		},
+	} {
+		out, err := (&Manifests{}).UpdatePolicyFile([]byte(c.in), c.id, c.update)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if string(out) != c.out {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", c.name, c.out, out)
+		}
+	}
+}
+
+func TestServicesWithPolicies_PolicyFile(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	if err := testfiles.WriteTestFiles(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, cluster.PolicyFile), []byte(testPolicyFile), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	services, sources, err := (&Manifests{}).ServicesWithPolicySources(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helloworld := flux.MustParseResourceID("default:deployment/helloworld")
+	if !services[helloworld].Contains(policy.Automated) {
+		t.Errorf("expected helloworld to be automated, got %v", services[helloworld])
+	}
+	if source := sources[helloworld][policy.Automated]; source != policy.SourcePolicyFile {
+		t.Errorf("expected automated to come from the policy file, got %q", source)
+	}
+	// The annotation on the manifest takes precedence
+	locked := flux.MustParseResourceID("default:deployment/locked-service")
+	if source := sources[locked][policy.Locked]; source != policy.SourceController {
+		t.Errorf("expected locked to come from the controller, got %q", source)
+	}
+}
@@ -56,16 +56,14 @@ func ParseMultidoc(multidoc []byte, source string) (map[string]resource.Resource
 	chunks.Buffer(initialBuffer, 1024*1024) // Allow growth to 1MB
 	chunks.Split(splitYAMLDocument)
 
-	var obj resource.Resource
-	var err error
-	for chunks.Scan() {
-		if obj, err = unmarshalObject(source, chunks.Bytes()); err != nil {
+	for document := 0; chunks.Scan(); document++ {
+		docObjs, err := unmarshalDocument(source, document, chunks.Bytes())
+		if err != nil {
 			return nil, errors.Wrapf(err, "parsing YAML doc from %q", source)
 		}
-		if obj == nil {
-			continue
+		for _, obj := range docObjs {
+			objs[obj.ResourceID().String()] = obj
 		}
-		objs[obj.ResourceID().String()] = obj
 	}
 
 	if err := chunks.Err(); err != nil {
@@ -11,7 +11,7 @@ import (
 
 // for convenience
 func base(source, kind, namespace, name string) baseObject {
-	b := baseObject{source: source, Kind: kind}
+	b := baseObject{source: source, item: -1, Kind: kind}
 	b.Meta.Namespace = namespace
 	b.Meta.Name = name
 	return b
@@ -46,6 +46,7 @@ metadata:
 	}
 
 	objA := base("test", "Deployment", "", "a-deployment")
+	objA.document = 1
 	objB := base("test", "Deployment", "b-namespace", "b-deployment")
 	expected := map[string]resource.Resource{
 		objA.ResourceID().String(): &Deployment{baseObject: objA},
@@ -78,7 +79,9 @@ metadata:
 	}
 
 	objA := base("test", "Deployment", "", "a-deployment")
+	objA.document = 2
 	objB := base("test", "Deployment", "b-namespace", "b-deployment")
+	objB.document = 1
 	expected := map[string]resource.Resource{
 		objA.ResourceID().String(): &Deployment{baseObject: objA},
 		objB.ResourceID().String(): &Deployment{baseObject: objB},
@@ -97,6 +100,58 @@ metadata:
 	}
 }
 
+func TestParseList(t *testing.T) {
+	docs := `---
+kind: Service
+metadata:
+  name: a-service
+---
+kind: List
+items:
+- kind: Deployment
+  metadata:
+    name: a-deployment
+- kind: Deployment
+  metadata:
+    name: b-deployment
+    # in its own namespace
+    namespace: b-namespace
+`
+	objs, err := ParseMultidoc([]byte(docs), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 3 {
+		t.Errorf("expected 3 objects, got %#v", objs)
+	}
+
+	for id, pos := range map[string][2]int{
+		"default:service/a-service":           {0, -1},
+		"default:deployment/a-deployment":     {1, 0},
+		"b-namespace:deployment/b-deployment": {1, 1},
+	} {
+		obj, ok := objs[id]
+		if !ok {
+			t.Errorf("expected to find %s", id)
+			continue
+		}
+		document, item := obj.(interface {
+			Position() (int, int)
+		}).Position()
+		if document != pos[0] || item != pos[1] {
+			t.Errorf("%s: expected position %v, got [%d %d]", id, pos, document, item)
+		}
+	}
+	if _, ok := objs["default:deployment/a-deployment"].(*Deployment); !ok {
+		t.Errorf("expected List item to be parsed as a Deployment, got %#v", objs["default:deployment/a-deployment"])
+	}
+	// Items are synced as they're written in the List
+	expected := "kind: Deployment\nmetadata:\n  name: b-deployment\n  # in its own namespace\n  namespace: b-namespace\n"
+	if b := objs["b-namespace:deployment/b-deployment"].Bytes(); string(b) != expected {
+		t.Errorf("expected List item to keep its text:\n%s\ngot:\n%s", expected, b)
+	}
+}
+
 func TestParseSomeLong(t *testing.T) {
 	doc := `---
 kind: ConfigMap
@@ -6,6 +6,7 @@ import (
 	yaml "gopkg.in/yaml.v2"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster/kubernetes/yamledit"
 	fluxerr "github.com/weaveworks/flux/errors"
 	"github.com/weaveworks/flux/policy"
 	"github.com/weaveworks/flux/resource"
@@ -20,11 +21,15 @@ const (
 // struct to embed in objects, to provide default implementation
 type baseObject struct {
 	source string
-	bytes  []byte
-	Kind   string `yaml:"kind"`
-	Meta   struct {
+	// the index of the YAML document in the source, and of the item
+	// in the document if it's a List (or -1 if not)
+	document, item int
+	bytes          []byte
+	Kind           string `yaml:"kind"`
+	Meta           struct {
 		Namespace   string            `yaml:"namespace"`
 		Name        string            `yaml:"name"`
+		Labels      map[string]string `yaml:"labels,omitempty"`
 		Annotations map[string]string `yaml:"annotations,omitempty"`
 	} `yaml:"metadata"`
 }
@@ -58,22 +63,82 @@ func (o baseObject) Policy() policy.Set {
 	return set
 }
 
+func (o baseObject) Labels() map[string]string {
+	return o.Meta.Labels
+}
+
 func (o baseObject) Source() string {
 	return o.source
 }
 
+// Position returns the index of the YAML document in the source in
+// which the resource is defined, and its index among the items of the
+// document if that is a List (or -1 if not).
+func (o baseObject) Position() (document, item int) {
+	return o.document, o.item
+}
+
 func (o baseObject) Bytes() []byte {
 	return o.bytes
 }
 
-func unmarshalObject(source string, bytes []byte) (resource.Resource, error) {
-	var base = baseObject{source: source, bytes: bytes}
+// unmarshalDocument parses a YAML document, which may define a single
+// resource, or a List of resources.
+func unmarshalDocument(source string, document int, bytes []byte) ([]resource.Resource, error) {
+	var list struct {
+		Kind  string        `yaml:"kind"`
+		Items []interface{} `yaml:"items"`
+	}
+	if err := yaml.Unmarshal(bytes, &list); err != nil {
+		return nil, makeUnmarshalObjectErr(source, err)
+	}
+	if list.Kind != "List" {
+		obj, err := unmarshalObject(baseObject{source: source, document: document, item: -1}, bytes)
+		if err != nil || obj == nil {
+			return nil, err
+		}
+		return []resource.Resource{obj}, nil
+	}
+
+	// Each item keeps its text from the List, so that it's synced as
+	// written; only items that can't be cut out that way (e.g., those
+	// given as flow mappings) are marshalled afresh.
+	doc, err := yamledit.Parse(bytes)
+	if err != nil {
+		return nil, makeUnmarshalObjectErr(source, err)
+	}
+	itemNodes := doc.Root().Get("items").Items()
+
+	var objs []resource.Resource
+	for i, item := range list.Items {
+		var itemBytes []byte
+		if i < len(itemNodes) {
+			itemBytes, err = itemNodes[i].Text()
+		}
+		if itemBytes == nil {
+			if itemBytes, err = yaml.Marshal(item); err != nil {
+				return nil, err
+			}
+		}
+		obj, err := unmarshalObject(baseObject{source: source, document: document, item: i}, itemBytes)
+		if err != nil {
+			return nil, err
+		}
+		if obj != nil {
+			objs = append(objs, obj)
+		}
+	}
+	return objs, nil
+}
+
+func unmarshalObject(base baseObject, bytes []byte) (resource.Resource, error) {
+	base.bytes = bytes
 	if err := yaml.Unmarshal(bytes, &base); err != nil {
 		return nil, err
 	}
 	r, err := unmarshalKind(base, bytes)
 	if err != nil {
-		return nil, makeUnmarshalObjectErr(source, err)
+		return nil, makeUnmarshalObjectErr(base.source, err)
 	}
 	return r, nil
 }
@@ -46,6 +46,9 @@ func (pc podController) toClusterController(resourceID flux.ResourceID) cluster.
 	for _, container := range pc.podTemplate.Spec.Containers {
 		clusterContainers = append(clusterContainers, cluster.Container{Name: container.Name, Image: container.Image})
 	}
+	for _, container := range pc.podTemplate.Spec.InitContainers {
+		clusterContainers = append(clusterContainers, cluster.Container{Name: container.Name, Image: container.Image, Init: true})
+	}
 
 	return cluster.Controller{
 		ID:         resourceID,
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
 )
 
 func TempDir(t *testing.T) (string, func()) {
@@ -41,11 +42,14 @@ func WriteTestFiles(dir string) error {
 
 // ServiceMap ... given a base path, construct the map representing the services
 // given in the test data.
-func ServiceMap(dir string) map[flux.ResourceID][]string {
-	return map[flux.ResourceID][]string{
-		flux.MustParseResourceID("default:deployment/helloworld"):     []string{filepath.Join(dir, "helloworld-deploy.yaml")},
-		flux.MustParseResourceID("default:deployment/locked-service"): []string{filepath.Join(dir, "locked-service-deploy.yaml")},
-		flux.MustParseResourceID("default:deployment/test-service"):   []string{filepath.Join(dir, "test-service-deploy.yaml")},
+func ServiceMap(dir string) map[flux.ResourceID][]cluster.ManifestLocation {
+	location := func(file string) []cluster.ManifestLocation {
+		return []cluster.ManifestLocation{{Path: filepath.Join(dir, file), Item: -1}}
+	}
+	return map[flux.ResourceID][]cluster.ManifestLocation{
+		flux.MustParseResourceID("default:deployment/helloworld"):     location("helloworld-deploy.yaml"),
+		flux.MustParseResourceID("default:deployment/locked-service"): location("locked-service-deploy.yaml"),
+		flux.MustParseResourceID("default:deployment/test-service"):   location("test-service-deploy.yaml"),
 	}
 }
 
@@ -54,6 +58,8 @@ var Files = map[string]string{
 kind: Deployment
 metadata:
   name: helloworld
+  labels:
+    team: greetings
 spec:
   minReadySeconds: 1
   replicas: 5
@@ -82,6 +88,8 @@ metadata:
   annotations:
     flux.weave.works/locked: "true"
   name: locked-service
+  labels:
+    team: greetings
 spec:
   minReadySeconds: 1
   replicas: 5
@@ -125,6 +133,8 @@ var FilesUpdated = map[string]string{
 kind: Deployment
 metadata:
   name: helloworld
+  labels:
+    team: greetings
 spec:
   minReadySeconds: 1
   replicas: 5
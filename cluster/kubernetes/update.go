@@ -1,202 +1,178 @@
 package kubernetes
 
 import (
-	"bytes"
 	"fmt"
 	"io"
-	"regexp"
 	"strings"
 
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster/kubernetes/yamledit"
 	"github.com/weaveworks/flux/image"
 )
 
-// updatePodController takes the body of a Deployment resource definition
-// (specified in YAML) and the name of the new image that should be put in the
-// definition (in the format "repo.org/group/name:tag"). It returns a new
-// resource definition body where all references to the old image have been
-// replaced with the new one.
+// updatePodController takes the bytes of a manifest file, which may
+// have several resource definitions in it, and updates the image used
+// by the named container in the definition of the resource given.
+// Everything else in the file is left as it was.
 //
-// This function has many additional requirements that are likely in flux. Read
-// the source to learn about them.
-func updatePodController(def []byte, container string, newImageID image.Ref) ([]byte, error) {
-	// Sanity check
-	obj, err := definitionObj(def)
+// See updateImage for exactly what is changed.
+func updatePodController(def []byte, id flux.ResourceID, container string, newImageID image.Ref) ([]byte, error) {
+	doc, resource, err := findResource(def, id)
 	if err != nil {
 		return nil, err
 	}
 
-	if _, ok := resourceKinds[strings.ToLower(obj.Kind)]; !ok {
-		return nil, UpdateNotSupportedError(obj.Kind)
+	if kind := resource.Get("kind").Value(); !isResourceKind(kind) {
+		return nil, UpdateNotSupportedError(kind)
 	}
 
-	var buf bytes.Buffer
-	err = tryUpdate(def, container, newImageID, &buf)
-	return buf.Bytes(), err
+	if err := updateImage(resource, container, newImageID); err != nil {
+		return nil, err
+	}
+	return doc.Bytes()
 }
 
-// Attempt to update an RC or Deployment config. This makes several assumptions
-// that are justified only with the phrase "because that's how we do it",
-// including:
-//
-//  * the file is a replication controller or deployment
-//  * the update is from one tag of an image to another tag of the
-//    same image; e.g., "weaveworks/helloworld:a00001" to
-//    "weaveworks/helloworld:a00002"
-//  * the container spec to update is the (first) one that uses the
-//    same image name (e.g., weaveworks/helloworld)
-//  * the name of the controller is updated to reflect the new tag
-//  * there's a label which must be updated in both the pod spec and the selector
-//  * the file uses canonical YAML syntax, that is, one line per item
-//  * ... other assumptions as encoded in the regular expressions used
-//
-// Here's an example of the assumed structure:
-//
-// ```
-// apiVersion: v1
-// kind: Deployment # not presently checked
-// metadata:                         # )
-//   ...                             # ) any number of equally-indented lines
-//   name: helloworld-master-a000001 # ) can precede the name
-// spec:
-//   replicas: 2
-//   selector:                 # )
-//     name: helloworld        # ) this use of labels is assumed
-//     version: master-a000001 # )
-//   template:
-//     metadata:
-//       labels:                   # )
-//         name: helloworld        # ) this structure is assumed, as for the selector
-//         version: master-a000001 # )
-//     spec:
-//       containers:
-//       # extra container specs are allowed here ...
-//       - name: helloworld                                    # )
-//         image: quay.io/weaveworks/helloworld:master-a000001 # ) these must be together
-//         args:
-//         - -msg=Ahoy
-//         ports:
-//         - containerPort: 80
-// ```
+func isResourceKind(kind string) bool {
+	_, ok := resourceKinds[strings.ToLower(kind)]
+	return ok
+}
+
+// findResource finds the definition of a resource in the bytes of a
+// manifest file. The file may have several YAML documents in it, and
+// each may be a single resource or a List of resources. The document
+// with the resource is returned too, so edits to the resource can be
+// written out.
+func findResource(def []byte, id flux.ResourceID) (*yamledit.Document, yamledit.Node, error) {
+	docs, err := yamledit.ParseAll(def)
+	if err != nil {
+		return nil, yamledit.Node{}, err
+	}
+	for _, doc := range docs {
+		root := doc.Root()
+		resources := []yamledit.Node{root}
+		if root.Get("kind").Value() == "List" {
+			resources = root.Get("items").Items()
+		}
+		for _, r := range resources {
+			if resourceIDOf(r) == id {
+				return doc, r, nil
+			}
+		}
+	}
+	return nil, yamledit.Node{}, fmt.Errorf("could not find definition of %s", id)
+}
+
+func resourceIDOf(r yamledit.Node) flux.ResourceID {
+	namespace := r.Get("metadata", "namespace").Value()
+	if namespace == "" {
+		namespace = "default"
+	}
+	return flux.MakeResourceID(namespace, r.Get("kind").Value(), r.Get("metadata", "name").Value())
+}
+
+// tryUpdate updates the image used by the named container in the
+// resource defined by the (first) YAML document given.
 func tryUpdate(def []byte, container string, newImage image.Ref, out io.Writer) error {
-	manifest, err := parseManifest(def)
+	doc, err := yamledit.Parse(def)
 	if err != nil {
 		return err
 	}
-	if manifest.Metadata.Name == "" {
+	if err := updateImage(doc.Root(), container, newImage); err != nil {
+		return err
+	}
+	newDef, err := doc.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(newDef)
+	return err
+}
+
+// updateImage updates the image used by the named container in a
+// controller's resource definition. The definition is edited in
+// place, so formatting, comments and the order of keys are preserved;
+// only these values are changed:
+//
+//  * the image of each container (or init container) with the given
+//    name, that uses the same image repository as the new image
+//  * the name of the controller, if it ends with the old image tag
+//    (as is the custom for replication controllers)
+//  * a `version` label in the selector or the pod template, if it's
+//    the same as the old image tag
+//
+// Pod templates are looked for under `spec.template` and (for cron
+// jobs) `spec.jobTemplate.spec.template`.
+func updateImage(root yamledit.Node, container string, newImage image.Ref) error {
+	name := root.Get("metadata", "name")
+	if name.Value() == "" {
 		return fmt.Errorf("could not find resource name")
 	}
 
-	// Check if any containers need updating. As we go through, we calculate the
-	// new manifest name, in case it includes the image tag (as in replication
-	// controllers).
-	newDefName := manifest.Metadata.Name
-	matchingContainers := map[int]Container{}
-	for i, c := range append(manifest.Spec.Template.Spec.Containers, manifest.Spec.JobTemplate.Spec.Template.Spec.Containers...) {
-		if c.Name != container {
+	var (
+		matched bool
+		oldTag  string
+	)
+	for _, c := range containerNodes(root) {
+		if c.Get("name").Value() != container {
 			continue
 		}
-		currentImage, err := image.ParseRef(c.Image)
+		imageNode := c.Get("image")
+		currentImage, err := image.ParseRef(imageNode.Value())
 		if err != nil {
-			return fmt.Errorf("could not parse image %s", c.Image)
+			return fmt.Errorf("could not parse image %s", imageNode.Value())
 		}
-		if currentImage.CanonicalName() == newImage.CanonicalName() {
-			matchingContainers[i] = c
+		if currentImage.CanonicalName() != newImage.CanonicalName() {
+			continue
 		}
-		_, _, oldImageTag := currentImage.Components()
-		if strings.HasSuffix(manifest.Metadata.Name, oldImageTag) {
-			newDefName = manifest.Metadata.Name[:len(manifest.Metadata.Name)-len(oldImageTag)] + newImage.Tag
+		if err := imageNode.SetValue(newImage.String()); err != nil {
+			return errors.Wrapf(err, "updating image of container %s", container)
 		}
+		matched = true
+		oldTag = currentImage.Tag
 	}
-
-	// Some values (most likely the version) will be interpreted as a
-	// number if unquoted; while, on the other hand, it is apparently
-	// not OK to quote things that don't look like numbers. So: we
-	// extract values *without* quotes, and add them if necessary.
-	newDefName = maybeQuote(newDefName)
-
-	if len(matchingContainers) == 0 {
+	if !matched {
 		return fmt.Errorf("could not find container using image: %s", newImage.Repository())
 	}
 
-	// Detect how indented the "containers" block is.
-	newDef := string(def)
-	matches := regexp.MustCompile(`( +)containers:.*`).FindStringSubmatch(newDef)
-	if len(matches) != 2 {
-		return fmt.Errorf("could not find container specs")
-	}
-	indent := matches[1]
-
-	// Replace the container images
-	// Parse out all the container blocks
-	containersRE := regexp.MustCompile(`(?m:^` + indent + `containers:\s*(?:#.*)*$(?:\n(?:` + indent + `[-\s#].*)?)*)`)
-	// Parse out an individual container blog
-	containerRE := regexp.MustCompile(`(?m:` + indent + `-.*(?:\n(?:` + indent + `\s+.*)?)*)`)
-	// Parse out the image ID
-	imageRE := regexp.MustCompile(`(` + indent + `[-\s]\s*"?image"?:\s*)"?(?:[\w\.\-/:]+\s*?)*"?([\t\f #]+.*)?`)
-	imageReplacement := fmt.Sprintf("${1}%s${2}", maybeQuote(newImage.String()))
-	// Find the block of container specs
-	newDef = containersRE.ReplaceAllStringFunc(newDef, func(containers string) string {
-		i := 0
-		// Find each container spec
-		return containerRE.ReplaceAllStringFunc(containers, func(spec string) string {
-			if _, ok := matchingContainers[i]; ok {
-				// container matches, let's replace the image
-				spec = imageRE.ReplaceAllString(spec, imageReplacement)
-				delete(matchingContainers, i)
+	// Some values (most likely the version) will be interpreted as a
+	// number if unquoted; while, on the other hand, it is apparently
+	// not OK to quote things that don't look like numbers. So these
+	// are written quoted only if necessary.
+	if oldTag != "" && oldTag != newImage.Tag {
+		if defName := name.Value(); strings.HasSuffix(defName, oldTag) {
+			if err := name.SetPlainValue(defName[:len(defName)-len(oldTag)] + newImage.Tag); err != nil {
+				return errors.Wrap(err, "updating resource name")
 			}
-			i++
-			return spec
-		})
-	})
-
-	if len(matchingContainers) > 0 {
-		missed := []string{}
-		for _, c := range matchingContainers {
-			missed = append(missed, c.Name)
 		}
-		return fmt.Errorf("did not update expected containers: %s", strings.Join(missed, ", "))
-	}
-
-	// The name we want is that under `metadata:`, which will *probably* be the first one
-	replacedName := false
-	replaceRCNameRE := regexp.MustCompile(`(\s+"?name"?:\s*)"?(?:[\w\.\-/:]+\s*?)"?([\t\f #]+.*)`)
-	replaceRCNameRE.ReplaceAllStringFunc(newDef, func(found string) string {
-		if replacedName {
-			return found
+		for _, labels := range []yamledit.Node{
+			root.Get("spec", "selector"),
+			root.Get("spec", "selector", "matchLabels"),
+			root.Get("spec", "template", "metadata", "labels"),
+		} {
+			if version := labels.Get("version"); version.IsScalar() && version.Value() == oldTag {
+				if err := version.SetPlainValue(newImage.Tag); err != nil {
+					return errors.Wrap(err, "updating version label")
+				}
+			}
 		}
-		replacedName = true
-		return replaceRCNameRE.ReplaceAllString(found, fmt.Sprintf(`${1}%s${2}`, newDefName))
-	})
-
-	// Replacing labels: these are in two places, the container template and the selector
-	// TODO: This doesn't handle # comments
-	// TODO: This encodes an expectation of map keys being ordered (i.e. name *then* version)
-	// TODO: This assumes that these are indented by exactly 2 spaces (which may not be true)
-	replaceLabelsRE := multilineRE(
-		`((?:  selector|      labels):.*)`,
-		`((?:  ){2,4}name:.*)`,
-		`((?:  ){2,4}version:\s*) (?:"?[-\w]+"?)(\s.*)`,
-	)
-	replaceLabels := fmt.Sprintf("$1\n$2\n$3 %s$4", maybeQuote(newImage.Tag))
-	newDef = replaceLabelsRE.ReplaceAllString(newDef, replaceLabels)
+	}
 
-	fmt.Fprint(out, newDef)
 	return nil
 }
 
-func multilineRE(lines ...string) *regexp.Regexp {
-	return regexp.MustCompile(`(?m:^` + strings.Join(lines, "\n") + `$)`)
-}
-
-var looksLikeNumber *regexp.Regexp = regexp.MustCompile("^(" + strings.Join([]string{
-	`(-?[1-9](\.[0-9]*[1-9])?(e[-+][1-9][0-9]*)?)`,
-	`(-?(0|[1-9][0-9]*))`,
-	`(0|(\.inf)|(-\.inf)|(\.nan))`},
-	"|") + ")$")
-
-func maybeQuote(scalar string) string {
-	if looksLikeNumber.MatchString(scalar) {
-		return `"` + scalar + `"`
+// containerNodes returns the containers and init containers in the
+// pod templates of a resource definition.
+func containerNodes(resource yamledit.Node) []yamledit.Node {
+	var containers []yamledit.Node
+	for _, podSpec := range []yamledit.Node{
+		resource.Get("spec", "template", "spec"),
+		resource.Get("spec", "jobTemplate", "spec", "template", "spec"),
+	} {
+		for _, key := range []string{containersKey, initContainersKey} {
+			containers = append(containers, podSpec.Get(key).Items()...)
+		}
 	}
-	return scalar
+	return containers
 }
@@ -49,6 +49,8 @@ func TestUpdates(t *testing.T) {
 		{"minimal dockerhub image name", case5container, case5image, case5, case5out},
 		{"reordered keys", case6containers, case6image, case6, case6out},
 		{"from prod", case7containers, case7image, case7, case7out},
+		{"init container", case8containers, case8image, case8, case8out},
+		{"container using same image as init container", case9containers, case9image, case8, case9out},
 	} {
 		testUpdate(t, c)
 	}
@@ -534,3 +536,83 @@ spec:
         - name: FLUENTD_CONF
           value: fluent.conf
 `
+
+const case8 = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: accounts
+spec:
+  replicas: 1
+  template:
+    metadata:
+      labels:
+        name: accounts
+    spec:
+      initContainers:
+      - name: migrate
+        image: quay.io/weaveworks/accounts:v1
+        args:
+        - -migrate
+      containers:
+      - name: accounts
+        image: quay.io/weaveworks/accounts:v1
+        ports:
+        - containerPort: 80
+`
+
+const case8image = "quay.io/weaveworks/accounts:v2"
+
+var case8containers = []string{"migrate"}
+
+const case8out = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: accounts
+spec:
+  replicas: 1
+  template:
+    metadata:
+      labels:
+        name: accounts
+    spec:
+      initContainers:
+      - name: migrate
+        image: quay.io/weaveworks/accounts:v2
+        args:
+        - -migrate
+      containers:
+      - name: accounts
+        image: quay.io/weaveworks/accounts:v1
+        ports:
+        - containerPort: 80
+`
+
+const case9image = "quay.io/weaveworks/accounts:v2"
+
+var case9containers = []string{"accounts"}
+
+const case9out = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: accounts
+spec:
+  replicas: 1
+  template:
+    metadata:
+      labels:
+        name: accounts
+    spec:
+      initContainers:
+      - name: migrate
+        image: quay.io/weaveworks/accounts:v1
+        args:
+        - -migrate
+      containers:
+      - name: accounts
+        image: quay.io/weaveworks/accounts:v2
+        ports:
+        - containerPort: 80
+`
@@ -0,0 +1,870 @@
+// Package yamledit edits YAML documents in place. A document is parsed
+// into a node tree, which is used to find the values to change; the
+// changes are then made to the original text, so that everything else
+// -- indentation, comments, quoting, key order -- is left exactly as it
+// was.
+package yamledit
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+var (
+	ErrNoDocument  = errors.New("no YAML document found")
+	ErrNotMapping  = errors.New("not a mapping")
+	ErrNotScalar   = errors.New("not a scalar value")
+	ErrUnsupported = errors.New("unsupported YAML construct for editing")
+	ErrOverlapping = errors.New("overlapping edits")
+)
+
+const defaultIndent = 2
+
+// Document is a parsed YAML document, along with the edits made to
+// it so far.
+type Document struct {
+	src     []byte
+	lines   []int // offset of the start of each line
+	newline string
+	indent  int // the number of spaces used to indent nested mappings
+	root    *yaml.Node
+	edits   []edit
+	flows   []*flowMapping
+}
+
+// An edit replaces the bytes from start to end with text.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// Parse parses the first document in the YAML given. Any further
+// documents are left untouched by edits.
+func Parse(src []byte) (*Document, error) {
+	docs, err := parseDocuments(src, 1)
+	if err != nil {
+		return nil, err
+	}
+	return docs[0], nil
+}
+
+// ParseAll parses all the documents in the YAML given. The documents
+// each keep their own edits, and the text of each (as returned from
+// Bytes) is the whole of the YAML given with only that document's
+// edits applied.
+func ParseAll(src []byte) ([]*Document, error) {
+	return parseDocuments(src, 0)
+}
+
+func parseDocuments(src []byte, max int) ([]*Document, error) {
+	lines := []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	newline := "\n"
+	if bytes.Contains(src, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+
+	var docs []*Document
+	decoder := yaml.NewDecoder(bytes.NewReader(src))
+	for max <= 0 || len(docs) < max {
+		var n yaml.Node
+		if err := decoder.Decode(&n); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrap(err, "parsing YAML")
+		}
+		doc := &Document{src: src, lines: lines, newline: newline}
+		if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+			doc.root = n.Content[0]
+		}
+		doc.indent = detectIndent(doc.root)
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		return nil, ErrNoDocument
+	}
+	return docs, nil
+}
+
+// detectIndent finds how far nested block mappings are indented,
+// by looking for the first one in the document.
+func detectIndent(n *yaml.Node) int {
+	if n == nil {
+		return defaultIndent
+	}
+	if n.Kind == yaml.MappingNode && n.Style&yaml.FlowStyle == 0 {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if v.Kind == yaml.MappingNode && v.Style&yaml.FlowStyle == 0 && len(v.Content) > 0 && v.Content[0].Line > k.Line {
+				return v.Content[0].Column - k.Column
+			}
+		}
+	}
+	for _, c := range n.Content {
+		if c.Kind == yaml.MappingNode || c.Kind == yaml.SequenceNode {
+			if indent := detectIndent(c); indent != defaultIndent {
+				return indent
+			}
+		}
+	}
+	return defaultIndent
+}
+
+// Root returns the top-level node of the document.
+func (d *Document) Root() Node {
+	return Node{doc: d, node: d.root}
+}
+
+// Bytes returns the text of the document with all the edits so far
+// applied.
+func (d *Document) Bytes() ([]byte, error) {
+	edits := append([]edit{}, d.edits...)
+	for _, f := range d.flows {
+		e, err := f.edit()
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start == edits[j].start {
+			return edits[i].end < edits[j].end
+		}
+		return edits[i].start < edits[j].start
+	})
+
+	var buf bytes.Buffer
+	pos := 0
+	for _, e := range edits {
+		if e.start < pos {
+			return nil, ErrOverlapping
+		}
+		buf.Write(d.src[pos:e.start])
+		buf.WriteString(e.text)
+		pos = e.end
+	}
+	buf.Write(d.src[pos:])
+	return buf.Bytes(), nil
+}
+
+// offset gives the byte offset of a node's line and (character)
+// column in the source.
+func (d *Document) offset(line, column int) int {
+	if line < 1 || line > len(d.lines) {
+		return len(d.src)
+	}
+	off := d.lines[line-1]
+	for i := 1; i < column && off < len(d.src); i++ {
+		_, size := utf8.DecodeRune(d.src[off:])
+		off += size
+	}
+	return off
+}
+
+// lineEnd gives the offset just after the end of the line (i.e.,
+// including the newline), given a zero-based line index.
+func (d *Document) lineEnd(line int) int {
+	if line+1 < len(d.lines) {
+		return d.lines[line+1]
+	}
+	return len(d.src)
+}
+
+func (d *Document) lineText(line int) string {
+	return strings.TrimRight(string(d.src[d.lines[line]:d.lineEnd(line)]), "\r\n")
+}
+
+func (d *Document) addEdit(start, end int, text string) {
+	d.edits = append(d.edits, edit{start, end, text})
+}
+
+// Node is a node in a document, through which the document can be
+// edited. The zero value represents a node that doesn't exist.
+type Node struct {
+	doc  *Document
+	node *yaml.Node
+	// whether this node is inside a flow collection, where commas
+	// and brackets end plain scalars
+	inFlow bool
+}
+
+// Exists reports whether the node is present in the document.
+func (n Node) Exists() bool {
+	return n.node != nil
+}
+
+// resolved follows an alias to the node it refers to.
+func (n Node) resolved() *yaml.Node {
+	if n.node != nil && n.node.Kind == yaml.AliasNode {
+		return n.node.Alias
+	}
+	return n.node
+}
+
+func (n Node) IsMapping() bool {
+	r := n.resolved()
+	return r != nil && r.Kind == yaml.MappingNode
+}
+
+func (n Node) IsSequence() bool {
+	r := n.resolved()
+	return r != nil && r.Kind == yaml.SequenceNode
+}
+
+func (n Node) IsScalar() bool {
+	r := n.resolved()
+	return r != nil && r.Kind == yaml.ScalarNode
+}
+
+// IsNull reports whether the node is absent, or a null value.
+func (n Node) IsNull() bool {
+	r := n.resolved()
+	return r == nil || (r.Kind == yaml.ScalarNode && r.Tag == "!!null")
+}
+
+// Value returns the value of a scalar node, or the empty string for
+// anything else.
+func (n Node) Value() string {
+	if r := n.resolved(); r != nil && r.Kind == yaml.ScalarNode {
+		return r.Value
+	}
+	return ""
+}
+
+// Decode decodes the node into the value given, as yaml.Unmarshal
+// would.
+func (n Node) Decode(v interface{}) error {
+	if n.node == nil {
+		return nil
+	}
+	return n.node.Decode(v)
+}
+
+func (n Node) child(c *yaml.Node) Node {
+	return Node{doc: n.doc, node: c, inFlow: n.inFlow || n.resolved().Style&yaml.FlowStyle != 0}
+}
+
+// Get follows the path of keys given through nested mappings, and
+// returns the node found, which will not exist if any of the keys
+// are missing.
+func (n Node) Get(path ...string) Node {
+	for _, key := range path {
+		i := n.index(key)
+		if i < 0 {
+			return Node{doc: n.doc}
+		}
+		n = n.child(n.resolved().Content[i+1])
+	}
+	return n
+}
+
+// index returns the index of the key in a mapping's content, or -1.
+func (n Node) index(key string) int {
+	if !n.IsMapping() {
+		return -1
+	}
+	content := n.resolved().Content
+	for i := 0; i+1 < len(content); i += 2 {
+		if content[i].Kind == yaml.ScalarNode && content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Keys returns the keys of a mapping, in order.
+func (n Node) Keys() []string {
+	if !n.IsMapping() {
+		return nil
+	}
+	var keys []string
+	content := n.resolved().Content
+	for i := 0; i+1 < len(content); i += 2 {
+		keys = append(keys, content[i].Value)
+	}
+	return keys
+}
+
+// Items returns the items in a sequence.
+func (n Node) Items() []Node {
+	if !n.IsSequence() {
+		return nil
+	}
+	var items []Node
+	for _, c := range n.resolved().Content {
+		items = append(items, n.child(c))
+	}
+	return items
+}
+
+// Text returns the original text of a block mapping, with the
+// indentation of its keys removed, so it can stand as a document by
+// itself. This is used to get the text of a sequence item.
+func (n Node) Text() ([]byte, error) {
+	m := n.resolved()
+	if m == nil || m.Kind != yaml.MappingNode || m.Style&yaml.FlowStyle != 0 || len(m.Content) == 0 {
+		return nil, errors.Wrap(ErrUnsupported, "getting text of anything but a block mapping")
+	}
+	d := n.doc
+	indent := m.Column - 1
+
+	// The mapping goes on for as long as lines are indented at least
+	// as far as its keys; blank lines and comments in between don't
+	// end it.
+	last := m.Line - 1
+	for line := m.Line; line < len(d.lines); line++ {
+		text := d.lineText(line)
+		if trimmed := strings.TrimSpace(text); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if len(text)-len(strings.TrimLeft(text, " ")) < indent {
+			break
+		}
+		last = line
+	}
+
+	var buf bytes.Buffer
+	buf.Write(d.src[d.offset(m.Line, m.Column):d.lineEnd(m.Line-1)])
+	for line := m.Line; line <= last; line++ {
+		text := d.src[d.lines[line]:d.lineEnd(line)]
+		if len(bytes.TrimLeft(text, " ")) < len(text)-indent {
+			text = text[indent:]
+		} else {
+			text = bytes.TrimLeft(text, " ")
+		}
+		buf.Write(text)
+	}
+	return buf.Bytes(), nil
+}
+
+// SetValue replaces the value of a scalar node, keeping its quoting
+// style if possible.
+func (n Node) SetValue(value string) error {
+	return n.setValue(value, true)
+}
+
+// SetPlainValue replaces the value of a scalar node, quoting it only
+// if it needs to be quoted to be read back as a string.
+func (n Node) SetPlainValue(value string) error {
+	return n.setValue(value, false)
+}
+
+func (n Node) setValue(value string, keepStyle bool) error {
+	if n.node == nil || (n.node.Kind != yaml.ScalarNode && n.node.Kind != yaml.AliasNode) {
+		return ErrNotScalar
+	}
+	start, end, err := n.doc.scalarExtent(n.node, n.inFlow)
+	if err != nil {
+		return err
+	}
+	style := n.node.Style
+	if !keepStyle {
+		style = 0
+	}
+	text := renderScalar(value, style, n.inFlow)
+	// An empty value directly follows the colon; leave a space.
+	if start == end && start > 0 && n.doc.src[start-1] == ':' {
+		text = " " + text
+	}
+	n.doc.addEdit(start, end, text)
+	return nil
+}
+
+// Set sets the value of a key in a mapping to the string given,
+// adding the key if it's not there already.
+func (n Node) Set(key, value string) error {
+	if !n.IsMapping() {
+		return ErrNotMapping
+	}
+	if i := n.index(key); i >= 0 {
+		v := n.child(n.resolved().Content[i+1])
+		if f := n.doc.flowMapping(n.resolved()); f != nil {
+			return f.set(key, renderScalar(value, v.node.Style, true))
+		}
+		return v.SetValue(value)
+	}
+	return n.insert(key, []string{renderScalar(key, 0, n.isFlow()) + ": " + renderScalar(value, 0, n.isFlow())})
+}
+
+// SetMap sets the value of a key in a mapping to a (block) mapping of
+// the strings given, adding the key if it's not there already. If the
+// key is already there, it must have a null value.
+func (n Node) SetMap(key string, values map[string]string) error {
+	if !n.IsMapping() {
+		return ErrNotMapping
+	}
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if n.isFlow() {
+		var entries []string
+		for _, k := range keys {
+			entries = append(entries, renderScalar(k, 0, true)+": "+renderScalar(values[k], 0, true))
+		}
+		flow := "{" + strings.Join(entries, ", ") + "}"
+		if i := n.index(key); i >= 0 {
+			return n.doc.flowMapping(n.resolved()).set(key, flow)
+		}
+		return n.insert(key, []string{renderScalar(key, 0, true) + ": " + flow})
+	}
+
+	lines := []string{renderScalar(key, 0, false) + ":"}
+	for _, k := range keys {
+		lines = append(lines, strings.Repeat(" ", n.doc.indent)+renderScalar(k, 0, false)+": "+renderScalar(values[k], 0, false))
+	}
+	if i := n.index(key); i >= 0 {
+		if !n.child(n.resolved().Content[i+1]).IsNull() {
+			return errors.Wrapf(ErrUnsupported, "replacing non-null value of %q with a mapping", key)
+		}
+		start, end, err := n.entryExtent(i)
+		if err != nil {
+			return err
+		}
+		if n.doc.lines[lineOf(n.doc, start)] != start {
+			return errors.Wrapf(ErrUnsupported, "replacing %q when it's not at the start of a line", key)
+		}
+		indent := strings.Repeat(" ", n.resolved().Content[i].Column-1)
+		n.doc.addEdit(start, end, indentLines(lines, indent, n.doc.newline))
+		return nil
+	}
+	return n.insert(key, lines)
+}
+
+// Remove removes a key (and its value) from a mapping. It's not an
+// error if the key is not present.
+func (n Node) Remove(key string) error {
+	if !n.IsMapping() {
+		return ErrNotMapping
+	}
+	i := n.index(key)
+	if i < 0 {
+		return nil
+	}
+	if f := n.doc.flowMapping(n.resolved()); f != nil {
+		return f.remove(key)
+	}
+	start, end, err := n.entryExtent(i)
+	if err != nil {
+		return err
+	}
+	n.doc.addEdit(start, end, "")
+	return nil
+}
+
+func (n Node) isFlow() bool {
+	return n.resolved().Style&yaml.FlowStyle != 0
+}
+
+// insert adds a new entry to a mapping. The first line given is the
+// key, and any following lines are the (already indented) value. To
+// keep mappings that are sorted sorted, the entry goes before the
+// first key that sorts after it; otherwise, at the end.
+func (n Node) insert(key string, lines []string) error {
+	m := n.resolved()
+	if f := n.doc.flowMapping(m); f != nil {
+		return f.insert(key, strings.Join(lines, " "))
+	}
+	if len(m.Content) == 0 {
+		return errors.Wrap(ErrUnsupported, "inserting into empty block mapping")
+	}
+	indent := strings.Repeat(" ", m.Content[0].Column-1)
+
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		k := m.Content[i]
+		if k.Value <= key {
+			continue
+		}
+		start := n.doc.offset(k.Line, k.Column)
+		line := k.Line - 1
+		if strings.TrimSpace(string(n.doc.src[n.doc.lines[line]:start])) != "" {
+			// e.g., the first key in a sequence item; can't go before it
+			continue
+		}
+		// Comments directly above a key are taken to belong to it
+		for line > 0 && strings.HasPrefix(n.doc.lineText(line-1), indent+"#") {
+			line--
+		}
+		lineStart := n.doc.lines[line]
+		n.doc.addEdit(lineStart, lineStart, indentLines(lines, indent, n.doc.newline))
+		return nil
+	}
+
+	_, end, err := n.entryExtent(len(m.Content) - 2)
+	if err != nil {
+		return err
+	}
+	text := indentLines(lines, indent, n.doc.newline)
+	if end > 0 && n.doc.src[end-1] != '\n' {
+		text = n.doc.newline + text
+	}
+	n.doc.addEdit(end, end, text)
+	return nil
+}
+
+func indentLines(lines []string, indent, newline string) string {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(indent)
+		buf.WriteString(line)
+		buf.WriteString(newline)
+	}
+	return buf.String()
+}
+
+func lineOf(d *Document, offset int) int {
+	return sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+}
+
+// entryExtent gives the range of text taken up by the entry at index
+// i in a block mapping's content; that is, from the start of the line
+// with the key, to the end of the last line belonging to the value.
+func (n Node) entryExtent(i int) (int, int, error) {
+	d := n.doc
+	m := n.resolved()
+	key, value := m.Content[i], m.Content[i+1]
+	keyStart := d.offset(key.Line, key.Column)
+	lineStart := d.lines[key.Line-1]
+	first := strings.TrimSpace(string(d.src[lineStart:keyStart])) == ""
+
+	// Find the last line that belongs to the value: anything more
+	// indented than the key; or a sequence item at the same
+	// indentation, if the value is a block sequence.
+	indent := key.Column - 1
+	if !first {
+		indent = len([]rune(string(d.src[lineStart:keyStart])))
+	}
+	seqValue := value.Kind == yaml.SequenceNode && value.Style&yaml.FlowStyle == 0
+	last := key.Line - 1
+	for line := key.Line; line < len(d.lines); line++ {
+		text := d.lineText(line)
+		trimmed := strings.TrimLeft(text, " ")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "---") || strings.HasPrefix(text, "...") {
+			break
+		}
+		lineIndent := len(text) - len(trimmed)
+		if lineIndent > indent || (seqValue && lineIndent == indent && (trimmed == "-" || strings.HasPrefix(trimmed, "- "))) {
+			last = line
+			continue
+		}
+		break
+	}
+	end := d.lineEnd(last)
+
+	if first {
+		return lineStart, end, nil
+	}
+	// The key follows something else on its line, most likely the
+	// dash of a sequence item. It can be removed only if there's
+	// another key to take its place.
+	if i+2 < len(m.Content) {
+		next := m.Content[i+2]
+		return keyStart, d.offset(next.Line, next.Column), nil
+	}
+	return 0, 0, errors.Wrapf(ErrUnsupported, "removing the only entry of %q", key.Value)
+}
+
+// scalarExtent finds the range of text taken up by a scalar value.
+func (d *Document) scalarExtent(n *yaml.Node, inFlow bool) (int, int, error) {
+	start := d.offset(n.Line, n.Column)
+	src := d.src
+	// skip any tag or anchor
+	for start < len(src) && (src[start] == '!' || src[start] == '&') {
+		for start < len(src) && src[start] != ' ' && src[start] != '\t' && src[start] != '\n' {
+			start++
+		}
+		for start < len(src) && (src[start] == ' ' || src[start] == '\t') {
+			start++
+		}
+	}
+
+	switch {
+	case n.Kind == yaml.ScalarNode && n.Style&yaml.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(src); i++ {
+			switch src[i] {
+			case '\\':
+				i++
+			case '\n':
+				return 0, 0, errors.Wrap(ErrUnsupported, "multi-line quoted scalar")
+			case '"':
+				return start, i + 1, nil
+			}
+		}
+		return 0, 0, errors.New("unterminated double-quoted scalar")
+	case n.Kind == yaml.ScalarNode && n.Style&yaml.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(src); i++ {
+			switch src[i] {
+			case '\'':
+				if i+1 < len(src) && src[i+1] == '\'' {
+					i++
+					continue
+				}
+				return start, i + 1, nil
+			case '\n':
+				return 0, 0, errors.Wrap(ErrUnsupported, "multi-line quoted scalar")
+			}
+		}
+		return 0, 0, errors.New("unterminated single-quoted scalar")
+	case n.Kind == yaml.ScalarNode && n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		return 0, 0, errors.Wrap(ErrUnsupported, "block scalar")
+	}
+
+	// plain scalar (or alias)
+	end := start
+scan:
+	for ; end < len(src); end++ {
+		switch src[end] {
+		case '\n', '\r':
+			break scan
+		case '#':
+			if end > start && (src[end-1] == ' ' || src[end-1] == '\t') {
+				break scan
+			}
+		case ',', ']', '}':
+			if inFlow {
+				break scan
+			}
+		case ':':
+			// a colon followed by a space ends a key
+			if end+1 == len(src) || strings.IndexByte(" \t\r\n", src[end+1]) >= 0 || (inFlow && strings.IndexByte(",]}", src[end+1]) >= 0) {
+				break scan
+			}
+		}
+	}
+	for end > start && (src[end-1] == ' ' || src[end-1] == '\t') {
+		end--
+	}
+	if n.Kind == yaml.ScalarNode {
+		text := string(src[start:end])
+		if n.Tag == "!!null" && text == "" {
+			return start, start, nil
+		}
+		if text != n.Value {
+			return 0, 0, errors.Wrapf(ErrUnsupported, "multi-line plain scalar %q", n.Value)
+		}
+	}
+	return start, end, nil
+}
+
+// renderScalar gives the YAML text for a string value, quoted in the
+// style given if possible, and otherwise only if necessary.
+func renderScalar(value string, style yaml.Style, inFlow bool) string {
+	switch {
+	case style&yaml.SingleQuotedStyle != 0 && !strings.ContainsAny(value, "\n\r"):
+		return "'" + strings.Replace(value, "'", "''", -1) + "'"
+	case style&yaml.DoubleQuotedStyle != 0:
+		return strconv.Quote(value)
+	case isPlainSafe(value, inFlow):
+		return value
+	default:
+		return strconv.Quote(value)
+	}
+}
+
+// isPlainSafe reports whether a string can be written without quotes,
+// and still be read back as the same string.
+func isPlainSafe(value string, inFlow bool) bool {
+	if value == "" || strings.TrimSpace(value) != value || strings.ContainsAny(value, "\n\r\t") {
+		return false
+	}
+	if inFlow && strings.ContainsAny(value, ",[]{}") {
+		return false
+	}
+	var n yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &n); err != nil {
+		return false
+	}
+	if n.Kind != yaml.DocumentNode || len(n.Content) != 1 {
+		return false
+	}
+	s := n.Content[0]
+	return s.Kind == yaml.ScalarNode && s.Tag == "!!str" && s.Style == 0 && s.Value == value
+}
+
+// flowMapping keeps track of changes to a flow mapping, e.g.,
+// `{a: b, c: d}`, which is rewritten as a whole.
+type flowMapping struct {
+	doc        *Document
+	node       *yaml.Node
+	keys       []string
+	values     map[string]string
+	start, end int
+}
+
+// flowMapping returns the record of changes for a flow mapping,
+// creating it if necessary; or nil if the node is not a flow mapping.
+func (d *Document) flowMapping(m *yaml.Node) *flowMapping {
+	if m.Style&yaml.FlowStyle == 0 {
+		return nil
+	}
+	for _, f := range d.flows {
+		if f.node == m {
+			return f
+		}
+	}
+	f := &flowMapping{doc: d, node: m, values: map[string]string{}}
+	d.flows = append(d.flows, f)
+	return f
+}
+
+// load fills in the entries from the original text.
+func (f *flowMapping) load() error {
+	if f.values != nil && f.end > 0 {
+		return nil
+	}
+	d, m := f.doc, f.node
+	f.start = d.offset(m.Line, m.Column)
+	end, err := matchBracket(d.src, f.start)
+	if err != nil {
+		return err
+	}
+	f.end = end
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		k, v := m.Content[i], m.Content[i+1]
+		if v.Kind != yaml.ScalarNode && v.Kind != yaml.AliasNode {
+			start := d.offset(v.Line, v.Column)
+			vend, err := matchBracket(d.src, start)
+			if err != nil {
+				return err
+			}
+			f.keys = append(f.keys, k.Value)
+			f.values[k.Value] = string(d.src[start:vend])
+			continue
+		}
+		kstart, kend, err := d.scalarExtent(k, true)
+		if err != nil {
+			return err
+		}
+		vstart, vend, err := d.scalarExtent(v, true)
+		if err != nil {
+			return err
+		}
+		key := string(d.src[kstart:kend])
+		f.keys = append(f.keys, key)
+		f.values[key] = string(d.src[vstart:vend])
+	}
+	return nil
+}
+
+// keyText finds the key as written, for a key value.
+func (f *flowMapping) keyText(key string) string {
+	for _, k := range f.keys {
+		if k == key || unquote(k) == key {
+			return k
+		}
+	}
+	return ""
+}
+
+func (f *flowMapping) set(key, text string) error {
+	if err := f.load(); err != nil {
+		return err
+	}
+	if k := f.keyText(key); k != "" {
+		f.values[k] = text
+		return nil
+	}
+	return f.insert(key, renderScalar(key, 0, true)+": "+text)
+}
+
+func (f *flowMapping) insert(key, entry string) error {
+	if err := f.load(); err != nil {
+		return err
+	}
+	k := renderScalar(key, 0, true)
+	// as for block mappings, go before the first key that sorts after
+	i := 0
+	for ; i < len(f.keys); i++ {
+		if unquote(f.keys[i]) > key {
+			break
+		}
+	}
+	f.keys = append(f.keys[:i], append([]string{k}, f.keys[i:]...)...)
+	f.values[k] = strings.TrimPrefix(entry, k+": ")
+	return nil
+}
+
+func (f *flowMapping) remove(key string) error {
+	if err := f.load(); err != nil {
+		return err
+	}
+	k := f.keyText(key)
+	for i := range f.keys {
+		if f.keys[i] == k {
+			f.keys = append(f.keys[:i], f.keys[i+1:]...)
+			break
+		}
+	}
+	delete(f.values, k)
+	return nil
+}
+
+func (f *flowMapping) edit() (edit, error) {
+	if err := f.load(); err != nil {
+		return edit{}, err
+	}
+	var entries []string
+	for _, k := range f.keys {
+		entries = append(entries, k+": "+f.values[k])
+	}
+	return edit{f.start, f.end, "{" + strings.Join(entries, ", ") + "}"}, nil
+}
+
+func unquote(s string) string {
+	var n yaml.Node
+	if err := yaml.Unmarshal([]byte(s), &n); err != nil || len(n.Content) != 1 {
+		return s
+	}
+	return n.Content[0].Value
+}
+
+// matchBracket finds the end of the flow collection starting at the
+// offset given.
+func matchBracket(src []byte, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		case '"':
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+		case '\'':
+			for i++; i < len(src); i++ {
+				if src[i] == '\'' {
+					if i+1 < len(src) && src[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		}
+	}
+	return 0, errors.New("unterminated flow collection")
+}
@@ -0,0 +1,256 @@
+package yamledit
+
+import (
+	"testing"
+)
+
+func mustParse(t *testing.T, src string) *Document {
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func checkBytes(t *testing.T, doc *Document, expected string) {
+	out, err := doc.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestGet(t *testing.T) {
+	doc := mustParse(t, `a:
+  b: &b
+    c: one
+  d: *b
+  e: [x, y]
+`)
+	root := doc.Root()
+	if v := root.Get("a", "b", "c").Value(); v != "one" {
+		t.Errorf("expected %q, got %q", "one", v)
+	}
+	if v := root.Get("a", "d", "c").Value(); v != "one" {
+		t.Errorf("expected alias to be followed, got %q", v)
+	}
+	if root.Get("a", "nope", "c").Exists() {
+		t.Errorf("expected missing path not to exist")
+	}
+	items := root.Get("a", "e").Items()
+	if len(items) != 2 || items[1].Value() != "y" {
+		t.Errorf("unexpected items %v", items)
+	}
+	if keys := root.Get("a").Keys(); len(keys) != 3 || keys[2] != "e" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func TestSetValue(t *testing.T) {
+	for _, c := range []struct {
+		in, value, out string
+	}{
+		{"k: v # comment\n", "w", "k: w # comment\n"},
+		{"k: \"v\"\n", "w", "k: \"w\"\n"},
+		{"k: 'v'\n", "it's", "k: 'it''s'\n"},
+		{"k: v\n", "1234", "k: \"1234\"\n"},
+		{"k: v\n", "true", "k: \"true\"\n"},
+		{"k: v\n", "a: b", "k: \"a: b\"\n"},
+		{"k: v\n", "#hash", "k: \"#hash\"\n"},
+		{"k:\n", "w", "k: w\n"},
+		{"k: ~\n", "w", "k: w\n"},
+		{"k: [v, x]\n", "a,b", "k: [\"a,b\", x]\n"},
+		{"k: v\n", "two\nlines", "k: \"two\\nlines\"\n"},
+	} {
+		doc := mustParse(t, c.in)
+		root := doc.Root()
+		v := root.Get("k")
+		if v.IsSequence() {
+			v = v.Items()[0]
+		}
+		if err := v.SetValue(c.value); err != nil {
+			t.Errorf("%q: %s", c.in, err)
+			continue
+		}
+		checkBytes(t, doc, c.out)
+	}
+}
+
+func TestSetPlainValue(t *testing.T) {
+	doc := mustParse(t, "a: \"1234\"\nb: 'v1'\n")
+	root := doc.Root()
+	if err := root.Get("a").SetPlainValue("v2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Get("b").SetPlainValue("5678"); err != nil {
+		t.Fatal(err)
+	}
+	checkBytes(t, doc, "a: v2\nb: \"5678\"\n")
+}
+
+func TestSetValueUnsupported(t *testing.T) {
+	for _, in := range []string{
+		"k: |\n  literal\n",
+		"k: >\n  folded\n",
+		"k: plain\n  continued\n",
+		"k: \"quoted\n  continued\"\n",
+		"k: {a: b}\n",
+	} {
+		doc := mustParse(t, in)
+		if err := doc.Root().Get("k").SetValue("x"); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	for _, c := range []struct {
+		in, key, value, out string
+	}{
+		// sorted position
+		{"a: 1\nc: 3\n", "b", "2", "a: 1\nb: \"2\"\nc: 3\n"},
+		// at the end
+		{"a: 1\nb: 2\n", "c", "x", "a: 1\nb: 2\nc: x\n"},
+		// at the end, after a nested value
+		{"a: 1\nb:\n  c: 2\n", "z", "x", "a: 1\nb:\n  c: 2\nz: x\n"},
+		// at the end, with no final newline
+		{"a: 1", "b", "x", "a: 1\nb: x\n"},
+		// before the comments for the key that follows
+		{"a: 1\n# about c\nc: 3\n", "b", "x", "a: 1\nb: x\n# about c\nc: 3\n"},
+		// not before the first key of a sequence item
+		{"- c: 1\n  d: 2\n", "b", "x", "- c: 1\n  b: x\n  d: 2\n"},
+		// existing
+		{"a: 1\nb: 'old' # comment\n", "b", "new", "a: 1\nb: 'new' # comment\n"},
+		// flow mappings
+		{"{a: 1, c: 3}\n", "b", "x", "{a: 1, b: x, c: 3}\n"},
+		{"{a: 1, 'b': 2}\n", "b", "x", "{a: 1, 'b': x}\n"},
+		// windows line endings
+		{"a: 1\r\nc: 3\r\n", "b", "x", "a: 1\r\nb: x\r\nc: 3\r\n"},
+	} {
+		doc := mustParse(t, c.in)
+		root := doc.Root()
+		if root.IsSequence() {
+			root = root.Items()[0]
+		}
+		if err := root.Set(c.key, c.value); err != nil {
+			t.Errorf("%q: %s", c.in, err)
+			continue
+		}
+		checkBytes(t, doc, c.out)
+	}
+}
+
+func TestSetMap(t *testing.T) {
+	for _, c := range []struct {
+		in, out string
+	}{
+		{"a: 1\nz: 2\n", "a: 1\nm:\n  x: \"1\"\n  y: two\nz: 2\n"},
+		{"a:\n    b: 1\nz: 2\n", "a:\n    b: 1\nm:\n    x: \"1\"\n    y: two\nz: 2\n"},
+		{"a: 1\nm:\nz: 2\n", "a: 1\nm:\n  x: \"1\"\n  y: two\nz: 2\n"},
+		{"a: 1\nm: ~ # nothing\nz: 2\n", "a: 1\nm:\n  x: \"1\"\n  y: two\nz: 2\n"},
+		{"{a: 1, z: 2}\n", "{a: 1, m: {x: \"1\", y: two}, z: 2}\n"},
+	} {
+		doc := mustParse(t, c.in)
+		if err := doc.Root().SetMap("m", map[string]string{"y": "two", "x": "1"}); err != nil {
+			t.Errorf("%q: %s", c.in, err)
+			continue
+		}
+		checkBytes(t, doc, c.out)
+	}
+
+	doc := mustParse(t, "m: {a: 1}\n")
+	if err := doc.Root().SetMap("m", map[string]string{"b": "2"}); err == nil {
+		t.Errorf("expected error replacing non-null value")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	for _, c := range []struct {
+		in, key, out string
+	}{
+		{"a: 1\nb: 2\nc: 3\n", "b", "a: 1\nc: 3\n"},
+		{"a: 1\nb:\n  x: 1\n\n  y: 2\nc: 3\n", "b", "a: 1\nc: 3\n"},
+		{"a: 1\nb:\n- 1\n- 2\nc: 3\n", "b", "a: 1\nc: 3\n"},
+		{"a: 1\nb: |\n  text\n  more text\n# about c\nc: 3\n", "b", "a: 1\n# about c\nc: 3\n"},
+		{"a: 1\nb: 2", "b", "a: 1\n"},
+		{"a: 1\n", "b", "a: 1\n"},
+		{"- a: 1\n  b: 2\n", "a", "- b: 2\n"},
+		{"{a: 1, b: 2}\n", "a", "{b: 2}\n"},
+	} {
+		doc := mustParse(t, c.in)
+		root := doc.Root()
+		if root.IsSequence() {
+			root = root.Items()[0]
+		}
+		if err := root.Remove(c.key); err != nil {
+			t.Errorf("%q: %s", c.in, err)
+			continue
+		}
+		checkBytes(t, doc, c.out)
+	}
+
+	doc := mustParse(t, "- a: 1\n")
+	if err := doc.Root().Items()[0].Remove("a"); err == nil {
+		t.Errorf("expected error removing only key of sequence item")
+	}
+}
+
+func TestOnlyFirstDocument(t *testing.T) {
+	doc := mustParse(t, "a: 1\n---\na: 1\n")
+	if err := doc.Root().Get("a").SetValue("2"); err != nil {
+		t.Fatal(err)
+	}
+	checkBytes(t, doc, "a: \"2\"\n---\na: 1\n")
+}
+
+func TestOverlappingEdits(t *testing.T) {
+	doc := mustParse(t, "a: {b: 1}\n")
+	root := doc.Root()
+	if err := root.Get("a", "b").SetValue("2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Get("a").Set("c", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doc.Bytes(); err != ErrOverlapping {
+		t.Errorf("expected %v, got %v", ErrOverlapping, err)
+	}
+}
+
+func TestText(t *testing.T) {
+	doc := mustParse(t, `items:
+# first
+- kind: A
+  data:
+    x: |
+      text
+
+      more text
+# about list
+  list:
+  - 1
+# about B
+- 
+    kind: B
+- {kind: C}
+other: 1
+`)
+	items := doc.Root().Get("items").Items()
+	for i, expected := range []string{
+		"kind: A\ndata:\n  x: |\n    text\n\n    more text\n# about list\nlist:\n- 1\n",
+		"kind: B\n",
+	} {
+		text, err := items[i].Text()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(text) != expected {
+			t.Errorf("item %d: expected:\n%s\ngot:\n%s", i, expected, text)
+		}
+	}
+	if _, err := items[2].Text(); err == nil {
+		t.Errorf("expected error getting text of flow mapping")
+	}
+}
@@ -1,8 +1,11 @@
 package cluster
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/image"
@@ -10,43 +13,104 @@ import (
 	"github.com/weaveworks/flux/resource"
 )
 
+// ManifestLocation says where a resource is defined: in which file,
+// and where in the file, since a file may have several YAML documents
+// in it, and a document may be a List of resources.
+type ManifestLocation struct {
+	Path string
+	// Document is the index of the YAML document in the file,
+	// counting from zero
+	Document int
+	// Item is the index of the resource among the items of a List,
+	// or -1 if the document is not a List
+	Item int
+}
+
+func (l ManifestLocation) String() string {
+	switch {
+	case l.Item >= 0:
+		return fmt.Sprintf("%s (document %d, item %d)", l.Path, l.Document, l.Item)
+	case l.Document > 0:
+		return fmt.Sprintf("%s (document %d)", l.Path, l.Document)
+	}
+	return l.Path
+}
+
 // Manifests represents how a set of files are used as definitions of
 // resources, e.g., in Kubernetes, YAML files describing Kubernetes
 // resources.
 type Manifests interface {
-	// Given a directory with manifest files, find which files define
-	// which services.
-	FindDefinedServices(path string) (map[flux.ResourceID][]string, error)
-	// Update the definitions in a manifests bytes according to the
-	// spec given.
-	UpdateDefinition(def []byte, container string, newImageID image.Ref) ([]byte, error)
+	// Given a directory with manifest files, find which files (and
+	// where in the files) define which services.
+	FindDefinedServices(path string) (map[flux.ResourceID][]ManifestLocation, error)
+	// Update the definition of the resource given, in the bytes of
+	// a manifest file, according to the spec given. Anything else
+	// in the file is left as it was.
+	UpdateDefinition(def []byte, id flux.ResourceID, container string, newImageID image.Ref) ([]byte, error)
 	// Load all the resource manifests under the path given
 	LoadManifests(paths ...string) (map[string]resource.Resource, error)
 	// Parse the manifests given in an exported blob
 	ParseManifests([]byte) (map[string]resource.Resource, error)
-	// UpdatePolicies modifies a manifest to apply the policy update specified
-	UpdatePolicies([]byte, policy.Update) ([]byte, error)
+	// UpdatePolicies modifies the definition of the resource given,
+	// in the bytes of a manifest file, to apply the policy update
+	// specified
+	UpdatePolicies([]byte, flux.ResourceID, policy.Update) ([]byte, error)
+	// UpdatePolicyFile modifies the policy file (given as bytes,
+	// which may be empty if there's no file yet), to apply the
+	// policy update specified to the resource given
+	UpdatePolicyFile([]byte, flux.ResourceID, policy.Update) ([]byte, error)
 	// ServicesWithPolicies returns all services with their associated policies
 	ServicesWithPolicies(path string) (policy.ResourceMap, error)
+	// ServicesWithPolicySources returns all services with their
+	// associated policies, as ServicesWithPolicies does, and where
+	// each policy came from
+	ServicesWithPolicySources(path string) (policy.ResourceMap, policy.SourceMap, error)
+	// InheritedPolicies returns, for all services, the policies in
+	// effect that are not given for the service itself, i.e., those
+	// that would still be in effect if they were removed from it
+	InheritedPolicies(path string) (policy.ResourceMap, error)
+}
+
+// PolicyFile is the name of the file, at the root of the manifests,
+// in which policies can be given for resources, as an alternative to
+// annotations in the resources' manifests.
+const PolicyFile = ".flux.yaml"
+
+// UpdatePolicyFile reads the policy file at the root of the manifests
+// given (or nothing, if there isn't one), applies f(contents), and
+// writes the results back to the file.
+func UpdatePolicyFile(root string, f func(def []byte) ([]byte, error)) error {
+	path := filepath.Join(root, PolicyFile)
+	def, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	newDef, err := f(def)
+	if err != nil || bytes.Equal(newDef, def) {
+		return err
+	}
+	return ioutil.WriteFile(path, newDef, 0666)
 }
 
-// UpdateManifest looks for the manifest for a given service, reads
-// its contents, applies f(contents), and writes the results back to
-// the file.
+// UpdateManifest looks for the manifest file for a given service,
+// reads its contents, applies f(contents), and writes the results
+// back to the file. Since the file may define other resources as
+// well, f should change only the definition of the service.
 func UpdateManifest(m Manifests, root string, serviceID flux.ResourceID, f func(manifest []byte) ([]byte, error)) error {
 	services, err := m.FindDefinedServices(root)
 	if err != nil {
 		return err
 	}
-	paths := services[serviceID]
-	if len(paths) == 0 {
+	locations := services[serviceID]
+	if len(locations) == 0 {
 		return ErrNoResourceFilesFoundForService
 	}
-	if len(paths) > 1 {
+	if len(locations) > 1 {
 		return ErrMultipleResourceFilesFoundForService
 	}
+	path := locations[0].Path
 
-	def, err := ioutil.ReadFile(paths[0])
+	def, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
@@ -56,9 +120,9 @@ func UpdateManifest(m Manifests, root string, serviceID flux.ResourceID, f func(
 		return err
 	}
 
-	fi, err := os.Stat(paths[0])
+	fi, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(paths[0], newDef, fi.Mode())
+	return ioutil.WriteFile(path, newDef, fi.Mode())
 }
@@ -10,19 +10,22 @@ import (
 
 // Doubles as a cluster.Cluster and cluster.Manifests implementation
 type Mock struct {
-	AllServicesFunc          func(maybeNamespace string) ([]Controller, error)
-	SomeServicesFunc         func([]flux.ResourceID) ([]Controller, error)
-	PingFunc                 func() error
-	ExportFunc               func() ([]byte, error)
-	SyncFunc                 func(SyncDef) error
-	PublicSSHKeyFunc         func(regenerate bool) (ssh.PublicKey, error)
-	FindDefinedServicesFunc  func(path string) (map[flux.ResourceID][]string, error)
-	UpdateDefinitionFunc     func(def []byte, container string, newImageID image.Ref) ([]byte, error)
-	LoadManifestsFunc        func(paths ...string) (map[string]resource.Resource, error)
-	ParseManifestsFunc       func([]byte) (map[string]resource.Resource, error)
-	UpdateManifestFunc       func(path, resourceID string, f func(def []byte) ([]byte, error)) error
-	UpdatePoliciesFunc       func([]byte, policy.Update) ([]byte, error)
-	ServicesWithPoliciesFunc func(path string) (policy.ResourceMap, error)
+	AllServicesFunc               func(maybeNamespace string) ([]Controller, error)
+	SomeServicesFunc              func([]flux.ResourceID) ([]Controller, error)
+	PingFunc                      func() error
+	ExportFunc                    func() ([]byte, error)
+	SyncFunc                      func(SyncDef) error
+	PublicSSHKeyFunc              func(regenerate bool) (ssh.PublicKey, error)
+	FindDefinedServicesFunc       func(path string) (map[flux.ResourceID][]ManifestLocation, error)
+	UpdateDefinitionFunc          func(def []byte, id flux.ResourceID, container string, newImageID image.Ref) ([]byte, error)
+	LoadManifestsFunc             func(paths ...string) (map[string]resource.Resource, error)
+	ParseManifestsFunc            func([]byte) (map[string]resource.Resource, error)
+	UpdateManifestFunc            func(path, resourceID string, f func(def []byte) ([]byte, error)) error
+	UpdatePoliciesFunc            func([]byte, flux.ResourceID, policy.Update) ([]byte, error)
+	UpdatePolicyFileFunc          func([]byte, flux.ResourceID, policy.Update) ([]byte, error)
+	ServicesWithPoliciesFunc      func(path string) (policy.ResourceMap, error)
+	ServicesWithPolicySourcesFunc func(path string) (policy.ResourceMap, policy.SourceMap, error)
+	InheritedPoliciesFunc         func(path string) (policy.ResourceMap, error)
 }
 
 func (m *Mock) AllControllers(maybeNamespace string) ([]Controller, error) {
@@ -49,12 +52,12 @@ func (m *Mock) PublicSSHKey(regenerate bool) (ssh.PublicKey, error) {
 	return m.PublicSSHKeyFunc(regenerate)
 }
 
-func (m *Mock) FindDefinedServices(path string) (map[flux.ResourceID][]string, error) {
+func (m *Mock) FindDefinedServices(path string) (map[flux.ResourceID][]ManifestLocation, error) {
 	return m.FindDefinedServicesFunc(path)
 }
 
-func (m *Mock) UpdateDefinition(def []byte, container string, newImageID image.Ref) ([]byte, error) {
-	return m.UpdateDefinitionFunc(def, container, newImageID)
+func (m *Mock) UpdateDefinition(def []byte, id flux.ResourceID, container string, newImageID image.Ref) ([]byte, error) {
+	return m.UpdateDefinitionFunc(def, id, container, newImageID)
 }
 
 func (m *Mock) LoadManifests(paths ...string) (map[string]resource.Resource, error) {
@@ -69,10 +72,22 @@ func (m *Mock) UpdateManifest(path string, resourceID string, f func(def []byte)
 	return m.UpdateManifestFunc(path, resourceID, f)
 }
 
-func (m *Mock) UpdatePolicies(def []byte, p policy.Update) ([]byte, error) {
-	return m.UpdatePoliciesFunc(def, p)
+func (m *Mock) UpdatePolicies(def []byte, id flux.ResourceID, p policy.Update) ([]byte, error) {
+	return m.UpdatePoliciesFunc(def, id, p)
+}
+
+func (m *Mock) UpdatePolicyFile(def []byte, id flux.ResourceID, p policy.Update) ([]byte, error) {
+	return m.UpdatePolicyFileFunc(def, id, p)
 }
 
 func (m *Mock) ServicesWithPolicies(path string) (policy.ResourceMap, error) {
 	return m.ServicesWithPoliciesFunc(path)
 }
+
+func (m *Mock) ServicesWithPolicySources(path string) (policy.ResourceMap, policy.SourceMap, error) {
+	return m.ServicesWithPolicySourcesFunc(path)
+}
+
+func (m *Mock) InheritedPolicies(path string) (policy.ResourceMap, error) {
+	return m.InheritedPoliciesFunc(path)
+}
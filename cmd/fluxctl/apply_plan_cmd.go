@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux/update"
+)
+
+type applyPlanOpts struct {
+	*rootOpts
+	outputOpts
+	cause update.Cause
+}
+
+func newApplyPlan(parent *rootOpts) *applyPlanOpts {
+	return &applyPlanOpts{rootOpts: parent}
+}
+
+func (opts *applyPlanOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply-plan <id>",
+		Short: "Execute a release plan saved by a dry run.",
+		Long: `
+Execute a release plan saved with fluxctl release --dry-run --save-plan.
+
+The release is made exactly as it was reported by the dry run. If the
+git repo has moved on, or the images available have changed, since the
+plan was saved, nothing is released; make a fresh plan instead.`,
+		Example: makeExample(
+			"fluxctl apply-plan 6f2a4b1c-1d3e-4f5a-8b9c-0d1e2f3a4b5c",
+		),
+		RunE: opts.RunE,
+	}
+	AddOutputFlags(cmd, &opts.outputOpts)
+	AddCauseFlags(cmd, &opts.cause)
+	return cmd
+}
+
+func (opts *applyPlanOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return newUsageError("please supply the ID of the release plan to apply")
+	}
+
+	fmt.Fprintf(cmd.OutOrStderr(), "Submitting release plan ...\n")
+
+	ctx := context.Background()
+	jobID, err := opts.API.ApplyPlan(ctx, args[0], opts.cause)
+	if err != nil {
+		return err
+	}
+
+	return await(ctx, cmd.OutOrStdout(), cmd.OutOrStderr(), opts.API, jobID, true, opts.verbose)
+}
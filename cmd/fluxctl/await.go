@@ -19,30 +19,38 @@ var ErrTimeout = errors.New("timeout")
 // await polls for a job to complete, then for the resulting commit to
 // be applied
 func await(ctx context.Context, stdout, stderr io.Writer, client api.Client, jobID job.ID, apply, verbose bool) error {
+	_, err := awaitResult(ctx, stdout, stderr, client, jobID, apply, verbose)
+	return err
+}
+
+// awaitResult is await, also returning the outcome of the job
+func awaitResult(ctx context.Context, stdout, stderr io.Writer, client api.Client, jobID job.ID, apply, verbose bool) (event.CommitEventMetadata, error) {
 	metadata, err := awaitJob(ctx, client, jobID)
 	if err != nil && err.Error() != git.ErrNoChanges.Error() {
-		return err
+		return metadata, err
 	}
 	if metadata.Result != nil {
 		update.PrintResults(stdout, metadata.Result, verbose)
 	}
 	if metadata.Revision != "" {
 		fmt.Fprintf(stderr, "Commit pushed:\t%s\n", metadata.ShortRevision())
+	} else if metadata.PlanID != "" {
+		fmt.Fprintf(stderr, "Plan saved:\t%s\n", metadata.PlanID)
 	}
 	if metadata.Result == nil {
 		fmt.Fprintf(stderr, "Nothing to do\n")
-		return nil
+		return metadata, nil
 	}
 
 	if apply && metadata.Revision != "" {
 		if err := awaitSync(ctx, client, metadata.Revision); err != nil {
-			return err
+			return metadata, err
 		}
 
 		fmt.Fprintf(stderr, "Commit applied:\t%s\n", metadata.ShortRevision())
 	}
 
-	return nil
+	return metadata, nil
 }
 
 // await polls for a job to have been completed, with exponential backoff.
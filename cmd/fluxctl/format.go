@@ -8,6 +8,8 @@ import (
 	"text/tabwriter"
 
 	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux"
 )
 
 type outputOpts struct {
@@ -29,3 +31,12 @@ func makeExample(examples ...string) string {
 	}
 	return strings.TrimSuffix(buf.String(), "\n")
 }
+
+// containerDisplayName gives the name of the container as shown in
+// tables, marking init containers so they can be told apart.
+func containerDisplayName(c flux.Container) string {
+	if c.Init {
+		return c.Name + " (init)"
+	}
+	return c.Name
+}
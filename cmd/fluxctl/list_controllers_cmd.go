@@ -57,9 +57,9 @@ func (opts *controllerListOpts) RunE(cmd *cobra.Command, args []string) error {
 	for _, controller := range controllers {
 		if len(controller.Containers) > 0 {
 			c := controller.Containers[0]
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", controller.ID, c.Name, c.Current.ID, controller.Status, policies(controller))
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", controller.ID, containerDisplayName(c), c.Current.ID, controller.Status, policies(controller))
 			for _, c := range controller.Containers[1:] {
-				fmt.Fprintf(w, "\t%s\t%s\t\t\n", c.Name, c.Current.ID)
+				fmt.Fprintf(w, "\t%s\t%s\t\t\n", containerDisplayName(c), c.Current.ID)
 			}
 		} else {
 			fmt.Fprintf(w, "%s\t\t\t\t\n", controller.ID)
@@ -86,14 +86,24 @@ func (s controllerStatusByName) Swap(a, b int) {
 func policies(s flux.ControllerStatus) string {
 	var ps []string
 	if s.Automated {
-		ps = append(ps, string(policy.Automated))
+		ps = append(ps, withSource(s, policy.Automated))
 	}
 	if s.Locked {
-		ps = append(ps, string(policy.Locked))
+		ps = append(ps, withSource(s, policy.Locked))
 	}
 	if s.Ignore {
-		ps = append(ps, string(policy.Ignore))
+		ps = append(ps, withSource(s, policy.Ignore))
 	}
 	sort.Strings(ps)
 	return strings.Join(ps, ",")
 }
+
+// withSource gives the name of a policy, along with where it came
+// from if it wasn't given on the controller itself; e.g.,
+// "automated(namespace)".
+func withSource(s flux.ControllerStatus, p policy.Policy) string {
+	if source, ok := s.PolicySources[string(p)]; ok {
+		return string(p) + "(" + source + ")"
+	}
+	return string(p)
+}
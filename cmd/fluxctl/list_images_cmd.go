@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/policy"
 	"github.com/weaveworks/flux/update"
 )
 
@@ -17,6 +20,7 @@ type controllerShowOpts struct {
 	namespace  string
 	controller string
 	limit      int
+	platforms  bool
 
 	// Deprecated
 	service string
@@ -36,6 +40,7 @@ func (opts *controllerShowOpts) Command() *cobra.Command {
 	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "Controller namespace")
 	cmd.Flags().StringVarP(&opts.controller, "controller", "c", "", "Show images for this controller")
 	cmd.Flags().IntVarP(&opts.limit, "limit", "l", 10, "Number of images to show (0 for all)")
+	cmd.Flags().BoolVar(&opts.platforms, "platforms", false, "Show the platforms each image has an image for, if it has a manifest list")
 
 	// Deprecated
 	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Show images for this service")
@@ -73,8 +78,13 @@ func (opts *controllerShowOpts) RunE(cmd *cobra.Command, args []string) error {
 	sort.Sort(imageStatusByName(controllers))
 
 	out := newTabwriter()
+	now := time.Now()
 
-	fmt.Fprintln(out, "CONTROLLER\tCONTAINER\tIMAGE\tCREATED")
+	if opts.platforms {
+		fmt.Fprintln(out, "CONTROLLER\tCONTAINER\tIMAGE\tCREATED\tPLATFORMS")
+	} else {
+		fmt.Fprintln(out, "CONTROLLER\tCONTAINER\tIMAGE\tCREATED")
+	}
 	for _, controller := range controllers {
 		if len(controller.Containers) == 0 {
 			fmt.Fprintf(out, "%s\t\t\t\n", controller.ID)
@@ -84,18 +94,47 @@ func (opts *controllerShowOpts) RunE(cmd *cobra.Command, args []string) error {
 		controllerName := controller.ID.String()
 		for _, container := range controller.Containers {
 			var lineCount int
-			containerName := container.Name
+			containerName := containerDisplayName(container)
 			reg, repo, currentTag := container.Current.ID.Components()
 			if reg != "" {
 				reg += "/"
 			}
-			if len(container.Available) == 0 {
-				fmt.Fprintf(out, "%s\t%s\t%s%s\twaiting for cache\n", controllerName, containerName, reg, repo)
+			// Tags which pass the filter, if there is one, are marked
+			// with an asterisk and listed first, in the filter's order
+			// of latestness.
+			images := container.Available
+			var pattern policy.Pattern
+			var notes []string
+			if container.Filter != "" {
+				if p, err := policy.ParsePattern(container.Filter); err == nil {
+					pattern = p
+					notes = append(notes, "filter "+p.String())
+					images = sortByPattern(images, pattern)
+				}
+			}
+			// Images newer than the minimum age, if there is one, are
+			// noted with when they'll be old enough to be released.
+			var minAge time.Duration
+			if container.MinAge != "" {
+				if age, err := policy.ParseMinAge(container.MinAge); err == nil {
+					minAge = age
+					notes = append(notes, "min-age "+container.MinAge)
+				}
+			}
+			if container.Locked {
+				notes = append(notes, "locked")
+			}
+			var filter string
+			if len(notes) > 0 {
+				filter = " (" + strings.Join(notes, ", ") + ")"
+			}
+			if len(images) == 0 {
+				fmt.Fprintf(out, "%s\t%s\t%s%s%s\twaiting for cache\n", controllerName, containerName, reg, repo, filter)
 			} else {
-				fmt.Fprintf(out, "%s\t%s\t%s%s\t\n", controllerName, containerName, reg, repo)
+				fmt.Fprintf(out, "%s\t%s\t%s%s%s\t\n", controllerName, containerName, reg, repo, filter)
 			}
 			foundRunning := false
-			for _, available := range container.Available {
+			for _, available := range images {
 				running := "|  "
 				_, _, tag := available.ID.Components()
 				if currentTag == tag {
@@ -104,12 +143,16 @@ func (opts *controllerShowOpts) RunE(cmd *cobra.Command, args []string) error {
 				} else if foundRunning {
 					running = "   "
 				}
+				matches := pattern == nil || pattern.Matches(tag)
+				if pattern != nil && matches {
+					tag += " *"
+				}
 
 				lineCount++
 				var printEllipsis, printLine bool
 				if opts.limit <= 0 || lineCount <= opts.limit {
 					printEllipsis, printLine = false, true
-				} else if container.Current.ID == available.ID {
+				} else if container.Current.ID.WithDigest("") == available.ID {
 					printEllipsis, printLine = lineCount > (opts.limit+1), true
 				}
 				if printEllipsis {
@@ -120,6 +163,12 @@ func (opts *controllerShowOpts) RunE(cmd *cobra.Command, args []string) error {
 					if !available.CreatedAt.IsZero() {
 						createdAt = available.CreatedAt.Format(time.RFC822)
 					}
+					if minAge > 0 && matches {
+						createdAt += minAgeNote(available, minAge, now)
+					}
+					if opts.platforms {
+						createdAt += "\t" + platformsOf(available)
+					}
 					fmt.Fprintf(out, "\t\t%s %s\t%s\n", running, tag, createdAt)
 				}
 			}
@@ -130,6 +179,49 @@ func (opts *controllerShowOpts) RunE(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// platformsOf lists the platforms there's an image for, if the image
+// has a manifest list.
+func platformsOf(im image.Info) string {
+	var platforms []string
+	for p := range im.Platforms {
+		platforms = append(platforms, p)
+	}
+	sort.Strings(platforms)
+	return strings.Join(platforms, ",")
+}
+
+// minAgeNote explains why an image won't be automatically released
+// yet, if it's newer than the minimum age given; or why it never will
+// be, if its age isn't known.
+func minAgeNote(im image.Info, minAge time.Duration, now time.Time) string {
+	switch {
+	case im.CreatedAt.IsZero():
+		return " (age unknown, so not automated)"
+	case now.Sub(im.CreatedAt) < minAge:
+		return " (too new until " + im.CreatedAt.Add(minAge).Format(time.RFC822) + ")"
+	default:
+		return ""
+	}
+}
+
+// sortByPattern returns the images given, with those matching the
+// pattern first, in the pattern's order, followed by the rest in
+// their original order.
+func sortByPattern(images []image.Info, pattern policy.Pattern) []image.Info {
+	sorted := make([]image.Info, len(images))
+	copy(sorted, images)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		mi, mj := pattern.Matches(sorted[i].ID.Tag), pattern.Matches(sorted[j].ID.Tag)
+		switch {
+		case mi && mj:
+			return pattern.Newer(&sorted[i], &sorted[j])
+		default:
+			return mi && !mj
+		}
+	})
+	return sorted
+}
+
 type imageStatusByName []flux.ImageStatus
 
 func (s imageStatusByName) Len() int {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/spf13/cobra"
 
 	"github.com/weaveworks/flux/update"
@@ -10,6 +12,9 @@ type controllerLockOpts struct {
 	*rootOpts
 	namespace  string
 	controller string
+	containers []string
+	lockFor    time.Duration
+	lockUntil  string
 	outputOpts
 	cause update.Cause
 
@@ -27,6 +32,9 @@ func (opts *controllerLockOpts) Command() *cobra.Command {
 		Short: "Lock a controller, so it cannot be deployed.",
 		Example: makeExample(
 			"fluxctl lock --controller=deployment/helloworld",
+			"fluxctl lock --controller=deployment/helloworld --container=sidecar",
+			"fluxctl lock --controller=deployment/helloworld --for=4h",
+			"fluxctl lock --controller=deployment/helloworld --until=2018-03-01T09:00:00Z",
 		),
 		RunE: opts.RunE,
 	}
@@ -34,6 +42,9 @@ func (opts *controllerLockOpts) Command() *cobra.Command {
 	AddCauseFlags(cmd, &opts.cause)
 	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "Controller namespace")
 	cmd.Flags().StringVarP(&opts.controller, "controller", "c", "", "Controller to lock")
+	cmd.Flags().StringSliceVar(&opts.containers, "container", nil, "Lock only this container, rather than the whole controller; give more than once for several containers")
+	cmd.Flags().DurationVar(&opts.lockFor, "for", 0, "Unlock the controller automatically after this long, e.g., 4h")
+	cmd.Flags().StringVar(&opts.lockUntil, "until", "", "Unlock the controller automatically at this time, given in RFC3339 format")
 
 	// Deprecated
 	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to lock")
@@ -47,13 +58,48 @@ func (opts *controllerLockOpts) RunE(cmd *cobra.Command, args []string) error {
 		return errorServiceFlagDeprecated
 	}
 
+	lockedUntil, err := opts.lockedUntil(time.Now())
+	if err != nil {
+		return err
+	}
+
 	policyOpts := &controllerPolicyOpts{
-		rootOpts:   opts.rootOpts,
-		outputOpts: opts.outputOpts,
-		namespace:  opts.namespace,
-		controller: opts.controller,
-		cause:      opts.cause,
-		lock:       true,
+		rootOpts:    opts.rootOpts,
+		outputOpts:  opts.outputOpts,
+		namespace:   opts.namespace,
+		controller:  opts.controller,
+		cause:       opts.cause,
+		lock:        true,
+		containers:  opts.containers,
+		lockedUntil: lockedUntil,
 	}
 	return policyOpts.RunE(cmd, args)
 }
+
+// lockedUntil works out when the lock should expire, from the flags
+// given; or the zero time, if it shouldn't.
+func (opts *controllerLockOpts) lockedUntil(now time.Time) (time.Time, error) {
+	var until time.Time
+	switch {
+	case opts.lockFor != 0 && opts.lockUntil != "":
+		return until, newUsageError("--for and --until both specified")
+	case opts.lockFor < 0:
+		return until, newUsageError("--for must be a positive duration")
+	case opts.lockFor > 0:
+		until = now.Add(opts.lockFor)
+	case opts.lockUntil != "":
+		var err error
+		if until, err = time.Parse(time.RFC3339, opts.lockUntil); err != nil {
+			return until, newUsageError("--until must be a time in RFC3339 format, e.g., 2006-01-02T15:04:05Z")
+		}
+		if !until.After(now) {
+			return until, newUsageError("--until must be in the future")
+		}
+	default:
+		return until, nil
+	}
+	if len(opts.containers) > 0 {
+		return until, newUsageError("--for and --until cannot be used with --container")
+	}
+	return until, nil
+}
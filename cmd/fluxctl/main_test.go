@@ -107,7 +107,8 @@ func testArgs(t *testing.T, args []string, shouldErr bool, errMsg string) *gener
 func newMockService() *genericMockRoundTripper {
 	return &genericMockRoundTripper{
 		mockResponses: map[*mux.Route]interface{}{
-			transport.NewAPIRouter().Get("UpdateImages"): job.ID("here-is-a-job-id"),
+			transport.NewAPIRouter().Get("UpdateImages"):    job.ID("here-is-a-job-id"),
+			transport.NewAPIRouter().Get("UpdateImagesV10"): job.ID("here-is-a-job-id"),
 			transport.NewAPIRouter().Get("JobStatus"): job.Status{
 				StatusString: job.StatusSucceeded,
 			},
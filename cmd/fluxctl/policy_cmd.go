@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/weaveworks/flux"
@@ -19,9 +20,17 @@ type controllerPolicyOpts struct {
 	controller string
 	tagAll     string
 	tags       []string
+	minAges    []string
 
 	automate, deautomate bool
 	lock, unlock         bool
+	pin, unpin           bool
+	// containers to lock or unlock, rather than the whole controller
+	containers []string
+	// when the lock expires, if it does
+	lockedUntil time.Time
+	// whether to write to the policy file rather than the manifest
+	inPolicyFile bool
 
 	cause update.Cause
 
@@ -44,14 +53,48 @@ Tag filter patterns must be specified as 'container=pattern', such as 'foo=1.*'
 where an asterisk means 'match anything'.
 Surrounding these with single-quotes are recommended to avoid shell expansion.
 
+Patterns may instead be semantic version constraints, prefixed with 'semver:',
+such as 'foo=semver:~1.4' or 'foo=semver:>=2.0.0 <3.0.0'. With these, the
+latest image is the highest version satisfying the constraint, rather than
+the most recently built. Pre-release versions are not considered, unless the
+prefix is 'semver-prerelease:' instead, as in 'foo=semver-prerelease:~1.5'.
+
+Patterns may also be regular expressions, prefixed with 'regex:', such as
+'foo=regex:^master-[0-9]+-[0-9a-f]+$'. If the expression has a named capture
+group, such as 'foo=regex:^master-(?P<build>[0-9]+)-[0-9a-f]+$', the latest
+image is the one with the highest value for that group (compared as numbers,
+if they are numbers), rather than the most recently built. To choose how the
+values are compared, use the prefix 'regex-num:' for numbers or 'regex-lex:'
+for strings instead of 'regex:'.
+
 If both --tag-all and --tag are specified, --tag-all will apply to all
 containers which aren't explicitly named.
+
+A minimum age can be given per container as 'container=duration', such as
+'foo=30m'; automation will not release images to the container until they are
+at least that old. A duration of zero removes the minimum age.
+
+With --in-policy-file, the policies are written to the policy file (.flux.yaml)
+at the root of the daemon's git path, instead of the controller's manifest;
+e.g., if the manifest is generated, and annotations would be overwritten.
+Annotations on the controller take precedence over the policy file.
+
+With --pin-digests, images released to the controller are named by digest
+as well as tag, e.g., 'foo:1.4.2@sha256:2ec1...', so that what runs is exactly
+the image released even if the tag is later moved.
         `,
 		Example: makeExample(
 			"fluxctl policy --controller=deployment/foo --automate",
 			"fluxctl policy --controller=deployment/foo --lock",
 			"fluxctl policy --controller=deployment/foo --tag='bar=1.*' --tag='baz=2.*'",
 			"fluxctl policy --controller=deployment/foo --tag-all='master-*' --tag='bar=1.*'",
+			"fluxctl policy --controller=deployment/foo --tag='bar=semver:~1.4'",
+			"fluxctl policy --controller=deployment/foo --tag='bar=semver-prerelease:~1.5'",
+			"fluxctl policy --controller=deployment/foo --tag='bar=regex:^master-(?P<build>[0-9]+)-[0-9a-f]+$'",
+			"fluxctl policy --controller=deployment/foo --tag='bar=regex-num:^(?P<build>[0-9]+)$'",
+			"fluxctl policy --controller=deployment/foo --min-age='bar=30m'",
+			"fluxctl policy --controller=deployment/foo --pin-digests",
+			"fluxctl policy --controller=deployment/foo --automate --in-policy-file",
 		),
 		RunE: opts.RunE,
 	}
@@ -63,10 +106,14 @@ containers which aren't explicitly named.
 	flags.StringVarP(&opts.controller, "controller", "c", "", "Controller to modify")
 	flags.StringVar(&opts.tagAll, "tag-all", "", "Tag filter pattern to apply to all containers")
 	flags.StringSliceVar(&opts.tags, "tag", nil, "Tag filter container/pattern pairs")
+	flags.StringSliceVar(&opts.minAges, "min-age", nil, "Minimum image age container/duration pairs")
 	flags.BoolVar(&opts.automate, "automate", false, "Automate controller")
 	flags.BoolVar(&opts.deautomate, "deautomate", false, "Deautomate controller")
 	flags.BoolVar(&opts.lock, "lock", false, "Lock controller")
 	flags.BoolVar(&opts.unlock, "unlock", false, "Unlock controller")
+	flags.BoolVar(&opts.pin, "pin-digests", false, "Pin images released to controller by digest")
+	flags.BoolVar(&opts.unpin, "unpin-digests", false, "Stop pinning images released to controller by digest")
+	flags.BoolVar(&opts.inPolicyFile, "in-policy-file", false, "Write the policies to the policy file, rather than the controller's manifest")
 
 	// Deprecated
 	flags.StringVarP(&opts.service, "service", "s", "", "Service to modify")
@@ -91,6 +138,12 @@ func (opts *controllerPolicyOpts) RunE(cmd *cobra.Command, args []string) error
 	if opts.lock && opts.unlock {
 		return newUsageError("lock and unlock both specified")
 	}
+	if opts.pin && opts.unpin {
+		return newUsageError("pin-digests and unpin-digests both specified")
+	}
+	if opts.inPolicyFile && opts.tagAll != "" {
+		return newUsageError("tag-all cannot be used with in-policy-file; use --tag for each container")
+	}
 
 	resourceID, err := flux.ParseResourceIDOptionalNamespace(opts.namespace, opts.controller)
 	if err != nil {
@@ -118,7 +171,11 @@ func calculatePolicyChanges(opts *controllerPolicyOpts) (policy.Update, error) {
 	if opts.automate {
 		add = add.Add(policy.Automated)
 	}
-	if opts.lock {
+	if opts.lock && len(opts.containers) > 0 {
+		for _, container := range opts.containers {
+			add = add.Add(policy.LockedPrefix(container))
+		}
+	} else if opts.lock {
 		add = add.Add(policy.Locked)
 		if opts.cause.User != "" {
 			add = add.
@@ -126,19 +183,43 @@ func calculatePolicyChanges(opts *controllerPolicyOpts) (policy.Update, error) {
 				Set(policy.LockedMsg, opts.cause.Message)
 		}
 	}
+	if opts.lock && !opts.lockedUntil.IsZero() {
+		add = add.Set(policy.LockedUntil, opts.lockedUntil.UTC().Format(time.RFC3339))
+	}
+
+	if opts.pin {
+		add = add.Add(policy.PinDigest)
+	}
 
 	remove := policy.Set{}
 	if opts.deautomate {
 		remove = remove.Add(policy.Automated)
 	}
-	if opts.unlock {
+	if opts.unpin {
+		remove = remove.Add(policy.PinDigest)
+	}
+	if opts.unlock && len(opts.containers) > 0 {
+		for _, container := range opts.containers {
+			remove = remove.Add(policy.LockedPrefix(container))
+		}
+	} else if opts.unlock {
 		remove = remove.
 			Add(policy.Locked).
 			Add(policy.LockedMsg).
-			Add(policy.LockedUser)
+			Add(policy.LockedUser).
+			Add(policy.LockedUntil)
+	}
+	// Locking again without an expiry makes the lock last until it's
+	// removed.
+	if opts.lock && len(opts.containers) == 0 && opts.lockedUntil.IsZero() {
+		remove = remove.Add(policy.LockedUntil)
 	}
 	if opts.tagAll != "" {
-		add = add.Set(policy.TagAll, "glob:"+opts.tagAll)
+		tagAll, err := normaliseTagPattern(opts.tagAll)
+		if err != nil {
+			return policy.Update{}, err
+		}
+		add = add.Set(policy.TagAll, tagAll)
 	}
 
 	for _, tagPair := range opts.tags {
@@ -148,15 +229,49 @@ func calculatePolicyChanges(opts *controllerPolicyOpts) (policy.Update, error) {
 		}
 
 		container, tag := parts[0], parts[1]
-		if tag != "*" {
-			add = add.Set(policy.TagPrefix(container), "glob:"+tag)
+		pattern, err := normaliseTagPattern(tag)
+		if err != nil {
+			return policy.Update{}, err
+		}
+		if pattern != policy.PatternAll.String() {
+			add = add.Set(policy.TagPrefix(container), pattern)
 		} else {
 			remove = remove.Add(policy.TagPrefix(container))
 		}
 	}
 
+	for _, agePair := range opts.minAges {
+		parts := strings.Split(agePair, "=")
+		if len(parts) != 2 {
+			return policy.Update{}, fmt.Errorf("invalid container/duration pair: %q. Expected format is 'container=duration'", agePair)
+		}
+
+		container, value := parts[0], parts[1]
+		age, err := policy.ParseMinAge(value)
+		if err != nil {
+			return policy.Update{}, newUsageError(err.Error())
+		}
+		if age > 0 {
+			add = add.Set(policy.MinAgePrefix(container), value)
+		} else {
+			remove = remove.Add(policy.MinAgePrefix(container))
+		}
+	}
+
 	return policy.Update{
-		Add:    add,
-		Remove: remove,
+		Add:          add,
+		Remove:       remove,
+		InPolicyFile: opts.inPolicyFile,
 	}, nil
 }
+
+// normaliseTagPattern checks that a tag filter given on the command
+// line is valid, and returns it in the form it should take in a
+// policy; i.e., with a prefix.
+func normaliseTagPattern(tag string) (string, error) {
+	pattern, err := policy.ParsePattern(tag)
+	if err != nil {
+		return "", newUsageError(err.Error())
+	}
+	return pattern.String(), nil
+}
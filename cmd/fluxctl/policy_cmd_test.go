@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/weaveworks/flux/policy"
+)
+
+func TestCalculatePolicyChanges_Tags(t *testing.T) {
+	for _, x := range []struct {
+		tag     string
+		pattern string
+		err     bool
+	}{
+		{"foo=master-*", "glob:master-*", false},
+		{"foo=semver:~1.4", "semver:~1.4", false},
+		{"foo=semver-prerelease:~1.5", "semver-prerelease:~1.5", false},
+		{"foo=semver-prerelease:not a version", "", true},
+		{"foo=regex:^master-(?P<build>[0-9]+)$", "regex:^master-(?P<build>[0-9]+)$", false},
+		{"foo=regex-num:^master-(?P<build>[0-9]+)$", "regex-num:^master-(?P<build>[0-9]+)$", false},
+		{"foo=regex-lex:^(?P<date>[0-9-]+)$", "regex-lex:^(?P<date>[0-9-]+)$", false},
+		{"foo=regex:^master-(", "", true},
+		{"foo=regex-num:^master-(", "", true},
+	} {
+		update, err := calculatePolicyChanges(&controllerPolicyOpts{tags: []string{x.tag}})
+		if x.err {
+			if _, ok := err.(usageError); !ok {
+				t.Errorf("%q: expected usage error, got %v", x.tag, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", x.tag, err)
+			continue
+		}
+		if got, _ := update.Add.Get(policy.TagPrefix("foo")); got != x.pattern {
+			t.Errorf("%q: expected pattern %q, got %q", x.tag, x.pattern, got)
+		}
+	}
+}
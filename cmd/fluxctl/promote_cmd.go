@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/update"
+)
+
+type controllerPromoteOpts struct {
+	*rootOpts
+	namespace string
+	from      string
+	to        string
+	exclude   []string
+	dryRun    bool
+	outputOpts
+	cause update.Cause
+}
+
+func newControllerPromote(parent *rootOpts) *controllerPromoteOpts {
+	return &controllerPromoteOpts{rootOpts: parent}
+}
+
+func (opts *controllerPromoteOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "promote",
+		Short: "Promote the images running in one namespace or controller to another.",
+		Long: `
+Promote the images running in one namespace or controller to another.
+
+If --from and --to are namespaces, each controller in the target
+namespace gets the images running in the controller of the same kind
+and name in the source namespace. If they are controllers, the target
+controller gets the images running in the source controller. In both
+cases, containers are matched by name.`,
+		Example: makeExample(
+			"fluxctl promote --from=staging --to=production",
+			"fluxctl promote --from=staging:deployment/foo --to=production:deployment/foo",
+			"fluxctl promote --from=staging --to=production --exclude=deployment/bar --dry-run",
+		),
+		RunE: opts.RunE,
+	}
+
+	AddOutputFlags(cmd, &opts.outputOpts)
+	AddCauseFlags(cmd, &opts.cause)
+	cmd.Flags().StringVar(&opts.from, "from", "", "namespace or controller to promote images from")
+	cmd.Flags().StringVar(&opts.to, "to", "", "namespace or controller to promote images to")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace of excluded controllers given as <kind>/<name>")
+	cmd.Flags().StringSliceVar(&opts.exclude, "exclude", []string{}, "exclude a controller")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "do not promote anything; just report back what would have been done")
+
+	return cmd
+}
+
+func (opts *controllerPromoteOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errorWantedNoArgs
+	}
+
+	if opts.from == "" || opts.to == "" {
+		return newUsageError("please supply both --from and --to")
+	}
+	from, err := update.ParsePromoteScope(opts.from)
+	if err != nil {
+		return err
+	}
+	to, err := update.ParsePromoteScope(opts.to)
+	if err != nil {
+		return err
+	}
+	_, fromID := from.AsID()
+	_, toID := to.AsID()
+	if fromID != toID {
+		return newUsageError("--from and --to must both be namespaces, or both be controllers")
+	}
+	if from == to {
+		return newUsageError("--from and --to must be different")
+	}
+
+	var kind update.ReleaseKind = update.ReleaseKindExecute
+	if opts.dryRun {
+		kind = update.ReleaseKindPlan
+	}
+
+	var excludes []flux.ResourceID
+	for _, exclude := range opts.exclude {
+		s, err := flux.ParseResourceIDOptionalNamespace(opts.namespace, exclude)
+		if err != nil {
+			return err
+		}
+		excludes = append(excludes, s)
+	}
+
+	if opts.dryRun {
+		fmt.Fprintf(cmd.OutOrStderr(), "Submitting dry-run promotion...\n")
+	} else {
+		fmt.Fprintf(cmd.OutOrStderr(), "Submitting promotion ...\n")
+	}
+
+	ctx := context.Background()
+
+	jobID, err := opts.API.Promote(ctx, update.PromoteSpec{
+		From:     from,
+		To:       to,
+		Kind:     kind,
+		Excludes: excludes,
+	}, opts.cause)
+	if err != nil {
+		return err
+	}
+
+	return await(ctx, cmd.OutOrStdout(), cmd.OutOrStderr(), opts.API, jobID, !opts.dryRun, opts.verbose)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/spf13/cobra"
@@ -15,10 +16,12 @@ type controllerReleaseOpts struct {
 	namespace      string
 	controllers    []string
 	allControllers bool
-	image          string
+	selector       string
+	images         []string
 	allImages      bool
 	exclude        []string
 	dryRun         bool
+	savePlan       bool
 	outputOpts
 	cause update.Cause
 
@@ -37,7 +40,11 @@ func (opts *controllerReleaseOpts) Command() *cobra.Command {
 		Example: makeExample(
 			"fluxctl release -n default --controller=deployment/foo --update-image=library/hello:v2",
 			"fluxctl release --all --update-image=library/hello:v2",
+			"fluxctl release --all --update-image=library/frontend:v2 --update-image=library/backend:v2",
 			"fluxctl release --controller=default:deployment/foo --update-all-images",
+			"fluxctl release --all --update-all-images --dry-run --save-plan",
+			"fluxctl release --selector=team=payments --update-all-images",
+			"fluxctl release --namespace=payments --all --update-image=library/hello:v2",
 		),
 		RunE: opts.RunE,
 	}
@@ -46,11 +53,13 @@ func (opts *controllerReleaseOpts) Command() *cobra.Command {
 	AddCauseFlags(cmd, &opts.cause)
 	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "controller namespace")
 	cmd.Flags().StringSliceVarP(&opts.controllers, "controller", "c", []string{}, "list of controllers to release <kind>/<name>")
-	cmd.Flags().BoolVar(&opts.allControllers, "all", false, "release all controllers")
-	cmd.Flags().StringVarP(&opts.image, "update-image", "i", "", "update a specific image")
+	cmd.Flags().BoolVar(&opts.allControllers, "all", false, "release all controllers; if --namespace is given, all controllers in that namespace")
+	cmd.Flags().StringVar(&opts.selector, "selector", "", "release controllers with labels matching the selector given, e.g., team=payments; if --namespace is given, only those in that namespace")
+	cmd.Flags().StringSliceVarP(&opts.images, "update-image", "i", []string{}, "update a specific image; give more than once to update several images in one release")
 	cmd.Flags().BoolVar(&opts.allImages, "update-all-images", false, "update all images to latest versions")
 	cmd.Flags().StringSliceVar(&opts.exclude, "exclude", []string{}, "exclude a controller")
 	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "do not release anything; just report back what would have been done")
+	cmd.Flags().BoolVar(&opts.savePlan, "save-plan", false, "with --dry-run, keep the release plan so it can be applied later with fluxctl apply-plan")
 
 	// Deprecated
 	cmd.Flags().StringSliceVarP(&opts.services, "service", "s", []string{}, "service to release")
@@ -68,18 +77,42 @@ func (opts *controllerReleaseOpts) RunE(cmd *cobra.Command, args []string) error
 		return errorWantedNoArgs
 	}
 
-	if err := checkExactlyOne("--update-image=<image> or --update-all-images", opts.image != "", opts.allImages); err != nil {
+	if err := checkExactlyOne("--update-image=<image> or --update-all-images", len(opts.images) > 0, opts.allImages); err != nil {
 		return err
 	}
 
-	if len(opts.controllers) <= 0 && !opts.allControllers {
-		return newUsageError("please supply either --all, or at least one --controller=<controller>")
+	if opts.savePlan && !opts.dryRun {
+		return newUsageError("--save-plan can only be used with --dry-run")
+	}
+
+	if len(opts.controllers) <= 0 && !opts.allControllers && opts.selector == "" {
+		return newUsageError("please supply either --all, --selector=<selector>, or at least one --controller=<controller>")
+	}
+	if opts.allControllers && opts.selector != "" {
+		return newUsageError("please supply only one of --all and --selector=<selector>")
+	}
+
+	// The namespace scopes --all and --selector only when it's given
+	// explicitly, since otherwise it's the default namespace.
+	var namespace string
+	if cmd.Flags().Changed("namespace") {
+		namespace = opts.namespace
 	}
 
 	var controllers []update.ResourceSpec
-	if opts.allControllers {
+	switch {
+	case opts.allControllers && namespace == "":
 		controllers = []update.ResourceSpec{update.ResourceSpecAll}
-	} else {
+	case opts.allControllers:
+		controllers = []update.ResourceSpec{update.MakeSelectorSpec(update.ResourceSelector{Namespace: namespace})}
+	default:
+		if opts.selector != "" {
+			labels, err := update.ParseLabelSelector(opts.selector)
+			if err != nil {
+				return err
+			}
+			controllers = append(controllers, update.MakeSelectorSpec(update.ResourceSelector{Namespace: namespace, Labels: labels}))
+		}
 		for _, controller := range opts.controllers {
 			id, err := flux.ParseResourceIDOptionalNamespace(opts.namespace, controller)
 			if err != nil {
@@ -89,18 +122,18 @@ func (opts *controllerReleaseOpts) RunE(cmd *cobra.Command, args []string) error
 		}
 	}
 
-	var (
-		image update.ImageSpec
-		err   error
-	)
+	var images []update.ImageSpec
 	switch {
-	case opts.image != "":
-		image, err = update.ParseImageSpec(opts.image)
-		if err != nil {
-			return err
+	case len(opts.images) > 0:
+		for _, imageOpt := range opts.images {
+			image, err := update.ParseImageSpec(imageOpt)
+			if err != nil {
+				return err
+			}
+			images = append(images, image)
 		}
 	case opts.allImages:
-		image = update.ImageSpecLatest
+		images = []update.ImageSpec{update.ImageSpecLatest}
 	}
 
 	var kind update.ReleaseKind = update.ReleaseKindExecute
@@ -125,15 +158,30 @@ func (opts *controllerReleaseOpts) RunE(cmd *cobra.Command, args []string) error
 
 	ctx := context.Background()
 
-	jobID, err := opts.API.UpdateImages(ctx, update.ReleaseSpec{
+	spec := update.ReleaseSpec{
 		ServiceSpecs: controllers,
-		ImageSpec:    image,
 		Kind:         kind,
 		Excludes:     excludes,
-	}, opts.cause)
+		SavePlan:     opts.savePlan,
+	}
+	spec.ImageSpec, spec.ImageSpecs = update.ReleaseImages(images...)
+	jobID, err := opts.API.UpdateImages(ctx, spec, opts.cause)
 	if err != nil {
 		return err
 	}
 
-	return await(ctx, cmd.OutOrStdout(), cmd.OutOrStderr(), opts.API, jobID, !opts.dryRun, opts.verbose)
+	if !opts.savePlan {
+		return await(ctx, cmd.OutOrStdout(), cmd.OutOrStderr(), opts.API, jobID, !opts.dryRun, opts.verbose)
+	}
+	metadata, err := awaitResult(ctx, cmd.OutOrStdout(), cmd.OutOrStderr(), opts.API, jobID, false, opts.verbose)
+	if err != nil {
+		return err
+	}
+	if metadata.PlanID == "" {
+		// Older daemons don't know about saving plans, and just do
+		// the dry run.
+		return errors.New("the daemon did not save the release plan; it may be a version that does not support saving plans")
+	}
+	fmt.Fprintf(cmd.OutOrStderr(), "To apply the plan:\tfluxctl apply-plan %s\n", metadata.PlanID)
+	return nil
 }
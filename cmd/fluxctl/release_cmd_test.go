@@ -1,6 +1,7 @@
 package main //+integration
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/weaveworks/flux/update"
@@ -26,11 +27,31 @@ func TestReleaseCommand_CLIConversion(t *testing.T) {
 			"image":   "alpine:latest",
 			"kind":    string(update.ReleaseKindExecute),
 		}},
+		{[]string{"--update-image=alpine:latest", "--update-image=nginx:1.13", "--all"}, map[string]string{
+			"service": string(update.ResourceSpecAll),
+			"image":   "alpine:latest,nginx:1.13",
+			"kind":    string(update.ReleaseKindExecute),
+		}},
 		{[]string{"--update-all-images", "--controller=deployment/flux"}, map[string]string{
 			"service": "default:deployment/flux",
 			"image":   string(update.ImageSpecLatest),
 			"kind":    string(update.ReleaseKindExecute),
 		}},
+		{[]string{"--update-all-images", "--selector=team=payments"}, map[string]string{
+			"service": "<team=payments>",
+			"image":   string(update.ImageSpecLatest),
+			"kind":    string(update.ReleaseKindExecute),
+		}},
+		{[]string{"--update-all-images", "--namespace=payments", "--selector=team=payments,tier!=web"}, map[string]string{
+			"service": "payments:<team=payments,tier!=web>",
+			"image":   string(update.ImageSpecLatest),
+			"kind":    string(update.ReleaseKindExecute),
+		}},
+		{[]string{"--update-all-images", "--namespace=payments", "--all"}, map[string]string{
+			"service": "payments:<all>",
+			"image":   string(update.ImageSpecLatest),
+			"kind":    string(update.ReleaseKindExecute),
+		}},
 		{[]string{"--update-all-images", "--all", "--exclude=deployment/test,deployment/yeah"}, map[string]string{
 			"service": string(update.ResourceSpecAll),
 			"image":   string(update.ImageSpecLatest),
@@ -42,6 +63,10 @@ func TestReleaseCommand_CLIConversion(t *testing.T) {
 
 		// Check that PostRelease was called with correct args
 		method := "UpdateImages"
+		// Releases of several images go to their own route
+		if strings.Contains(v.expectedParams["image"], ",") {
+			method = "UpdateImagesV10"
+		}
 		if calledURL(method, svc.requestHistory) == nil {
 			t.Fatalf("Expecting fluxctl to request %q, but did not.", method)
 		}
@@ -66,8 +91,12 @@ func TestReleaseCommand_InputFailures(t *testing.T) {
 		{[]string{}, "Should error when no args"},
 		{[]string{"--all"}, "Should error when not specifying image spec"},
 		{[]string{"--all", "--update-image=alpine"}, "Should error with invalid image spec"},
+		{[]string{"--all", "--update-image=alpine:3.7", "--update-all-images"}, "Should error with both specific and all images"},
 		{[]string{"--update-all-images"}, "Should error when not specifying controller spec"},
 		{[]string{"--controller=invalid&controller", "--update-all-images"}, "Should error with invalid controller"},
+		{[]string{"--all", "--selector=team=payments", "--update-all-images"}, "Should error with both --all and --selector"},
+		{[]string{"--selector=team", "--update-all-images"}, "Should error with invalid selector"},
+		{[]string{"--all", "--update-all-images", "--save-plan"}, "Should error when saving a plan without --dry-run"},
 		{[]string{"subcommand"}, "Should error when given subcommand"},
 	} {
 		testArgs(t, v.args, true, v.msg)
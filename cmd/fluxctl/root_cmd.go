@@ -61,6 +61,8 @@ func (opts *rootOpts) Command() *cobra.Command {
 		newControllerShow(opts).Command(),
 		newControllerList(opts).Command(),
 		newControllerRelease(opts).Command(),
+		newControllerPromote(opts).Command(),
+		newApplyPlan(opts).Command(),
 		newServiceAutomate(opts).Command(),
 		newControllerDeautomate(opts).Command(),
 		newControllerLock(opts).Command(),
@@ -10,6 +10,7 @@ type controllerUnlockOpts struct {
 	*rootOpts
 	namespace  string
 	controller string
+	containers []string
 	outputOpts
 	cause update.Cause
 
@@ -27,6 +28,7 @@ func (opts *controllerUnlockOpts) Command() *cobra.Command {
 		Short: "Unlock a controller, so it can be deployed.",
 		Example: makeExample(
 			"fluxctl unlock --controller=deployment/helloworld",
+			"fluxctl unlock --controller=deployment/helloworld --container=sidecar",
 		),
 		RunE: opts.RunE,
 	}
@@ -34,6 +36,7 @@ func (opts *controllerUnlockOpts) Command() *cobra.Command {
 	AddCauseFlags(cmd, &opts.cause)
 	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "Controller namespace")
 	cmd.Flags().StringVarP(&opts.controller, "controller", "c", "", "Controller to unlock")
+	cmd.Flags().StringSliceVar(&opts.containers, "container", nil, "Unlock only this container, rather than the whole controller; give more than once for several containers")
 
 	// Deprecate
 	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to unlock")
@@ -53,6 +56,7 @@ func (opts *controllerUnlockOpts) RunE(cmd *cobra.Command, args []string) error
 		controller: opts.controller,
 		cause:      opts.cause,
 		unlock:     true,
+		containers: opts.containers,
 	}
 	return policyOpts.RunE(cmd, args)
 }
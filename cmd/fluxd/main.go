@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
@@ -32,8 +33,10 @@ import (
 	"github.com/weaveworks/flux/job"
 	"github.com/weaveworks/flux/registry"
 	"github.com/weaveworks/flux/registry/cache"
+	registryDisk "github.com/weaveworks/flux/registry/cache/disk"
 	registryMemcache "github.com/weaveworks/flux/registry/cache/memcached"
 	registryMiddleware "github.com/weaveworks/flux/registry/middleware"
+	"github.com/weaveworks/flux/release"
 	"github.com/weaveworks/flux/remote"
 	"github.com/weaveworks/flux/ssh"
 )
@@ -85,14 +88,28 @@ func main() {
 		gitNotesRef = fs.String("git-notes-ref", defaultGitNotesRef, "ref to use for keeping commit annotations in git notes")
 
 		gitPollInterval = fs.Duration("git-poll-interval", 5*time.Minute, "period at which to poll git repo for new commits")
+		releasePlanDir  = fs.String("release-plan-dir", filepath.Join(os.TempDir(), "fluxd-plans"), "directory in which to keep release plans saved by dry runs, until they are applied; empty to disable saving plans")
+		releasePlanTTL  = fs.Duration("release-plan-ttl", 24*time.Hour, "how long to keep a release plan saved by a dry run before discarding it; 0 to keep plans until they are applied")
 		// registry
-		memcachedHostname    = fs.String("memcached-hostname", "memcached", "Hostname for memcached service.")
-		memcachedTimeout     = fs.Duration("memcached-timeout", time.Second, "Maximum time to wait before giving up on memcached requests.")
-		memcachedService     = fs.String("memcached-service", "memcached", "SRV service used to discover memcache servers.")
-		registryCacheExpiry  = fs.Duration("registry-cache-expiry", 1*time.Hour, "Duration to keep cached image info. Must be < 1 month.")
-		registryPollInterval = fs.Duration("registry-poll-interval", 5*time.Minute, "period at which to check for updated images")
-		registryRPS          = fs.Int("registry-rps", 200, "maximum registry requests per second per host")
-		registryBurst        = fs.Int("registry-burst", defaultRemoteConnections, "maximum number of warmer connections to remote and memcache")
+		memcachedHostname         = fs.String("memcached-hostname", "memcached", "Hostname for memcached service.")
+		memcachedTimeout          = fs.Duration("memcached-timeout", time.Second, "Maximum time to wait before giving up on memcached requests.")
+		memcachedService          = fs.String("memcached-service", "memcached", "SRV service used to discover memcache servers.")
+		registryCacheDir          = fs.String("registry-cache-dir", "", "directory in which to keep the registry cache, e.g., on a local volume, instead of using memcached; empty to use memcached")
+		registryCacheExpiry       = fs.Duration("registry-cache-expiry", 1*time.Hour, "Duration to keep cached image info. Must be < 1 month.")
+		registryPollInterval      = fs.Duration("registry-poll-interval", 5*time.Minute, "period at which to check for updated images")
+		registryRPS               = fs.Int("registry-rps", 200, "maximum registry requests per second per host")
+		registryBurst             = fs.Int("registry-burst", defaultRemoteConnections, "maximum number of warmer connections to remote and memcache")
+		automationWindow          = fs.Duration("automation-window", 0, "period to keep looking for new images for automated controllers, once some are found, before releasing them in a single commit; zero means release them straight away")
+		registryCredentialHelpers = fs.StringSlice("registry-credential-helper", nil, "Docker credential helper to get registry credentials from, as <host>=<helper> (the host may be a glob pattern), or just <helper> to use it for any host it has credentials for; may be repeated")
+		registryECR               = fs.Bool("registry-ecr", true, "get authorization tokens for AWS ECR hosts using the pod's AWS credentials, in preference to image pull secrets")
+		registryECREndpoint       = fs.String("registry-ecr-endpoint", "", "URL of the ECR API to get authorization tokens from, e.g., a VPC endpoint; empty to use the regional AWS endpoint for each host")
+		registryHookSecret        = fs.String("registry-hook-secret", "", "shared secret that requests to the registry webhook endpoints must give, as the secret query parameter or a bearer token; empty to accept any request")
+		registryPlatform          = fs.String("registry-platform", registry.DefaultPlatform.String(), "platform, as <os>/<arch>[/<variant>], of the image to look at when an image has a manifest list with images for several platforms")
+		registryCABundles         = fs.StringSlice("registry-ca-bundle", nil, "PEM bundle of extra CA certificates to trust for a registry host, as <host>=<file>; may be repeated")
+		registryInsecureHosts     = fs.StringSlice("registry-insecure-skip-verify", nil, "registry host for which not to verify the TLS certificate; may be repeated")
+		registryPlainHTTPHosts    = fs.StringSlice("registry-plain-http", nil, "registry host to connect to with plain HTTP rather than HTTPS, e.g., localhost:5000; may be repeated")
+		registryMirrors           = fs.StringSlice("registry-mirror", nil, "mirror (e.g., a pull-through cache) to look up image metadata in before the registry itself, as <registry>=<mirror host>; may be repeated, and mirrors are tried in the order given")
+		registryPinDigests        = fs.Bool("registry-pin-digests", false, "pin images by digest (as <image>:<tag>@<digest>) when releasing them to any controller, rather than only to those with the pin_digest policy")
 
 		// k8s-secret backed ssh keyring configuration
 		k8sSecretName            = fs.String("k8s-secret-name", "flux-git-deploy", "Name of the k8s secret used to store the private SSH key")
@@ -235,17 +252,31 @@ func main() {
 	{
 		// Cache client, for use by registry and cache warmer
 		var cacheClient cache.Client
-		memcacheClient := registryMemcache.NewMemcacheClient(registryMemcache.MemcacheConfig{
-			Host:           *memcachedHostname,
-			Service:        *memcachedService,
-			Expiry:         *registryCacheExpiry,
-			Timeout:        *memcachedTimeout,
-			UpdateInterval: 1 * time.Minute,
-			Logger:         log.With(logger, "component", "memcached"),
-			MaxIdleConns:   *registryBurst,
-		})
-		defer memcacheClient.Stop()
-		cacheClient = cache.InstrumentClient(memcacheClient)
+		if *registryCacheDir != "" {
+			diskClient, err := registryDisk.NewClient(registryDisk.Config{
+				Dir:    *registryCacheDir,
+				Expiry: *registryCacheExpiry,
+				Logger: log.With(logger, "component", "diskcache"),
+			})
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			defer diskClient.Stop()
+			cacheClient = cache.InstrumentClient(diskClient)
+		} else {
+			memcacheClient := registryMemcache.NewMemcacheClient(registryMemcache.MemcacheConfig{
+				Host:           *memcachedHostname,
+				Service:        *memcachedService,
+				Expiry:         *registryCacheExpiry,
+				Timeout:        *memcachedTimeout,
+				UpdateInterval: 1 * time.Minute,
+				Logger:         log.With(logger, "component", "memcached"),
+				MaxIdleConns:   *registryBurst,
+			})
+			defer memcacheClient.Stop()
+			cacheClient = cache.InstrumentClient(memcacheClient)
+		}
 
 		cacheRegistry = &cache.Cache{
 			Reader: cacheClient,
@@ -255,16 +286,47 @@ func main() {
 		// Remote client, for warmer to refresh entries
 		registryLogger := log.With(logger, "component", "registry")
 		registryLimits := &registryMiddleware.RateLimiters{
-			RPS:   *registryRPS,
-			Burst: *registryBurst,
+			RPS:      *registryRPS,
+			Burst:    *registryBurst,
+			Observer: registry.RateLimitMetrics,
+		}
+		credentialHelpers, err := registry.ParseCredentialHelpers(*registryCredentialHelpers)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		credentialHelpers.Logger = log.With(logger, "component", "credential-helper")
+		platform, err := registry.ParsePlatform(*registryPlatform)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		hostConfigs, err := registry.ParseHostConfigs(*registryCABundles, *registryInsecureHosts, *registryPlainHTTPHosts)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		mirrors, err := registry.ParseMirrors(*registryMirrors)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
 		}
 		remoteFactory := &registry.RemoteClientFactory{
-			Logger:   registryLogger,
-			Limiters: registryLimits,
+			Logger:            registryLogger,
+			Limiters:          registryLimits,
+			CredentialHelpers: credentialHelpers,
+			Platform:          platform,
+			Hosts:             hostConfigs,
+			Mirrors:           mirrors,
+		}
+		if *registryECR {
+			remoteFactory.ECR = &registry.ECRTokens{
+				Endpoint: *registryECREndpoint,
+				Logger:   log.With(logger, "component", "ecr"),
+			}
 		}
 
 		// Warmer
-		var err error
 		cacheWarmer, err = cache.NewWarmer(remoteFactory, cacheClient, *registryBurst)
 		if err != nil {
 			logger.Log("err", err)
@@ -336,7 +398,7 @@ func main() {
 	go func() {
 		mux := http.NewServeMux()
 		mux.Handle("/metrics", promhttp.Handler())
-		handler := daemonhttp.NewHandler(daemonRef, daemonhttp.NewRouter())
+		handler := daemonhttp.NewHandler(daemonRef, daemonhttp.NewRouter(), *registryHookSecret)
 		mux.Handle("/api/flux/", http.StripPrefix("/api/flux", handler))
 		logger.Log("addr", *listenAddr)
 		errc <- http.ListenAndServe(*listenAddr, mux)
@@ -420,6 +482,15 @@ func main() {
 		jobs = job.NewQueue(shutdown, shutdownWg)
 	}
 
+	var plans *release.PlanStore
+	if *releasePlanDir != "" {
+		plans, err = release.NewPlanStore(*releasePlanDir, *releasePlanTTL)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+	}
+
 	daemon := &daemon.Daemon{
 		V:            version,
 		Cluster:      k8s,
@@ -429,11 +500,14 @@ func main() {
 		Repo:         repo, Checkout: checkout,
 		Jobs:           jobs,
 		JobStatusCache: &job.StatusCache{Size: 100},
+		PinDigests:     *registryPinDigests,
+		Plans:          plans,
 
 		EventWriter: eventWriter,
 		Logger:      log.With(logger, "component", "daemon"), LoopVars: &daemon.LoopVars{
 			GitPollInterval:      *gitPollInterval,
 			RegistryPollInterval: *registryPollInterval,
+			AutomationWindow:     *automationWindow,
 		},
 	}
 
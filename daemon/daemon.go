@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"path/filepath"
 	"sort"
 	"time"
 
@@ -48,6 +49,13 @@ type Daemon struct {
 	JobStatusCache *job.StatusCache
 	EventWriter    event.EventWriter
 	Logger         log.Logger
+	// PinDigests says whether to pin images by digest when releasing
+	// them to any controller, rather than only to those with the
+	// pin_digest policy.
+	PinDigests bool
+	// Plans keeps dry-run releases that were asked to be saved, so
+	// they can be applied later; if nil, plans can't be saved.
+	Plans *release.PlanStore
 	// bookkeeping
 	*LoopVars
 }
@@ -76,7 +84,7 @@ func (d *Daemon) ListServices(ctx context.Context, namespace string) ([]flux.Con
 	d.Checkout.RLock()
 	defer d.Checkout.RUnlock()
 
-	services, err := d.Manifests.ServicesWithPolicies(d.Checkout.ManifestDir())
+	services, sources, err := d.Manifests.ServicesWithPolicySources(d.Checkout.ManifestDir())
 	if err != nil {
 		return nil, errors.Wrap(err, "getting service policies")
 	}
@@ -85,13 +93,14 @@ func (d *Daemon) ListServices(ctx context.Context, namespace string) ([]flux.Con
 	for _, service := range clusterServices {
 		policies := services[service.ID]
 		res = append(res, flux.ControllerStatus{
-			ID:         service.ID,
-			Containers: containers2containers(service.ContainersOrNil()),
-			Status:     service.Status,
-			Automated:  policies.Contains(policy.Automated),
-			Locked:     policies.Contains(policy.Locked),
-			Ignore:     policies.Contains(policy.Ignore),
-			Policies:   policies.ToStringMap(),
+			ID:            service.ID,
+			Containers:    containers2containers(service.ContainersOrNil()),
+			Status:        service.Status,
+			Automated:     policies.Contains(policy.Automated),
+			Locked:        policies.Contains(policy.Locked),
+			Ignore:        policies.Contains(policy.Ignore),
+			Policies:      policies.ToStringMap(),
+			PolicySources: policySources(sources[service.ID]),
 		})
 	}
 
@@ -104,6 +113,11 @@ func (d *Daemon) ListImages(ctx context.Context, spec update.ResourceSpec) ([]fl
 	var err error
 	if spec == update.ResourceSpecAll {
 		services, err = d.Cluster.AllControllers("")
+	} else if sel, ok := spec.AsSelector(); ok {
+		services, err = d.selectControllers(sel)
+		if err != nil {
+			return nil, errors.Wrap(err, "selecting services")
+		}
 	} else {
 		id, err := spec.AsID()
 		if err != nil {
@@ -117,9 +131,16 @@ func (d *Daemon) ListImages(ctx context.Context, spec update.ResourceSpec) ([]fl
 		return nil, errors.Wrap(err, "getting images for services")
 	}
 
+	d.Checkout.RLock()
+	policies, err := d.Manifests.ServicesWithPolicies(d.Checkout.ManifestDir())
+	d.Checkout.RUnlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting service policies")
+	}
+
 	var res []flux.ImageStatus
 	for _, service := range services {
-		containers := containersWithAvailable(service, images)
+		containers := containersWithAvailable(service, images, policies[service.ID])
 		res = append(res, flux.ImageStatus{
 			ID:         service.ID,
 			Containers: containers,
@@ -194,6 +215,32 @@ func (d *Daemon) queueJob(do DaemonJobFunc) job.ID {
 	return id
 }
 
+// selectControllers returns the running controllers picked out by
+// the selector, going by the labels in their manifests.
+func (d *Daemon) selectControllers(sel update.ResourceSelector) ([]cluster.Controller, error) {
+	controllers, err := d.Cluster.AllControllers(sel.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(sel.Labels) == 0 {
+		return controllers, nil
+	}
+	d.Checkout.RLock()
+	resources, err := d.Manifests.LoadManifests(d.Checkout.ManifestDir())
+	d.Checkout.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	var selected []cluster.Controller
+	for _, c := range controllers {
+		res, ok := resources[c.ID.String()]
+		if ok && sel.Matches(c.ID, res.Labels()) {
+			selected = append(selected, c)
+		}
+	}
+	return selected, nil
+}
+
 // Apply the desired changes to the config files
 func (d *Daemon) UpdateManifests(ctx context.Context, spec update.Spec) (job.ID, error) {
 	var id job.ID
@@ -210,6 +257,15 @@ func (d *Daemon) UpdateManifests(ctx context.Context, spec update.Spec) (job.ID,
 		return d.queueJob(d.release(spec, s)), nil
 	case policy.Updates:
 		return d.queueJob(d.updatePolicy(spec, s)), nil
+	case update.ApplyPlanSpec:
+		if d.Plans == nil {
+			return id, errNoPlanStore
+		}
+		plan, err := d.Plans.Get(s.ID)
+		if err != nil {
+			return id, unknownPlanError(s.ID, err)
+		}
+		return d.queueJob(d.applyPlan(spec, plan)), nil
 	default:
 		return id, fmt.Errorf(`unknown update type "%s"`, spec.Type)
 	}
@@ -228,14 +284,32 @@ func (d *Daemon) updatePolicy(spec update.Spec, updates policy.Updates) DaemonJo
 		// was (probably) set to automated, we will ask for an
 		// automation run straight ASAP.
 		var anythingAutomated bool
+		// The policy file may be new, in which case it needs adding
+		var usedPolicyFile bool
+		// Removing a policy that's also inherited, e.g., from the
+		// namespace, needs an override rather than a removal to
+		// have any effect.
+		var inherited policy.ResourceMap
 
 		for serviceID, u := range updates {
 			if policy.Set(u.Add).Contains(policy.Automated) {
 				anythingAutomated = true
 			}
-			// find the service manifest
-			err := cluster.UpdateManifest(d.Manifests, working.ManifestDir(), serviceID, func(def []byte) ([]byte, error) {
-				newDef, err := d.Manifests.UpdatePolicies(def, u)
+			if len(u.Remove) > 0 {
+				if inherited == nil {
+					var err error
+					if inherited, err = d.Manifests.InheritedPolicies(working.ManifestDir()); err != nil {
+						return nil, err
+					}
+				}
+				u = u.Overriding(inherited[serviceID])
+			}
+			updatePolicies := d.Manifests.UpdatePolicies
+			if u.InPolicyFile {
+				updatePolicies = d.Manifests.UpdatePolicyFile
+			}
+			updateDef := func(def []byte) ([]byte, error) {
+				newDef, err := updatePolicies(def, serviceID, u)
 				if err != nil {
 					metadata.Result[serviceID] = update.ControllerResult{
 						Status: update.ReleaseStatusFailed,
@@ -254,7 +328,15 @@ func (d *Daemon) updatePolicy(spec update.Spec, updates policy.Updates) DaemonJo
 					}
 				}
 				return newDef, nil
-			})
+			}
+			var err error
+			if u.InPolicyFile {
+				err = cluster.UpdatePolicyFile(working.ManifestDir(), updateDef)
+				usedPolicyFile = true
+			} else {
+				// find the service manifest
+				err = cluster.UpdateManifest(d.Manifests, working.ManifestDir(), serviceID, updateDef)
+			}
 			switch err {
 			case cluster.ErrNoResourceFilesFoundForService, cluster.ErrMultipleResourceFilesFoundForService:
 				metadata.Result[serviceID] = update.ControllerResult{
@@ -270,6 +352,11 @@ func (d *Daemon) updatePolicy(spec update.Spec, updates policy.Updates) DaemonJo
 		if len(serviceIDs) == 0 {
 			return metadata, nil
 		}
+		if usedPolicyFile {
+			if err := working.Add(ctx, filepath.Join(working.ManifestDir(), cluster.PolicyFile)); err != nil {
+				return nil, err
+			}
+		}
 
 		commitAuthor := ""
 		if d.Checkout.Config.SetAuthor {
@@ -298,7 +385,16 @@ func (d *Daemon) updatePolicy(spec update.Spec, updates policy.Updates) DaemonJo
 
 func (d *Daemon) release(spec update.Spec, c release.Changes) DaemonJobFunc {
 	return func(ctx context.Context, jobID job.ID, working *git.Checkout, logger log.Logger) (*event.CommitEventMetadata, error) {
-		rc := release.NewReleaseContext(d.Cluster, d.Manifests, d.Registry, working)
+		var savePlan bool
+		if rs, ok := c.(update.ReleaseSpec); ok && rs.SavePlan && rs.Kind == update.ReleaseKindPlan {
+			if d.Plans == nil {
+				return nil, errNoPlanStore
+			}
+			savePlan = true
+		}
+
+		images := release.NewImageRecorder(d.Registry)
+		rc := release.NewReleaseContext(d.Cluster, d.Manifests, images, working, d.PinDigests)
 		result, err := release.Release(rc, c, logger)
 		if err != nil {
 			return nil, err
@@ -306,35 +402,121 @@ func (d *Daemon) release(spec update.Spec, c release.Changes) DaemonJobFunc {
 
 		var revision string
 		if c.ReleaseKind() == update.ReleaseKindExecute {
-			commitMsg := spec.Cause.Message
-			if commitMsg == "" {
-				commitMsg = c.CommitMessage()
+			revision, err = d.commitRelease(ctx, jobID, working, spec, c, result)
+			if err != nil {
+				return nil, err
 			}
-			commitAuthor := ""
-			if d.Checkout.Config.SetAuthor {
-				commitAuthor = spec.Cause.User
+		}
+		metadata := &event.CommitEventMetadata{
+			Revision: revision,
+			Spec:     &spec,
+			Result:   result,
+		}
+
+		if savePlan {
+			plan := release.Plan{
+				ID:        string(jobID),
+				Spec:      spec,
+				Result:    result,
+				CreatedAt: time.Now().UTC(),
 			}
-			commitAction := &git.CommitAction{Author: commitAuthor, Message: commitMsg}
-			if err := working.CommitAndPush(ctx, commitAction, &git.Note{JobID: jobID, Spec: spec, Result: result}); err != nil {
-				// On the chance pushing failed because it was not
-				// possible to fast-forward, ask for a sync so the
-				// next attempt is more likely to succeed.
-				d.AskForSync()
+			if plan.Revision, err = working.HeadRevision(ctx); err != nil {
 				return nil, err
 			}
-			revision, err = working.HeadRevision(ctx)
-			if err != nil {
+			if plan.Images, err = images.Fingerprint(); err != nil {
 				return nil, err
 			}
+			if err = d.Plans.Save(plan); err != nil {
+				return nil, errors.Wrap(err, "saving release plan")
+			}
+			metadata.PlanID = plan.ID
+		}
+		return metadata, nil
+	}
+}
+
+// applyPlan executes a release that was planned earlier. The release
+// is calculated again, and if anything it depended on has changed --
+// the revision of the repo, the images available, or the outcome --
+// it fails without committing, since it would no longer do what was
+// reported when it was planned.
+func (d *Daemon) applyPlan(spec update.Spec, plan release.Plan) DaemonJobFunc {
+	return func(ctx context.Context, jobID job.ID, working *git.Checkout, logger log.Logger) (*event.CommitEventMetadata, error) {
+		planned, ok := plan.Spec.Spec.(release.Changes)
+		if !ok {
+			return nil, fmt.Errorf("release plan %s is not for a release", plan.ID)
+		}
+		c, ok := release.ExecuteSpec(planned)
+		if !ok {
+			return nil, fmt.Errorf("release plan %s cannot be executed", plan.ID)
+		}
+
+		head, err := working.HeadRevision(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if head != plan.Revision {
+			return nil, fmt.Errorf("release plan %s is stale: the repo has moved on from %s to %s since it was made", plan.ID, plan.Revision, head)
+		}
+
+		images := release.NewImageRecorder(d.Registry)
+		rc := release.NewReleaseContext(d.Cluster, d.Manifests, images, working, d.PinDigests)
+		result, err := release.Release(rc, c, logger)
+		if err != nil {
+			return nil, err
+		}
+		fingerprint, err := images.Fingerprint()
+		if err != nil {
+			return nil, err
+		}
+		if fingerprint != plan.Images {
+			return nil, fmt.Errorf("release plan %s is stale: the images available have changed since it was made", plan.ID)
+		}
+		if !release.SameResult(result, plan.Result) {
+			return nil, fmt.Errorf("release plan %s is stale: the release would no longer have the same outcome", plan.ID)
+		}
+
+		// The release is recorded as though it had been asked for
+		// directly, with the cause given when applying the plan.
+		executed := update.Spec{Type: plan.Spec.Type, Cause: spec.Cause, Spec: c}
+		revision, err := d.commitRelease(ctx, jobID, working, executed, c, result)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.Plans.Delete(plan.ID); err != nil {
+			logger.Log("warning", "release plan applied but not removed", "plan", plan.ID, "err", err)
 		}
 		return &event.CommitEventMetadata{
 			Revision: revision,
-			Spec:     &spec,
+			Spec:     &executed,
 			Result:   result,
+			PlanID:   plan.ID,
 		}, nil
 	}
 }
 
+// commitRelease commits and pushes the changes made by a release,
+// returning the new revision.
+func (d *Daemon) commitRelease(ctx context.Context, jobID job.ID, working *git.Checkout, spec update.Spec, c release.Changes, result update.Result) (string, error) {
+	commitMsg := spec.Cause.Message
+	if commitMsg == "" {
+		commitMsg = c.CommitMessage()
+	}
+	commitAuthor := ""
+	if d.Checkout.Config.SetAuthor {
+		commitAuthor = spec.Cause.User
+	}
+	commitAction := &git.CommitAction{Author: commitAuthor, Message: commitMsg}
+	if err := working.CommitAndPush(ctx, commitAction, &git.Note{JobID: jobID, Spec: spec, Result: result}); err != nil {
+		// On the chance pushing failed because it was not
+		// possible to fast-forward, ask for a sync so the
+		// next attempt is more likely to succeed.
+		d.AskForSync()
+		return "", err
+	}
+	return working.HeadRevision(ctx)
+}
+
 // Tell the daemon to synchronise the cluster with the manifests in
 // the git repo. This has an error return value because upstream there
 // may be comms difficulties or other sources of problems; here, we
@@ -453,6 +635,38 @@ daemon if possible:
 	}
 }
 
+var errNoPlanStore = &fluxerr.Error{
+	Type: fluxerr.User,
+	Err:  errors.New("release plans are not enabled"),
+	Help: `Release plans are not enabled
+
+This daemon has been started without a directory in which to keep
+release plans, so it can neither save them nor apply them. To enable
+them, give fluxd a directory with --release-plan-dir.
+`,
+}
+
+func unknownPlanError(id string, err error) error {
+	if errors.Cause(err) != release.ErrPlanNotFound {
+		return err
+	}
+	return &fluxerr.Error{
+		// Not fluxerr.Missing, since that would be taken as the
+		// daemon not supporting plans at all.
+		Type: fluxerr.User,
+		Err:  fmt.Errorf("unknown release plan %q", id),
+		Help: `Release plan not found
+
+Plans are removed once they have been applied, or once they are
+older than the daemon's --release-plan-ttl. They are lost if the
+daemon is restarted without keeping --release-plan-dir on a volume.
+Check the ID given, or make a fresh plan with
+
+    fluxctl release --dry-run --save-plan ...
+`,
+	}
+}
+
 func (d *Daemon) LogEvent(ev event.Event) error {
 	if d.EventWriter == nil {
 		d.Logger.Log("event", ev, "logupstream", "false")
@@ -470,6 +684,7 @@ func containers2containers(cs []cluster.Container) []flux.Container {
 		id, _ := image.ParseRef(c.Image)
 		res[i] = flux.Container{
 			Name: c.Name,
+			Init: c.Init,
 			Current: image.Info{
 				ID: id,
 			},
@@ -478,21 +693,44 @@ func containers2containers(cs []cluster.Container) []flux.Container {
 	return res
 }
 
-func containersWithAvailable(service cluster.Controller, images update.ImageMap) (res []flux.Container) {
+func containersWithAvailable(service cluster.Controller, images update.ImageMap, policies policy.Set) (res []flux.Container) {
 	for _, c := range service.ContainersOrNil() {
 		im, _ := image.ParseRef(c.Image)
 		available := images.Available(im.Name)
+		filter, _ := policies.Get(policy.TagPrefix(c.Name))
+		minAge, _ := policies.Get(policy.MinAgePrefix(c.Name))
 		res = append(res, flux.Container{
 			Name: c.Name,
+			Init: c.Init,
 			Current: image.Info{
 				ID: im,
 			},
 			Available: available,
+			Filter:    filter,
+			MinAge:    minAge,
+			Locked:    policy.IsContainerLocked(policies, c.Name),
 		})
 	}
 	return res
 }
 
+// policySources gives the sources of a controller's policies, for
+// reporting; only those not given on the controller itself are
+// included.
+func policySources(sources map[policy.Policy]policy.Source) map[string]string {
+	var res map[string]string
+	for p, source := range sources {
+		if source == policy.SourceController {
+			continue
+		}
+		if res == nil {
+			res = map[string]string{}
+		}
+		res[string(p)] = string(source)
+	}
+	return res
+}
+
 func policyCommitMessage(us policy.Updates, cause update.Cause) string {
 	// shortcut, since we want roughly the same information
 	events := policyEvents(us, time.Now())
@@ -544,7 +782,7 @@ func policyEventTypes(u policy.Update) []string {
 		switch {
 		case p == policy.Automated:
 			types[event.EventAutomate] = struct{}{}
-		case p == policy.Locked:
+		case p == policy.Locked, p == policy.LockedUntil, policy.LockedContainer(p):
 			types[event.EventLock] = struct{}{}
 		default:
 			types[event.EventUpdatePolicy] = struct{}{}
@@ -555,8 +793,11 @@ func policyEventTypes(u policy.Update) []string {
 		switch {
 		case p == policy.Automated:
 			types[event.EventDeautomate] = struct{}{}
-		case p == policy.Locked:
+		case p == policy.Locked, policy.LockedContainer(p):
 			types[event.EventUnlock] = struct{}{}
+		case p == policy.LockedUntil && (u.Add.Contains(policy.Locked) || u.Remove.Contains(policy.Locked)):
+			// Locking again without an expiry, or unlocking; that's
+			// covered by the lock or unlock event.
 		default:
 			types[event.EventUpdatePolicy] = struct{}{}
 		}
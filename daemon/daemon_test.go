@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,6 +19,7 @@ import (
 	"github.com/weaveworks/flux/cluster/kubernetes"
 	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
 	"github.com/weaveworks/flux/cluster/kubernetes/testfiles"
+	fluxerr "github.com/weaveworks/flux/errors"
 	"github.com/weaveworks/flux/event"
 	"github.com/weaveworks/flux/git"
 	"github.com/weaveworks/flux/git/gittest"
@@ -26,6 +28,7 @@ import (
 	"github.com/weaveworks/flux/policy"
 	"github.com/weaveworks/flux/registry"
 	registryMock "github.com/weaveworks/flux/registry/mock"
+	"github.com/weaveworks/flux/release"
 	"github.com/weaveworks/flux/remote"
 	"github.com/weaveworks/flux/resource"
 	"github.com/weaveworks/flux/update"
@@ -254,6 +257,107 @@ func TestDaemon_Release(t *testing.T) {
 
 }
 
+// When I save a release plan, I can apply it later, and it's then
+// removed
+func TestDaemon_ApplyPlan(t *testing.T) {
+	d, clean, _, _ := mockDaemon(t)
+	defer clean()
+	w := newWait(t)
+
+	ctx := context.Background()
+	planID, cleanPlans := savePlan(ctx, t, d)
+	defer cleanPlans()
+
+	id := updateManifest(ctx, t, d, update.Spec{Type: update.ApplyPlan, Spec: update.ApplyPlanSpec{ID: planID}})
+	stat := w.ForJobSucceeded(d, id)
+	if stat.Result.Revision == "" {
+		t.Fatal("expected applying the plan to commit")
+	}
+	if stat.Result.PlanID != planID {
+		t.Errorf("expected result to refer to plan %q, got %q", planID, stat.Result.PlanID)
+	}
+	if stat.Result.Spec.Type != update.Images {
+		t.Errorf("expected the release to be recorded as type %q, got %q", update.Images, stat.Result.Spec.Type)
+	}
+	if spec := stat.Result.Spec.Spec.(update.ReleaseSpec); spec.Kind != update.ReleaseKindExecute {
+		t.Errorf("expected the release to be recorded as executed, got kind %q", spec.Kind)
+	}
+
+	_, err := d.UpdateManifests(ctx, update.Spec{Type: update.ApplyPlan, Spec: update.ApplyPlanSpec{ID: planID}})
+	// This is a user error rather than "missing", which a client
+	// would take to mean the daemon doesn't support plans at all.
+	if ferr, ok := err.(*fluxerr.Error); !ok || ferr.Type != fluxerr.User {
+		t.Errorf("expected a user error on applying a plan twice, got %v", err)
+	}
+}
+
+// When things have changed since I saved a release plan, applying it
+// should fail
+func TestDaemon_ApplyPlanStale(t *testing.T) {
+	for name, change := range map[string]func(context.Context, *testing.T, *Daemon){
+		"new commit": func(ctx context.Context, t *testing.T, d *Daemon) {
+			w := newWait(t)
+			w.ForJobSucceeded(d, updatePolicy(ctx, t, d))
+		},
+		"new image": func(ctx context.Context, t *testing.T, d *Daemon) {
+			reg := d.Registry.(*registryMock.Registry)
+			d.Registry = &registryMock.Registry{
+				Images: append(reg.Images, makeImageInfo("quay.io/weaveworks/helloworld:master-a000003", time.Now().Add(time.Hour))),
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d, clean, _, _ := mockDaemon(t)
+			defer clean()
+			w := newWait(t)
+
+			ctx := context.Background()
+			planID, cleanPlans := savePlan(ctx, t, d)
+			defer cleanPlans()
+			change(ctx, t, d)
+
+			id := updateManifest(ctx, t, d, update.Spec{Type: update.ApplyPlan, Spec: update.ApplyPlanSpec{ID: planID}})
+			var stat job.Status
+			w.Eventually(func() bool {
+				stat, _ = d.JobStatus(ctx, id)
+				return stat.StatusString == job.StatusFailed || stat.StatusString == job.StatusSucceeded
+			}, "Waiting for job to finish")
+			if stat.StatusString != job.StatusFailed || !strings.Contains(stat.Err, "stale") {
+				t.Errorf("expected applying the plan to fail as stale, got %q (%s)", stat.StatusString, stat.Err)
+			}
+		})
+	}
+}
+
+func savePlan(ctx context.Context, t *testing.T, d *Daemon) (string, func()) {
+	dir, err := ioutil.TempDir("", "flux-plans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	if d.Plans, err = release.NewPlanStore(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	id := updateManifest(ctx, t, d, update.Spec{
+		Type: update.Images,
+		Spec: update.ReleaseSpec{
+			Kind:         update.ReleaseKindPlan,
+			ServiceSpecs: []update.ResourceSpec{update.ResourceSpecAll},
+			ImageSpec:    update.ImageSpecLatest,
+			SavePlan:     true,
+		},
+	})
+	stat, err := d.JobStatus(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Result.PlanID == "" {
+		t.Fatal("expected a plan ID in the dry run result")
+	}
+	return stat.Result.PlanID, cleanup
+}
+
 // When I update a policy, I expect it to add to the queue
 // When I update a policy, it should add an annotation to the manifest
 func TestDaemon_PolicyUpdate(t *testing.T) {
@@ -280,6 +384,232 @@ func TestDaemon_PolicyUpdate(t *testing.T) {
 	}, "Waiting for new annotation")
 }
 
+// When I update a policy in the policy file, I expect the file to
+// be committed, and the policy to be in effect
+func TestDaemon_PolicyFileUpdate(t *testing.T) {
+	d, clean, _, _ := mockDaemon(t)
+	defer clean()
+	w := newWait(t)
+
+	ctx := context.Background()
+	id := updateManifest(ctx, t, d, update.Spec{
+		Type: update.Policy,
+		Spec: policy.Updates{
+			flux.MustParseResourceID(svc): {
+				Add:          policy.Set{policy.TagPrefix(container): "glob:master-*"},
+				InPolicyFile: true,
+			},
+		},
+	})
+	w.ForJobSucceeded(d, id)
+
+	w.Eventually(func() bool {
+		d.Checkout.RLock()
+		defer d.Checkout.RUnlock()
+		if _, err := os.Stat(filepath.Join(d.Checkout.ManifestDir(), cluster.PolicyFile)); err != nil {
+			return false
+		}
+		_, sources, err := d.Manifests.ServicesWithPolicySources(d.Checkout.ManifestDir())
+		return err == nil && sources[flux.MustParseResourceID(svc)][policy.TagPrefix(container)] == policy.SourcePolicyFile
+	}, "Waiting for policy file to be committed")
+}
+
+// When a controller has a minimum image age, images newer than that
+// are not released, and are looked at again when they are old enough
+func TestDaemon_MinAge(t *testing.T) {
+	d, clean, _, _ := mockDaemon(t)
+	defer clean()
+	w := newWait(t)
+
+	ctx := context.Background()
+	id := updateManifest(ctx, t, d, update.Spec{
+		Type: update.Policy,
+		Spec: policy.Updates{
+			flux.MustParseResourceID(svc): {
+				Add: policy.Set{
+					policy.Automated:               "true",
+					policy.MinAgePrefix(container): "30m",
+				},
+			},
+		},
+	})
+	w.ForJobSucceeded(d, id)
+	w.Eventually(func() bool {
+		services, err := d.unlockedAutomatedServices()
+		return err == nil && services.Contains(flux.MustParseResourceID(svc))
+	}, "Waiting for controller to be automated")
+
+	// The new image was only just created, so it's too new
+	recheck := d.pollForNewImages(d.Logger)
+	if wait := time.Until(recheck); wait < 29*time.Minute || wait > 31*time.Minute {
+		t.Errorf("expected images to be looked at again in 30m, got %v", wait)
+	}
+	if wait := nextImagePoll(5*time.Minute, recheck); wait != 5*time.Minute {
+		t.Errorf("expected next poll at the poll interval, got %v", wait)
+	}
+	if wait := nextImagePoll(time.Hour, recheck); wait > 31*time.Minute {
+		t.Errorf("expected next poll when images are old enough, got %v", wait)
+	}
+}
+
+// When there's an automation window, new images are not released
+// until it's over
+func TestDaemon_AutomationWindow(t *testing.T) {
+	d, clean, _, _ := mockDaemon(t)
+	defer clean()
+	w := newWait(t)
+	d.AutomationWindow = time.Hour
+
+	ctx := context.Background()
+	id := updateManifest(ctx, t, d, update.Spec{
+		Type: update.Policy,
+		Spec: policy.Updates{
+			flux.MustParseResourceID(svc): {
+				Add: policy.Set{
+					policy.Automated: "true",
+				},
+			},
+		},
+	})
+	w.ForJobSucceeded(d, id)
+	w.Eventually(func() bool {
+		services, err := d.unlockedAutomatedServices()
+		return err == nil && services.Contains(flux.MustParseResourceID(svc))
+	}, "Waiting for controller to be automated")
+
+	recheck := d.pollForNewImages(d.Logger)
+	if wait := time.Until(recheck); wait < 59*time.Minute || wait > time.Hour {
+		t.Errorf("expected images to be looked at again at the end of the window, got %v", wait)
+	}
+	if d.automatedJob != "" {
+		t.Fatal("expected no automated release during the window")
+	}
+
+	// Once the window is over, the new image is released
+	d.automationWindowEnds = time.Now().Add(-time.Second)
+	if recheck := d.pollForNewImages(d.Logger); !recheck.IsZero() {
+		t.Errorf("expected no need to look at images again, got %v", recheck)
+	}
+	if d.automatedJob == "" {
+		t.Fatal("expected an automated release after the window")
+	}
+	w.ForJobSucceeded(d, d.automatedJob)
+	w.Eventually(func() bool {
+		d.Checkout.RLock()
+		defer d.Checkout.RUnlock()
+		def, err := ioutil.ReadFile(filepath.Join(d.Checkout.ManifestDir(), "helloworld-deploy.yaml"))
+		return err == nil && strings.Contains(string(def), newHelloImage)
+	}, "Waiting for new image to be released")
+}
+
+// When a lock expires, the controller is automated again, and the
+// lock is removed
+func TestDaemon_ExpiredLock(t *testing.T) {
+	d, clean, _, _ := mockDaemon(t)
+	defer clean()
+	w := newWait(t)
+	svcID := flux.MustParseResourceID(svc)
+	// Hold back automated releases, which aren't of interest here
+	d.AutomationWindow = time.Hour
+
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	id := updateManifest(ctx, t, d, update.Spec{
+		Type: update.Policy,
+		Spec: policy.Updates{
+			svcID: {
+				Add: policy.Set{
+					policy.Automated:   "true",
+					policy.Locked:      "true",
+					policy.LockedUntil: expires.Format(time.RFC3339),
+				},
+			},
+		},
+	})
+	w.ForJobSucceeded(d, id)
+	w.Eventually(func() bool {
+		services, err := d.Manifests.ServicesWithPolicies(d.Checkout.ManifestDir())
+		return err == nil && services[svcID].Contains(policy.LockedUntil)
+	}, "Waiting for controller to be locked")
+
+	// Not expired yet, so look again when it will have
+	if recheck := d.expireLocks(d.Logger); !recheck.Equal(expires) {
+		t.Errorf("expected locks to be looked at again at %v, got %v", expires, recheck)
+	}
+	if d.lockExpiryJob != "" {
+		t.Fatal("expected no job to remove locks")
+	}
+	if services, err := d.unlockedAutomatedServices(); err != nil || services.Contains(svcID) {
+		t.Fatalf("expected controller to be locked (error %v)", err)
+	}
+
+	// Pretend time has passed by moving the expiry back
+	id = updateManifest(ctx, t, d, update.Spec{
+		Type: update.Policy,
+		Spec: policy.Updates{
+			svcID: {
+				Add: policy.Set{
+					policy.LockedUntil: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+				},
+			},
+		},
+	})
+	w.ForJobSucceeded(d, id)
+	w.Eventually(func() bool {
+		services, err := d.unlockedAutomatedServices()
+		return err == nil && services.Contains(svcID)
+	}, "Waiting for expired lock to be ignored")
+
+	if recheck := d.expireLocks(d.Logger); !recheck.IsZero() {
+		t.Errorf("expected no need to look at locks again, got %v", recheck)
+	}
+	if d.lockExpiryJob == "" {
+		t.Fatal("expected a job to remove expired locks")
+	}
+	status := w.ForJobSucceeded(d, d.lockExpiryJob)
+	updates, ok := status.Result.Spec.Spec.(policy.Updates)
+	if !ok || !updates[svcID].Remove.Contains(policy.Locked) {
+		t.Errorf("expected lock to be removed, got %#v", status.Result.Spec)
+	}
+	var unlocked bool
+	for _, typ := range policyEventTypes(updates[svcID]) {
+		unlocked = unlocked || typ == event.EventUnlock
+	}
+	if !unlocked {
+		t.Errorf("expected an unlock event, got %v", policyEventTypes(updates[svcID]))
+	}
+	w.Eventually(func() bool {
+		services, err := d.Manifests.ServicesWithPolicies(d.Checkout.ManifestDir())
+		return err == nil && !services[svcID].Contains(policy.Locked) && !services[svcID].Contains(policy.LockedUntil)
+	}, "Waiting for lock to be removed")
+}
+
+func TestDaemon_AutomatedJobQueued(t *testing.T) {
+	shutdown := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	defer func() {
+		close(shutdown)
+		wg.Wait()
+	}()
+	d := &Daemon{
+		Jobs:     job.NewQueue(shutdown, wg),
+		LoopVars: &LoopVars{},
+	}
+	if d.automatedJobQueued() {
+		t.Error("expected no automated job to be queued")
+	}
+	d.Jobs.Enqueue(&job.Job{ID: "automated"})
+	d.Jobs.Sync()
+	d.automatedJob = "automated"
+	if !d.automatedJobQueued() {
+		t.Error("expected automated job to be queued")
+	}
+	d.automatedJob = "another"
+	if d.automatedJobQueued() {
+		t.Error("expected automated job not to be queued")
+	}
+}
+
 // When I call sync status, it should return a commit showing the sync
 // that is about to take place. Then it should return empty once it is
 // complete
@@ -391,6 +721,8 @@ func mockDaemon(t *testing.T) (*Daemon, func(), *cluster.Mock, *mockEventWriter)
 		}
 		k8s.PingFunc = func() error { return nil }
 		k8s.ServicesWithPoliciesFunc = (&kubernetes.Manifests{}).ServicesWithPolicies
+		k8s.ServicesWithPolicySourcesFunc = (&kubernetes.Manifests{}).ServicesWithPolicySources
+		k8s.InheritedPoliciesFunc = (&kubernetes.Manifests{}).InheritedPolicies
 		k8s.SomeServicesFunc = func([]flux.ResourceID) ([]cluster.Controller, error) {
 			return []cluster.Controller{
 				singleService,
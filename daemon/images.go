@@ -2,18 +2,25 @@ package daemon
 
 import (
 	"context"
-	"strings"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 
-	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/job"
 	"github.com/weaveworks/flux/policy"
 	"github.com/weaveworks/flux/update"
 )
 
-func (d *Daemon) pollForNewImages(logger log.Logger) {
+// pollForNewImages looks for newer images for the automated
+// controllers, and releases any it finds. It returns the time at
+// which it should be called again, ahead of the usual polling
+// interval, or the zero time if there's no need; either because
+// images were passed over for being newer than a minimum age policy
+// allows, or because releases are being held back until the end of
+// the automation window.
+func (d *Daemon) pollForNewImages(logger log.Logger) (recheck time.Time) {
 	logger.Log("msg", "polling images")
 
 	// One day we may use this for operations other than the call at the end
@@ -46,35 +53,123 @@ func (d *Daemon) pollForNewImages(logger log.Logger) {
 		for _, container := range service.ContainersOrNil() {
 			logger := log.With(logger, "service", service.ID, "container", container.Name, "currentimage", container.Image)
 
+			if policy.IsContainerLocked(candidateServices[service.ID], container.Name) {
+				continue
+			}
+
 			currentImageID, err := image.ParseRef(container.Image)
 			if err != nil {
 				logger.Log("error", err)
 				continue
 			}
 
-			pattern := getTagPattern(candidateServices, service.ID, container.Name)
+			pattern, err := policy.GetTagPattern(candidateServices[service.ID], container.Name)
+			if err != nil {
+				logger.Log("error", err)
+				continue
+			}
+			minAge, err := policy.GetMinAge(candidateServices[service.ID], container.Name)
+			if err != nil {
+				logger.Log("error", err)
+				continue
+			}
 			repo := currentImageID.Name
 			logger.Log("repo", repo, "pattern", pattern)
 
-			if latest := imageMap.LatestImage(repo, pattern); latest != nil && latest.ID != currentImageID {
-				newImage := currentImageID.WithNewTag(latest.ID.Tag)
+			var cutoff time.Time
+			if minAge > 0 {
+				cutoff = time.Now().Add(-minAge)
+			}
+			latest, tooNew := imageMap.LatestImageCreatedBefore(repo, pattern, cutoff)
+			for _, im := range tooNew {
+				oldEnough := im.CreatedAt.Add(minAge)
+				logger.Log("msg", "skipping image newer than minimum age", "image", im.ID, "minage", minAge, "until", oldEnough)
+				if recheck.IsZero() || oldEnough.Before(recheck) {
+					recheck = oldEnough
+				}
+			}
+			if latest == nil {
+				continue
+			}
+			// When pinning, this compares digests as well as tags, so
+			// that an image that's been retagged gets released.
+			newImage, err := update.TargetImage(currentImageID, latest, update.PinDigest(d.PinDigests, candidateServices[service.ID]))
+			if err != nil {
+				logger.Log("error", err)
+				continue
+			}
+			if newImage != currentImageID {
 				changes.Add(service.ID, container, newImage)
 				logger.Log("msg", "added image to changes", "newimage", newImage)
 			}
 		}
 	}
 
-	if len(changes.Changes) > 0 {
-		d.UpdateManifests(ctx, update.Spec{Type: update.Auto, Spec: changes})
+	if len(changes.Changes) == 0 {
+		if !d.automationWindowEnds.IsZero() {
+			d.automationWindowEnds = time.Time{}
+		}
+		return recheck
+	}
+
+	// If there's an automation window, wait until it's over before
+	// releasing anything, so that all the images found in the
+	// meantime go in a single commit. Since the latest images are
+	// looked for each time, the changes at the end of the window
+	// include those found at the start, if they haven't been
+	// superseded.
+	if d.AutomationWindow > 0 {
+		now := time.Now()
+		if d.automationWindowEnds.IsZero() {
+			d.automationWindowEnds = now.Add(d.AutomationWindow)
+			logger.Log("msg", "holding automated release for window", "until", d.automationWindowEnds)
+		}
+		if now.Before(d.automationWindowEnds) {
+			if recheck.IsZero() || d.automationWindowEnds.Before(recheck) {
+				recheck = d.automationWindowEnds
+			}
+			return recheck
+		}
+	}
+
+	// Don't queue another automated release behind one that's still
+	// waiting; the images will be looked at again once it's done.
+	if d.automatedJobQueued() {
+		logger.Log("msg", "automated release already queued; holding new changes until it's done")
+		d.automationDeferred = true
+		return recheck
+	}
+
+	d.automationWindowEnds = time.Time{}
+	d.automationDeferred = false
+	id, err := d.UpdateManifests(ctx, update.Spec{Type: update.Auto, Spec: changes})
+	if err != nil {
+		logger.Log("error", errors.Wrap(err, "queueing automated release"))
+		return recheck
 	}
+	d.automatedJob = id
+	return recheck
+}
+
+// automatedJobQueued reports whether the last automated release
+// queued is still waiting to be run.
+func (d *Daemon) automatedJobQueued() bool {
+	return d.jobQueued(d.automatedJob)
 }
 
-func getTagPattern(services policy.ResourceMap, service flux.ResourceID, container string) string {
-	policies := services[service]
-	if pattern, ok := policies.Get(policy.TagPrefix(container)); ok {
-		return strings.TrimPrefix(pattern, "glob:")
+// jobQueued reports whether the job given is still waiting to be run.
+func (d *Daemon) jobQueued(id job.ID) bool {
+	if id == "" {
+		return false
 	}
-	return "*"
+	var queued bool
+	d.Jobs.ForEach(func(_ int, j *job.Job) bool {
+		if j.ID == id {
+			queued = true
+		}
+		return !queued
+	})
+	return queued
 }
 
 func (d *Daemon) unlockedAutomatedServices() (policy.ResourceMap, error) {
@@ -83,6 +178,8 @@ func (d *Daemon) unlockedAutomatedServices() (policy.ResourceMap, error) {
 		return nil, err
 	}
 	automatedServices := services.OnlyWithPolicy(policy.Automated)
-	lockedServices := services.OnlyWithPolicy(policy.Locked)
+	// Expired locks will be removed by expireLocks; until then,
+	// they're as good as gone.
+	lockedServices := services.OnlyWithPolicy(policy.Locked).WithoutExpiredLocks(time.Now())
 	return automatedServices.Without(lockedServices), nil
 }
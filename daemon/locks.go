@@ -0,0 +1,79 @@
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux/event"
+	"github.com/weaveworks/flux/git"
+	"github.com/weaveworks/flux/job"
+	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/update"
+)
+
+// expiredLockCause is given as the cause of the commits that remove
+// expired locks.
+var expiredLockCause = update.Cause{Message: "Remove expired locks"}
+
+// expireLocks looks for controllers with locks that have expired,
+// and queues a job to remove the locks. It returns the time at which
+// the next lock will expire, so it can be called again then, or the
+// zero time if no locks have an expiry.
+func (d *Daemon) expireLocks(logger log.Logger) (recheck time.Time) {
+	services, err := d.Manifests.ServicesWithPolicies(d.Checkout.ManifestDir())
+	if err != nil {
+		logger.Log("error", errors.Wrap(err, "checking for expired locks"))
+		return
+	}
+	now := time.Now()
+	var anyExpired bool
+	for id, policies := range services {
+		until, ok, err := policy.GetLockedUntil(policies)
+		if err != nil {
+			logger.Log("service", id, "error", err)
+			continue
+		}
+		switch {
+		case !ok:
+		case !now.Before(until):
+			anyExpired = true
+		case recheck.IsZero() || until.Before(recheck):
+			recheck = until
+		}
+	}
+	if !anyExpired || d.jobQueued(d.lockExpiryJob) {
+		return recheck
+	}
+	d.lockExpiryJob = d.queueJob(d.removeExpiredLocks())
+	return recheck
+}
+
+// removeExpiredLocks gives a job that removes the locks that have
+// expired. It looks at the policies again when it runs, in case a
+// lock has been removed, or renewed, in the meantime.
+func (d *Daemon) removeExpiredLocks() DaemonJobFunc {
+	return func(ctx context.Context, jobID job.ID, working *git.Checkout, logger log.Logger) (*event.CommitEventMetadata, error) {
+		services, err := d.Manifests.ServicesWithPolicies(working.ManifestDir())
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		updates := policy.Updates{}
+		for id, policies := range services {
+			if policy.LockExpired(policies, now) {
+				logger.Log("msg", "removing expired lock", "service", id)
+				updates[id] = policy.Update{
+					Remove: policy.Set{}.Add(policy.Locked, policy.LockedUser, policy.LockedMsg, policy.LockedUntil),
+				}
+			}
+		}
+		spec := update.Spec{Type: update.Policy, Cause: expiredLockCause, Spec: updates}
+		if len(updates) == 0 {
+			return &event.CommitEventMetadata{Spec: &spec, Result: update.Result{}}, nil
+		}
+		return d.updatePolicy(spec, updates)(ctx, jobID, working, logger)
+	}
+}
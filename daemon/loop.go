@@ -15,6 +15,7 @@ import (
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/event"
 	"github.com/weaveworks/flux/git"
+	"github.com/weaveworks/flux/job"
 	fluxmetrics "github.com/weaveworks/flux/metrics"
 	"github.com/weaveworks/flux/resource"
 	fluxsync "github.com/weaveworks/flux/sync"
@@ -29,9 +30,20 @@ const (
 type LoopVars struct {
 	GitPollInterval      time.Duration
 	RegistryPollInterval time.Duration
-	syncSoon             chan struct{}
-	pollImagesSoon       chan struct{}
-	initOnce             sync.Once
+	// AutomationWindow is how long to keep looking for new images
+	// for automated controllers, once some are found, before
+	// releasing them all in one commit. If zero, they are released
+	// as soon as they are found.
+	AutomationWindow time.Duration
+	syncSoon         chan struct{}
+	pollImagesSoon   chan struct{}
+	initOnce         sync.Once
+
+	// Automation bookkeeping; these are only used from the loop
+	automationWindowEnds time.Time
+	automatedJob         job.ID
+	automationDeferred   bool
+	lockExpiryJob        job.ID
 }
 
 func (loop *LoopVars) ensureInit() {
@@ -74,9 +86,9 @@ func (d *Daemon) GitPollLoop(stop chan struct{}, wg *sync.WaitGroup, logger log.
 			logger.Log("stopping", "true")
 			return
 		case <-d.pollImagesSoon:
-			d.pollForNewImages(logger)
+			recheck := earliest(d.expireLocks(logger), d.pollForNewImages(logger))
 			imagePollTimer.Stop()
-			imagePollTimer = time.NewTimer(d.RegistryPollInterval)
+			imagePollTimer = time.NewTimer(nextImagePoll(d.RegistryPollInterval, recheck))
 		case <-imagePollTimer.C:
 			d.AskForImagePoll()
 		case <-d.syncSoon:
@@ -103,10 +115,40 @@ func (d *Daemon) GitPollLoop(stop chan struct{}, wg *sync.WaitGroup, logger log.
 				fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
 			).Observe(time.Since(start).Seconds())
 			pullThen(d.doSync)
+			// If an automated release was held back behind this job,
+			// it can go ahead now.
+			if d.automationDeferred && !d.automatedJobQueued() {
+				d.AskForImagePoll()
+			}
 		}
 	}
 }
 
+// nextImagePoll gives the time to wait before polling images again;
+// that is the poll interval, or less if some images need to be looked
+// at again before then.
+func nextImagePoll(interval time.Duration, recheck time.Time) time.Duration {
+	if recheck.IsZero() {
+		return interval
+	}
+	// Allow a little slack, so the images are definitely old enough
+	// when they are looked at.
+	wait := time.Until(recheck) + time.Second
+	if wait < interval {
+		return wait
+	}
+	return interval
+}
+
+// earliest gives the earlier of two times, ignoring either if it's
+// zero.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
 // Ask for a sync, or if there's one waiting, let that happen.
 func (d *LoopVars) AskForSync() {
 	d.ensureInit()
@@ -303,6 +345,25 @@ func (d *Daemon) doSync(logger log.Logger) (retErr error) {
 					},
 				})
 				includes[event.EventAutoRelease] = true
+			case update.Promote:
+				spec := n.Spec.Spec.(update.PromoteSpec)
+				noteEvents = append(noteEvents, event.Event{
+					ServiceIDs: serviceIDs.ToSlice(),
+					Type:       event.EventPromote,
+					StartedAt:  started,
+					EndedAt:    time.Now().UTC(),
+					LogLevel:   event.LogLevelInfo,
+					Metadata: &event.PromoteEventMetadata{
+						ReleaseEventCommon: event.ReleaseEventCommon{
+							Revision: commits[i].Revision,
+							Result:   n.Result,
+							Error:    n.Result.Error(),
+						},
+						Spec:  spec,
+						Cause: n.Spec.Cause,
+					},
+				})
+				includes[event.EventPromote] = true
 			case update.Policy:
 				// Use this to mean any change to policy
 				includes[event.EventUpdatePolicy] = true
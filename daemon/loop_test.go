@@ -57,6 +57,8 @@ func daemon(t *testing.T) (*Daemon, func()) {
 	k8s.ExportFunc = func() ([]byte, error) { return nil, nil }
 	k8s.FindDefinedServicesFunc = (&kubernetes.Manifests{}).FindDefinedServices
 	k8s.ServicesWithPoliciesFunc = (&kubernetes.Manifests{}).ServicesWithPolicies
+	k8s.ServicesWithPolicySourcesFunc = (&kubernetes.Manifests{}).ServicesWithPolicySources
+	k8s.InheritedPoliciesFunc = (&kubernetes.Manifests{}).InheritedPolicies
 
 	events = &mockEventWriter{}
 
@@ -19,6 +19,7 @@ const (
 	EventSync         = "sync"
 	EventRelease      = "release"
 	EventAutoRelease  = "autorelease"
+	EventPromote      = "promote"
 	EventAutomate     = "automate"
 	EventDeautomate   = "deautomate"
 	EventLock         = "lock"
@@ -129,6 +130,28 @@ func (e Event) String() string {
 			"Automated release of %s",
 			strings.Join(strImageIDs, ", "),
 		)
+	case EventPromote:
+		metadata := e.Metadata.(*PromoteEventMetadata)
+		strImageIDs := metadata.Result.ImageIDs()
+		if len(strImageIDs) == 0 {
+			strImageIDs = []string{"no image changes"}
+		}
+		var user string
+		if metadata.Cause.User != "" {
+			user = fmt.Sprintf(", by %s", metadata.Cause.User)
+		}
+		var msg string
+		if metadata.Cause.Message != "" {
+			msg = fmt.Sprintf(", with message %q", metadata.Cause.Message)
+		}
+		return fmt.Sprintf(
+			"Promoted: %s from %s to %s%s%s",
+			strings.Join(strImageIDs, ", "),
+			metadata.Spec.From,
+			metadata.Spec.To,
+			user,
+			msg,
+		)
 	case EventCommit:
 		metadata := e.Metadata.(*CommitEventMetadata)
 		svcStr := "<no changes>"
@@ -180,6 +203,9 @@ type CommitEventMetadata struct {
 	Revision string        `json:"revision,omitempty"`
 	Spec     *update.Spec  `json:"spec"`
 	Result   update.Result `json:"result,omitempty"`
+	// PlanID is the ID of the release plan saved by a dry run, or
+	// applied by this commit.
+	PlanID string `json:"planID,omitempty"`
 }
 
 func (c CommitEventMetadata) ShortRevision() string {
@@ -245,6 +271,14 @@ type AutoReleaseEventMetadata struct {
 	Spec update.Automated `json:"spec"`
 }
 
+// PromoteEventMetadata is for when the images running in one set of
+// service(s) are copied to another
+type PromoteEventMetadata struct {
+	ReleaseEventCommon
+	Spec  update.PromoteSpec `json:"spec"`
+	Cause update.Cause       `json:"cause"`
+}
+
 type UnknownEventMetadata map[string]interface{}
 
 func (e *Event) UnmarshalJSON(in []byte) error {
@@ -280,6 +314,13 @@ func (e *Event) UnmarshalJSON(in []byte) error {
 		}
 		e.Metadata = &metadata
 		break
+	case EventPromote:
+		var metadata PromoteEventMetadata
+		if err := json.Unmarshal(wireEvent.MetadataBytes, &metadata); err != nil {
+			return err
+		}
+		e.Metadata = &metadata
+		break
 	case EventCommit:
 		var metadata CommitEventMetadata
 		if err := json.Unmarshal(wireEvent.MetadataBytes, &metadata); err != nil {
@@ -333,6 +374,10 @@ func (rem *AutoReleaseEventMetadata) Type() string {
 	return EventAutoRelease
 }
 
+func (pem *PromoteEventMetadata) Type() string {
+	return EventPromote
+}
+
 // Special exception from pointer receiver rule, as UnknownEventMetadata is a
 // type alias for a map
 func (uem UnknownEventMetadata) Type() string {
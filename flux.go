@@ -260,12 +260,29 @@ type ControllerStatus struct {
 	Locked     bool
 	Ignore     bool
 	Policies   map[string]string
+	// PolicySources says where each policy came from (e.g.,
+	// "namespace", if it's a default given on the namespace), for
+	// those that weren't given on the controller itself.
+	PolicySources map[string]string `json:",omitempty"`
 }
 
 type Container struct {
-	Name      string
+	Name string
+	// Init is true if this is an init container.
+	Init      bool `json:",omitempty"`
 	Current   image.Info
 	Available []image.Info
+	// Filter is the tag filter pattern in effect for the container,
+	// as given in its policy (e.g., "semver:~1.4"), or empty if
+	// there is none.
+	Filter string `json:",omitempty"`
+	// MinAge is the minimum age of images that will be automatically
+	// released to the container, as given in its policy (e.g.,
+	// "30m"), or empty if there is none.
+	MinAge string `json:",omitempty"`
+	// Locked is true if the container itself is locked, so images
+	// won't be released to it, even if the controller is not locked.
+	Locked bool `json:",omitempty"`
 }
 
 // --- config types
@@ -78,6 +78,18 @@ func commit(ctx context.Context, workingDir string, commitAction *CommitAction)
 	return nil
 }
 
+// add marks the files given as to be included in the next commit
+// made with `commit -a`, even if they are new. Only the intent to add
+// them is recorded, so that their changes still count as such for
+// `check`.
+func add(ctx context.Context, workingDir string, paths []string) error {
+	args := append([]string{"add", "--intent-to-add", "--"}, paths...)
+	if err := execGitCmd(ctx, workingDir, nil, nil, args...); err != nil {
+		return errors.Wrap(err, "git add")
+	}
+	return nil
+}
+
 // push the refs given to the upstream repo
 func push(ctx context.Context, keyRing ssh.KeyRing, workingDir, upstream string, refs []string) error {
 	args := append([]string{"push", upstream}, refs...)
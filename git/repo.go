@@ -190,6 +190,14 @@ func (c *Checkout) CommitAndPush(ctx context.Context, commitAction *CommitAction
 	return nil
 }
 
+// Add marks the files given, which may be new, to be included when
+// changes are next committed.
+func (c *Checkout) Add(ctx context.Context, paths ...string) error {
+	c.Lock()
+	defer c.Unlock()
+	return add(ctx, c.Dir, paths)
+}
+
 // GetNote gets a note for the revision specified, or nil if there is no such note.
 func (c *Checkout) GetNote(ctx context.Context, rev string) (*Note, error) {
 	c.RLock()
@@ -17,6 +17,7 @@ import (
 	transport "github.com/weaveworks/flux/http"
 	"github.com/weaveworks/flux/job"
 	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/remote"
 	"github.com/weaveworks/flux/ssh"
 	"github.com/weaveworks/flux/update"
 )
@@ -51,20 +52,36 @@ func (c *Client) ListImages(ctx context.Context, s update.ResourceSpec) ([]flux.
 
 func (c *Client) UpdateImages(ctx context.Context, s update.ReleaseSpec, cause update.Cause) (job.ID, error) {
 	args := []string{
-		"image", string(s.ImageSpec),
 		"kind", string(s.Kind),
 		"user", cause.User,
 	}
+	for _, image := range s.Images() {
+		args = append(args, "image", string(image))
+	}
 	for _, spec := range s.ServiceSpecs {
 		args = append(args, "service", string(spec))
 	}
 	for _, ex := range s.Excludes {
 		args = append(args, "exclude", ex.String())
 	}
+	if s.SavePlan {
+		args = append(args, "save-plan", "true")
+	}
 	if cause.Message != "" {
 		args = append(args, "message", cause.Message)
 	}
 
+	if len(s.Images()) > 1 {
+		var res job.ID
+		err := c.methodWithResp(ctx, "POST", &res, "UpdateImagesV10", nil, args...)
+		return res, upgradeNeededIfMissing(err, "Releasing several images at once is not supported")
+	}
+	if s.SavePlan {
+		var res job.ID
+		err := c.methodWithResp(ctx, "POST", &res, "UpdateImagesV10", nil, args...)
+		return res, upgradeNeededIfMissing(err, "Saving release plans is not supported")
+	}
+
 	var res job.ID
 	err := c.methodWithResp(ctx, "POST", &res, "UpdateImages", nil, args...)
 	return res, err
@@ -88,9 +105,34 @@ func (c *Client) UpdatePolicies(ctx context.Context, updates policy.Updates, cau
 		args = append(args, "message", cause.Message)
 	}
 	var res job.ID
+	for _, u := range updates {
+		if u.InPolicyFile {
+			err := c.methodWithResp(ctx, "PATCH", &res, "UpdatePoliciesV10", updates, args...)
+			return res, upgradeNeededIfMissing(err, "Updating the policy file is not supported")
+		}
+	}
 	return res, c.methodWithResp(ctx, "PATCH", &res, "UpdatePolicies", updates, args...)
 }
 
+func (c *Client) Promote(ctx context.Context, spec update.PromoteSpec, cause update.Cause) (job.ID, error) {
+	args := []string{"user", cause.User}
+	if cause.Message != "" {
+		args = append(args, "message", cause.Message)
+	}
+	var res job.ID
+	return res, c.methodWithResp(ctx, "POST", &res, "Promote", spec, args...)
+}
+
+func (c *Client) ApplyPlan(ctx context.Context, id string, cause update.Cause) (job.ID, error) {
+	args := []string{"id", id, "user", cause.User}
+	if cause.Message != "" {
+		args = append(args, "message", cause.Message)
+	}
+	var res job.ID
+	err := c.methodWithResp(ctx, "POST", &res, "ApplyPlanV10", nil, args...)
+	return res, upgradeNeededIfMissing(err, "Applying a saved release plan is not supported")
+}
+
 func (c *Client) LogEvent(ctx context.Context, event event.Event) error {
 	return c.PostWithBody(ctx, "LogEvent", event)
 }
@@ -114,6 +156,16 @@ func (c *Client) PublicSSHKey(ctx context.Context, regenerate bool) (ssh.PublicK
 	return res, err
 }
 
+// upgradeNeededIfMissing turns the error from a daemon that doesn't
+// have a route into one saying the daemon needs upgrading, since
+// only routes introduced after the daemon was released are missing.
+func upgradeNeededIfMissing(err error, msg string) error {
+	if err, ok := errors.Cause(err).(*fluxerr.Error); ok && err.Type == fluxerr.Missing {
+		return remote.UpgradeNeededError(errors.New(msg))
+	}
+	return err
+}
+
 // --- Request helpers
 
 // post is a simple query-param only post request
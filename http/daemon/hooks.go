@@ -0,0 +1,140 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	transport "github.com/weaveworks/flux/http"
+	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/remote"
+)
+
+// These hooks accept the notifications registries send when an image
+// is pushed, so a registry's webhook can be pointed straight at
+// fluxd. Each turns the images pushed into image change
+// notifications, so they are looked at first by the cache warmer.
+//
+// If a secret is given for the hooks, a request must supply it,
+// either as the `secret` query parameter (since Docker Hub and Quay
+// don't let you give headers for webhooks), or as a bearer token in
+// the Authorization header.
+
+const hookSecretParam = "secret"
+
+var errBadHookSecret = errors.New("secret missing or incorrect")
+
+func (s HTTPServer) authoriseHook(r *http.Request) bool {
+	if s.hookSecret == "" {
+		return true
+	}
+	given := r.URL.Query().Get(hookSecretParam)
+	if auth := r.Header.Get("Authorization"); given == "" && strings.HasPrefix(auth, "Bearer ") {
+		given = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.hookSecret)) == 1
+}
+
+// registryHook makes a handler that checks the secret, uses parse to
+// get the names of the images pushed from the body of the request,
+// and notifies the daemon of each.
+func (s HTTPServer) registryHook(parse func(*http.Request) ([]string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authoriseHook(r) {
+			transport.WriteError(w, r, http.StatusUnauthorized, errBadHookSecret)
+			return
+		}
+		names, err := parse(r)
+		if err != nil {
+			transport.WriteError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing registry notification"))
+			return
+		}
+		seen := map[string]bool{}
+		for _, nameString := range names {
+			ref, err := image.ParseRef(nameString)
+			if err != nil {
+				transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing image name %q", nameString))
+				return
+			}
+			if seen[ref.Name.String()] {
+				continue
+			}
+			seen[ref.Name.String()] = true
+			if err := s.daemon.NotifyChange(r.Context(), remote.Change{
+				Kind:   remote.ImageChange,
+				Source: remote.ImageUpdate{Name: ref.Name},
+			}); err != nil {
+				transport.ErrorResponse(w, r, err)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// parseDockerHubHook gets the image pushed from a Docker Hub webhook
+// payload. See https://docs.docker.com/docker-hub/webhooks/.
+func parseDockerHubHook(r *http.Request) ([]string, error) {
+	var payload struct {
+		Repository struct {
+			RepoName string `json:"repo_name"`
+		} `json:"repository"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+	if payload.Repository.RepoName == "" {
+		return nil, errors.New("no repository name in payload")
+	}
+	return []string{payload.Repository.RepoName}, nil
+}
+
+// parseQuayHook gets the image pushed from a Quay repository push
+// notification. See https://docs.quay.io/guides/notifications.html.
+func parseQuayHook(r *http.Request) ([]string, error) {
+	var payload struct {
+		DockerURL string `json:"docker_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+	if payload.DockerURL == "" {
+		return nil, errors.New("no docker_url in payload")
+	}
+	return []string{payload.DockerURL}, nil
+}
+
+// parseRegistryHook gets the images pushed from a Docker
+// Distribution (i.e., registry:2) notification, which may have
+// several events. Events other than pushes are ignored. See
+// https://docs.docker.com/registry/notifications/.
+func parseRegistryHook(r *http.Request) ([]string, error) {
+	var envelope struct {
+		Events []struct {
+			Action string `json:"action"`
+			Target struct {
+				Repository string `json:"repository"`
+			} `json:"target"`
+			Request struct {
+				Host string `json:"host"`
+			} `json:"request"`
+		} `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, event := range envelope.Events {
+		if event.Action != "push" {
+			continue
+		}
+		if event.Target.Repository == "" || event.Request.Host == "" {
+			return nil, errors.New("push event without repository or host")
+		}
+		names = append(names, event.Request.Host+"/"+event.Target.Repository)
+	}
+	return names, nil
+}
@@ -0,0 +1,122 @@
+package daemon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/flux/remote"
+)
+
+type notifyRecorder struct {
+	*remote.MockPlatform
+	names []string
+}
+
+func (p *notifyRecorder) NotifyChange(ctx context.Context, change remote.Change) error {
+	if change.Kind == remote.ImageChange {
+		p.names = append(p.names, change.Source.(remote.ImageUpdate).Name.String())
+	}
+	return nil
+}
+
+const registryNotification = `{
+  "events": [
+    {
+      "action": "push",
+      "target": {"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "repository": "team/app", "tag": "v1"},
+      "request": {"host": "registry.example.com:5000", "method": "PUT"}
+    },
+    {
+      "action": "pull",
+      "target": {"repository": "team/other"},
+      "request": {"host": "registry.example.com:5000", "method": "GET"}
+    },
+    {
+      "action": "push",
+      "target": {"repository": "team/app", "tag": "latest"},
+      "request": {"host": "registry.example.com:5000", "method": "PUT"}
+    }
+  ]
+}`
+
+func TestRegistryHooks(t *testing.T) {
+	for _, v := range []struct {
+		path     string
+		body     string
+		expected []string
+	}{
+		{
+			path:     "/v9/hook/image/dockerhub",
+			body:     `{"push_data": {"tag": "latest"}, "repository": {"repo_name": "weaveworks/helloworld", "namespace": "weaveworks"}}`,
+			expected: []string{"weaveworks/helloworld"},
+		},
+		{
+			path:     "/v9/hook/image/quay",
+			body:     `{"repository": "weaveworks/helloworld", "docker_url": "quay.io/weaveworks/helloworld", "updated_tags": ["latest"]}`,
+			expected: []string{"quay.io/weaveworks/helloworld"},
+		},
+		{
+			path:     "/v9/hook/image/registry",
+			body:     registryNotification,
+			expected: []string{"registry.example.com:5000/team/app"},
+		},
+	} {
+		platform := &notifyRecorder{MockPlatform: &remote.MockPlatform{}}
+		handler := NewHandler(platform, NewRouter(), "")
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, httptest.NewRequest("POST", v.path, strings.NewReader(v.body)))
+		if res.Code != http.StatusNoContent {
+			t.Errorf("%s: expected status %d, got %d: %s", v.path, http.StatusNoContent, res.Code, res.Body.String())
+			continue
+		}
+		if strings.Join(platform.names, ",") != strings.Join(v.expected, ",") {
+			t.Errorf("%s: expected notifications for %v, got %v", v.path, v.expected, platform.names)
+		}
+	}
+}
+
+func TestRegistryHooks_BadPayload(t *testing.T) {
+	platform := &notifyRecorder{MockPlatform: &remote.MockPlatform{}}
+	handler := NewHandler(platform, NewRouter(), "")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("POST", "/v9/hook/image/dockerhub", strings.NewReader(`{"repository": {}}`)))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, res.Code)
+	}
+	if len(platform.names) > 0 {
+		t.Errorf("expected no notifications, got %v", platform.names)
+	}
+}
+
+func TestRegistryHooks_Secret(t *testing.T) {
+	body := `{"docker_url": "quay.io/weaveworks/helloworld"}`
+	for _, v := range []struct {
+		name   string
+		query  string
+		header string
+		code   int
+	}{
+		{"no secret", "", "", http.StatusUnauthorized},
+		{"wrong secret", "?secret=wrong", "", http.StatusUnauthorized},
+		{"secret in query", "?secret=s3cret", "", http.StatusNoContent},
+		{"secret in header", "", "Bearer s3cret", http.StatusNoContent},
+	} {
+		platform := &notifyRecorder{MockPlatform: &remote.MockPlatform{}}
+		handler := NewHandler(platform, NewRouter(), "s3cret")
+		req := httptest.NewRequest("POST", "/v9/hook/image/quay"+v.query, strings.NewReader(body))
+		if v.header != "" {
+			req.Header.Set("Authorization", v.header)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != v.code {
+			t.Errorf("%s: expected status %d, got %d", v.name, v.code, res.Code)
+		}
+		if notified := len(platform.names) > 0; notified != (v.code == http.StatusNoContent) {
+			t.Errorf("%s: unexpected notifications %v", v.name, platform.names)
+		}
+	}
+}
@@ -34,6 +34,9 @@ func NewRouter() *mux.Router {
 
 	r.NewRoute().Methods("POST").Name("GitPushHook").Path("/v9/hook/git").Queries("repo", "{repo}")
 	r.NewRoute().Methods("POST").Name("ImagePushHook").Path("/v9/hook/image").Queries("name", "{name}")
+	r.NewRoute().Methods("POST").Name("DockerHubHook").Path("/v9/hook/image/dockerhub")
+	r.NewRoute().Methods("POST").Name("QuayHook").Path("/v9/hook/image/quay")
+	r.NewRoute().Methods("POST").Name("RegistryHook").Path("/v9/hook/image/registry")
 
 	// All old versions are deprecated in the daemon. Use an up to
 	// date client!
@@ -47,12 +50,18 @@ func NewRouter() *mux.Router {
 	return r
 }
 
-func NewHandler(d remote.Platform, r *mux.Router) http.Handler {
-	handle := HTTPServer{d}
+// NewHandler makes a handler for the API routes given. If hookSecret
+// is not empty, the registry webhooks require it.
+func NewHandler(d remote.Platform, r *mux.Router, hookSecret string) http.Handler {
+	handle := HTTPServer{daemon: d, hookSecret: hookSecret}
 	r.Get("JobStatus").HandlerFunc(handle.JobStatus)
 	r.Get("SyncStatus").HandlerFunc(handle.SyncStatus)
 	r.Get("UpdateImages").HandlerFunc(handle.UpdateImages)
+	r.Get("UpdateImagesV10").HandlerFunc(handle.UpdateImages)
 	r.Get("UpdatePolicies").HandlerFunc(handle.UpdatePolicies)
+	r.Get("UpdatePoliciesV10").HandlerFunc(handle.UpdatePolicies)
+	r.Get("Promote").HandlerFunc(handle.Promote)
+	r.Get("ApplyPlanV10").HandlerFunc(handle.ApplyPlan)
 	r.Get("ListServices").HandlerFunc(handle.ListServices)
 	r.Get("ListImages").HandlerFunc(handle.ListImages)
 	r.Get("Export").HandlerFunc(handle.Export)
@@ -61,6 +70,9 @@ func NewHandler(d remote.Platform, r *mux.Router) http.Handler {
 
 	r.Get("GitPushHook").HandlerFunc(handle.GitPushHook)
 	r.Get("ImagePushHook").HandlerFunc(handle.ImagePushHook)
+	r.Get("DockerHubHook").HandlerFunc(handle.registryHook(parseDockerHubHook))
+	r.Get("QuayHook").HandlerFunc(handle.registryHook(parseQuayHook))
+	r.Get("RegistryHook").HandlerFunc(handle.registryHook(parseRegistryHook))
 
 	return middleware.Instrument{
 		RouteMatcher: r,
@@ -69,7 +81,8 @@ func NewHandler(d remote.Platform, r *mux.Router) http.Handler {
 }
 
 type HTTPServer struct {
-	daemon remote.Platform
+	daemon     remote.Platform
+	hookSecret string
 }
 
 func (s HTTPServer) GitPushHook(w http.ResponseWriter, r *http.Request) {
@@ -141,9 +154,8 @@ func (s HTTPServer) ListImages(w http.ResponseWriter, r *http.Request) {
 
 func (s HTTPServer) UpdateImages(w http.ResponseWriter, r *http.Request) {
 	var (
-		vars  = mux.Vars(r)
-		image = vars["image"]
-		kind  = vars["kind"]
+		vars = mux.Vars(r)
+		kind = vars["kind"]
 	)
 	if err := r.ParseForm(); err != nil {
 		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing form"))
@@ -158,10 +170,14 @@ func (s HTTPServer) UpdateImages(w http.ResponseWriter, r *http.Request) {
 		}
 		serviceSpecs = append(serviceSpecs, serviceSpec)
 	}
-	imageSpec, err := update.ParseImageSpec(image)
-	if err != nil {
-		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing image spec %q", image))
-		return
+	var imageSpecs []update.ImageSpec
+	for _, image := range r.Form["image"] {
+		imageSpec, err := update.ParseImageSpec(image)
+		if err != nil {
+			transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing image spec %q", image))
+			return
+		}
+		imageSpecs = append(imageSpecs, imageSpec)
 	}
 	releaseKind, err := update.ParseReleaseKind(kind)
 	if err != nil {
@@ -181,10 +197,11 @@ func (s HTTPServer) UpdateImages(w http.ResponseWriter, r *http.Request) {
 
 	spec := update.ReleaseSpec{
 		ServiceSpecs: serviceSpecs,
-		ImageSpec:    imageSpec,
 		Kind:         releaseKind,
 		Excludes:     excludes,
+		SavePlan:     r.FormValue("save-plan") == "true",
 	}
+	spec.ImageSpec, spec.ImageSpecs = update.ReleaseImages(imageSpecs...)
 	cause := update.Cause{
 		User:    r.FormValue("user"),
 		Message: r.FormValue("message"),
@@ -218,6 +235,43 @@ func (s HTTPServer) UpdatePolicies(w http.ResponseWriter, r *http.Request) {
 	transport.JSONResponse(w, r, jobID)
 }
 
+func (s HTTPServer) Promote(w http.ResponseWriter, r *http.Request) {
+	var spec update.PromoteSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	cause := update.Cause{
+		User:    r.FormValue("user"),
+		Message: r.FormValue("message"),
+	}
+
+	jobID, err := s.daemon.UpdateManifests(r.Context(), update.Spec{Type: update.Promote, Cause: cause, Spec: spec})
+	if err != nil {
+		transport.ErrorResponse(w, r, err)
+		return
+	}
+
+	transport.JSONResponse(w, r, jobID)
+}
+
+func (s HTTPServer) ApplyPlan(w http.ResponseWriter, r *http.Request) {
+	cause := update.Cause{
+		User:    r.FormValue("user"),
+		Message: r.FormValue("message"),
+	}
+
+	spec := update.ApplyPlanSpec{ID: mux.Vars(r)["id"]}
+	jobID, err := s.daemon.UpdateManifests(r.Context(), update.Spec{Type: update.ApplyPlan, Cause: cause, Spec: spec})
+	if err != nil {
+		transport.ErrorResponse(w, r, err)
+		return
+	}
+
+	transport.JSONResponse(w, r, jobID)
+}
+
 func (s HTTPServer) ListServices(w http.ResponseWriter, r *http.Request) {
 	namespace := mux.Vars(r)["namespace"]
 	res, err := s.daemon.ListServices(r.Context(), namespace)
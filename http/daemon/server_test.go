@@ -0,0 +1,147 @@
+package daemon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/flux"
+	fluxerr "github.com/weaveworks/flux/errors"
+	transport "github.com/weaveworks/flux/http"
+	"github.com/weaveworks/flux/http/client"
+	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/remote"
+	"github.com/weaveworks/flux/update"
+)
+
+func isUpgradeNeeded(err error) bool {
+	ferr, ok := err.(*fluxerr.Error)
+	return ok && ferr.Type == fluxerr.User && strings.Contains(ferr.Help, "needs to be upgraded")
+}
+
+// oldDaemon serves the API as a daemon from before version 10 would,
+// answering requests for any version 10 routes as not found.
+func oldDaemon(platform remote.Platform) http.Handler {
+	handler := NewHandler(platform, NewRouter(), "")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v10/") {
+			transport.WriteError(w, r, http.StatusNotFound, transport.MakeAPINotFound(r.URL.Path))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func TestUpdateImages_MultipleImages(t *testing.T) {
+	var got update.ReleaseSpec
+	platform := &remote.MockPlatform{
+		UpdateManifestsArgTest: func(s update.Spec) error {
+			got = s.Spec.(update.ReleaseSpec)
+			return nil
+		},
+	}
+	server := httptest.NewServer(NewHandler(platform, NewRouter(), ""))
+	defer server.Close()
+
+	images := []update.ImageSpec{"quay.io/weaveworks/helloworld:v2", "quay.io/weaveworks/sidecar:v2"}
+	spec := update.ReleaseSpec{
+		ServiceSpecs: []update.ResourceSpec{update.ResourceSpecAll},
+		Kind:         update.ReleaseKindExecute,
+	}
+	spec.ImageSpec, spec.ImageSpecs = update.ReleaseImages(images...)
+
+	c := client.New(http.DefaultClient, NewRouter(), server.URL, "")
+	if _, err := c.UpdateImages(context.Background(), spec, update.Cause{}); err != nil {
+		t.Fatal(err)
+	}
+	if released := got.Images(); len(released) != 2 || released[0] != images[0] || released[1] != images[1] {
+		t.Errorf("expected images %v to be released, got %v", images, released)
+	}
+
+	// A daemon that doesn't know about releasing several images at
+	// once doesn't have the route, so refuses the release outright.
+	oldServer := httptest.NewServer(oldDaemon(platform))
+	defer oldServer.Close()
+	got = update.ReleaseSpec{}
+	c = client.New(http.DefaultClient, NewRouter(), oldServer.URL, "")
+	if _, err := c.UpdateImages(context.Background(), spec, update.Cause{}); !isUpgradeNeeded(err) {
+		t.Errorf("expected upgrade needed error from daemon without multiple image releases, got %v", err)
+	}
+	if len(got.ServiceSpecs) > 0 {
+		t.Errorf("expected no release, got %+v", got)
+	}
+}
+
+func TestUpdatePolicies_PolicyFile(t *testing.T) {
+	var got policy.Updates
+	platform := &remote.MockPlatform{
+		UpdateManifestsArgTest: func(s update.Spec) error {
+			got = s.Spec.(policy.Updates)
+			return nil
+		},
+	}
+	id := flux.MustParseResourceID("default:deployment/helloworld")
+	updates := policy.Updates{
+		id: policy.Update{Add: policy.Set{policy.Automated: "true"}, InPolicyFile: true},
+	}
+
+	server := httptest.NewServer(NewHandler(platform, NewRouter(), ""))
+	defer server.Close()
+	c := client.New(http.DefaultClient, NewRouter(), server.URL, "")
+	if _, err := c.UpdatePolicies(context.Background(), updates, update.Cause{}); err != nil {
+		t.Fatal(err)
+	}
+	if !got[id].InPolicyFile {
+		t.Errorf("expected update to the policy file, got %+v", got)
+	}
+
+	// A daemon that doesn't know about the policy file doesn't have
+	// the route, so refuses the update rather than writing it to the
+	// manifest.
+	oldServer := httptest.NewServer(oldDaemon(platform))
+	defer oldServer.Close()
+	got = nil
+	c = client.New(http.DefaultClient, NewRouter(), oldServer.URL, "")
+	if _, err := c.UpdatePolicies(context.Background(), updates, update.Cause{}); !isUpgradeNeeded(err) {
+		t.Errorf("expected upgrade needed error from daemon without the policy file, got %v", err)
+	}
+	if got != nil {
+		t.Errorf("expected no update, got %+v", got)
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	var got update.ApplyPlanSpec
+	platform := &remote.MockPlatform{
+		UpdateManifestsArgTest: func(s update.Spec) error {
+			got = s.Spec.(update.ApplyPlanSpec)
+			return nil
+		},
+	}
+	id := "6f2a4b1c-1d3e-4f5a-8b9c-0d1e2f3a4b5c"
+
+	server := httptest.NewServer(NewHandler(platform, NewRouter(), ""))
+	defer server.Close()
+	c := client.New(http.DefaultClient, NewRouter(), server.URL, "")
+	if _, err := c.ApplyPlan(context.Background(), id, update.Cause{}); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != id {
+		t.Errorf("expected plan %q to be applied, got %+v", id, got)
+	}
+
+	// A daemon that doesn't know about release plans doesn't have the
+	// route, so says it needs upgrading rather than just not found.
+	oldServer := httptest.NewServer(oldDaemon(platform))
+	defer oldServer.Close()
+	got = update.ApplyPlanSpec{}
+	c = client.New(http.DefaultClient, NewRouter(), oldServer.URL, "")
+	if _, err := c.ApplyPlan(context.Background(), id, update.Cause{}); !isUpgradeNeeded(err) {
+		t.Errorf("expected upgrade needed error from daemon without release plans, got %v", err)
+	}
+	if got.ID != "" {
+		t.Errorf("expected no plan to be applied, got %+v", got)
+	}
+}
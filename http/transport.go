@@ -33,7 +33,18 @@ func NewAPIRouter() *mux.Router {
 	r.NewRoute().Name("ListImages").Methods("GET").Path("/v6/images").Queries("service", "{service}")
 
 	r.NewRoute().Name("UpdateImages").Methods("POST").Path("/v6/update-images").Queries("service", "{service}", "image", "{image}", "kind", "{kind}")
+	// Releases of more than one image, and dry runs that save their
+	// plan, go to their own route, so that a daemon that would only
+	// release the first image, or drop the plan, responds with a 404
+	// instead.
+	r.NewRoute().Name("UpdateImagesV10").Methods("POST").Path("/v10/update-images").Queries("service", "{service}", "image", "{image}", "kind", "{kind}")
 	r.NewRoute().Name("UpdatePolicies").Methods("PATCH").Path("/v6/policies")
+	// Likewise, updates to the policy file go to their own route, so
+	// a daemon that would write them to the manifests responds with a
+	// 404 instead.
+	r.NewRoute().Name("UpdatePoliciesV10").Methods("PATCH").Path("/v10/policies")
+	r.NewRoute().Name("Promote").Methods("POST").Path("/v6/promote")
+	r.NewRoute().Name("ApplyPlanV10").Methods("POST").Path("/v10/apply-plan").Queries("id", "{id}")
 	r.NewRoute().Name("JobStatus").Methods("GET").Path("/v6/jobs").Queries("id", "{id}")
 	r.NewRoute().Name("SyncStatus").Methods("GET").Path("/v6/sync").Queries("ref", "{ref}")
 	r.NewRoute().Name("Export").Methods("HEAD", "GET").Path("/v6/export")
@@ -48,6 +59,7 @@ func UpstreamRoutes(r *mux.Router) {
 	r.NewRoute().Name("RegisterDaemonV7").Methods("GET").Path("/v7/daemon")
 	r.NewRoute().Name("RegisterDaemonV8").Methods("GET").Path("/v8/daemon")
 	r.NewRoute().Name("RegisterDaemonV9").Methods("GET").Path("/v9/daemon")
+	r.NewRoute().Name("RegisterDaemonV10").Methods("GET").Path("/v10/daemon")
 	r.NewRoute().Name("LogEvent").Methods("POST").Path("/v6/events")
 }
 
@@ -20,6 +20,7 @@ var (
 	ErrInvalidImageID   = errors.New("invalid image ID")
 	ErrBlankImageID     = errors.Wrap(ErrInvalidImageID, "blank image name")
 	ErrMalformedImageID = errors.Wrap(ErrInvalidImageID, `expected image name as either <image>:<tag> or just <image>`)
+	ErrMalformedDigest  = errors.Wrap(ErrInvalidImageID, `expected digest as <algorithm>:<hex>, e.g., sha256:0123...`)
 )
 
 // Name represents an unversioned (i.e., untagged) image a.k.a.,
@@ -91,6 +92,7 @@ func (i Name) CanonicalName() CanonicalName {
 	}
 }
 
+// ToRef makes a Ref for the tag given, in the repository.
 func (i Name) ToRef(tag string) Ref {
 	return Ref{
 		Name: i,
@@ -102,14 +104,19 @@ func (i Name) ToRef(tag string) Ref {
 // allowed to be empty, though it is in general undefined what that
 // means. As such, `Ref` also includes all `Name` values.
 //
+// A ref may also have a digest, which pins it to exactly the image
+// with that manifest, whatever the tag now points at.
+//
 // Examples (stringified):
 //  * alpine:3.5
 //  * library/alpine:3.5
 //  * quay.io/weaveworks/flux:1.1.0
 //  * localhost:5000/arbitrary/path/to/repo:revision-sha1
+//  * quay.io/weaveworks/flux:1.1.0@sha256:2ec1...
 type Ref struct {
 	Name
-	Tag string
+	Tag    string
+	Digest string
 }
 
 // CanonicalRef is an image ref with none of the fields left to be
@@ -120,11 +127,14 @@ type CanonicalRef struct {
 
 // String returns the Ref as a string (i.e., unparsed) without canonicalising it.
 func (i Ref) String() string {
-	var tag string
+	var tag, digest string
 	if i.Tag != "" {
 		tag = ":" + i.Tag
 	}
-	return fmt.Sprintf("%s%s", i.Name.String(), tag)
+	if i.Digest != "" {
+		digest = "@" + i.Digest
+	}
+	return fmt.Sprintf("%s%s%s", i.Name.String(), tag, digest)
 }
 
 // ParseRef parses a string representation of an image id into an
@@ -140,6 +150,18 @@ func ParseRef(s string) (Ref, error) {
 		return id, ErrMalformedImageID
 	}
 
+	// Take off the digest, if there is one
+	if at := strings.Index(s, "@"); at >= 0 {
+		if !digestRegexp.MatchString(s[at+1:]) {
+			return id, ErrMalformedDigest
+		}
+		id.Digest = s[at+1:]
+		s = s[:at]
+		if s == "" {
+			return id, ErrMalformedImageID
+		}
+	}
+
 	elements := strings.Split(s, "/")
 	switch len(elements) {
 	case 0: // NB strings.Split will never return []
@@ -180,6 +202,8 @@ var (
 	domainComponent = `([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
 	domain          = fmt.Sprintf(`localhost|(%s([.]%s)+)(:[0-9]+)?`, domainComponent, domainComponent)
 	domainRegexp    = regexp.MustCompile(domain)
+	// From the grammar for references (linked above), but anchored
+	digestRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
 )
 
 // ImageID is serialized/deserialized as a string
@@ -203,8 +227,9 @@ func (i Ref) CanonicalRef() CanonicalRef {
 	name := i.CanonicalName()
 	return CanonicalRef{
 		Ref: Ref{
-			Name: name.Name,
-			Tag:  i.Tag,
+			Name:   name.Name,
+			Tag:    i.Tag,
+			Digest: i.Digest,
 		},
 	}
 }
@@ -213,11 +238,22 @@ func (i Ref) Components() (domain, repo, tag string) {
 	return i.Domain, i.Image, i.Tag
 }
 
-// WithNewTag makes a new copy of an ImageID with a new tag
+// WithNewTag makes a new copy of an ImageID with a new tag. Since
+// the digest of the image at the new tag is not known, it's left
+// off.
 func (i Ref) WithNewTag(t string) Ref {
 	var img Ref
 	img = i
 	img.Tag = t
+	img.Digest = ""
+	return img
+}
+
+// WithDigest makes a new copy of an ImageID, pinned to the digest
+// given (or not pinned, if it's empty).
+func (i Ref) WithDigest(d string) Ref {
+	img := i
+	img.Digest = d
 	return img
 }
 
@@ -235,6 +271,13 @@ type Info struct {
 	ImageID string
 	// the time at which the image pointed at was created
 	CreatedAt time.Time
+	// if the reference is to a manifest list, the digest of the image
+	// for each platform in the list, keyed by platform (e.g.,
+	// "linux/arm64"); the other fields are for the image for the
+	// platform fluxd is configured to look at
+	Platforms map[string]string `json:",omitempty"`
+	// the labels given in the image's config, if any
+	Labels map[string]string `json:",omitempty"`
 }
 
 // MarshalJSON returns the Info value in JSON (as bytes). It is
@@ -282,14 +325,21 @@ type ByCreatedDesc []Info
 func (is ByCreatedDesc) Len() int      { return len(is) }
 func (is ByCreatedDesc) Swap(i, j int) { is[i], is[j] = is[j], is[i] }
 func (is ByCreatedDesc) Less(i, j int) bool {
+	return NewerByCreated(&is[i], &is[j])
+}
+
+// NewerByCreated returns true if lhs image should be considered newer
+// than rhs, going by creation date. Images with no creation date are
+// considered newer than those with one, so that they sort to the top.
+func NewerByCreated(lhs, rhs *Info) bool {
 	switch {
-	case is[i].CreatedAt.IsZero():
+	case lhs.CreatedAt.IsZero():
 		return true
-	case is[j].CreatedAt.IsZero():
+	case rhs.CreatedAt.IsZero():
 		return false
-	case is[i].CreatedAt.Equal(is[j].CreatedAt):
-		return is[i].ID.String() < is[j].ID.String()
+	case lhs.CreatedAt.Equal(rhs.CreatedAt):
+		return lhs.ID.String() < rhs.ID.String()
 	default:
-		return is[i].CreatedAt.After(is[j].CreatedAt)
+		return lhs.CreatedAt.After(rhs.CreatedAt)
 	}
 }
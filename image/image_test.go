@@ -10,7 +10,10 @@ import (
 	"time"
 )
 
-const constTime = "2017-01-13T16:22:58.009923189Z"
+const (
+	constTime  = "2017-01-13T16:22:58.009923189Z"
+	testDigest = "sha256:2ec1bd66a9d5b35eb5df8d5a5b6a38a7e6a1bfe4fd1a3b8f2fe68d0a6f1cf4e3"
+)
 
 var (
 	testTime, _ = time.Parse(time.RFC3339Nano, constTime)
@@ -52,6 +55,10 @@ func TestParseRef(t *testing.T) {
 		{"quay.io/library/alpine:latest", "quay.io", "library/alpine", "quay.io/library/alpine:latest"},
 		{"quay.io/library/alpine:mytag", "quay.io", "library/alpine", "quay.io/library/alpine:mytag"},
 		{"localhost:5000/path/to/repo/alpine:mytag", "localhost:5000", "path/to/repo/alpine", "localhost:5000/path/to/repo/alpine:mytag"},
+		// An image can be pinned by digest, with or without a tag
+		{"alpine:3.5@" + testDigest, dockerHubHost, "library/alpine", "index.docker.io/library/alpine:3.5@" + testDigest},
+		{"alpine@" + testDigest, dockerHubHost, "library/alpine", "index.docker.io/library/alpine@" + testDigest},
+		{"localhost:5000/hello:v1.1@" + testDigest, "localhost:5000", "hello", "localhost:5000/hello:v1.1@" + testDigest},
 	} {
 		i, err := ParseRef(x.test)
 		if err != nil {
@@ -80,6 +87,10 @@ func TestParseRefErrorCases(t *testing.T) {
 		{":tag"},
 		{"/leading/slash"},
 		{"trailing/slash/"},
+		{"@" + testDigest},
+		{"alpine@"},
+		{"alpine:3.5@sha256:abc"},
+		{"alpine:3.5@" + testDigest + "@" + testDigest},
 	} {
 		_, err := ParseRef(x.test)
 		if err == nil {
@@ -112,6 +123,22 @@ func TestComponents(t *testing.T) {
 	}
 }
 
+func TestRefDigest(t *testing.T) {
+	ref, err := ParseRef("quay.io/my/repo:mytag@" + testDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Tag != "mytag" || ref.Digest != testDigest {
+		t.Errorf("expected tag and digest to be parsed, got %#v", ref)
+	}
+	if newRef := ref.WithNewTag("other"); newRef.String() != "quay.io/my/repo:other" {
+		t.Errorf("expected a new tag to drop the digest, got %q", newRef.String())
+	}
+	if unpinned := ref.WithDigest(""); unpinned.String() != "quay.io/my/repo:mytag" {
+		t.Errorf("expected no digest, got %q", unpinned.String())
+	}
+}
+
 func TestRefSerialization(t *testing.T) {
 	for _, x := range []struct {
 		test     Ref
@@ -119,6 +146,7 @@ func TestRefSerialization(t *testing.T) {
 	}{
 		{Ref{Name: Name{Image: "alpine"}, Tag: "a123"}, `"alpine:a123"`},
 		{Ref{Name: Name{Domain: "quay.io", Image: "weaveworks/foobar"}, Tag: "baz"}, `"quay.io/weaveworks/foobar:baz"`},
+		{Ref{Name: Name{Image: "alpine"}, Tag: "a123", Digest: testDigest}, `"alpine:a123@` + testDigest + `"`},
 	} {
 		serialized, err := json.Marshal(x.test)
 		if err != nil {
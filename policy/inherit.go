@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"github.com/weaveworks/flux"
+)
+
+// Source says where a policy in effect for a controller was given.
+type Source string
+
+const (
+	// SourceController is for policies given on the controller
+	// itself.
+	SourceController = Source("controller")
+	// SourcePolicyFile is for policies given in the policy file at
+	// the root of the manifests.
+	SourcePolicyFile = Source("policy file")
+	// SourceNamespace is for policies given as defaults on the
+	// namespace the controller is in.
+	SourceNamespace = Source("namespace")
+)
+
+// precedence lists the sources of policies, from the one that takes
+// precedence over all the others to the one that gives way to all
+// the others.
+var precedence = []Source{SourceController, SourcePolicyFile, SourceNamespace}
+
+// SourceMap records, for each controller, where each of its policies
+// came from.
+type SourceMap map[flux.ResourceID]map[Policy]Source
+
+// Inheritable reports whether a policy given as a default (e.g., on
+// a namespace) applies to the controllers it covers. Locks are not
+// inherited, since a lock is about a particular controller.
+func Inheritable(p Policy) bool {
+	switch p {
+	case Automated, Ignore, PinDigest:
+		return true
+	}
+	return Tag(p) || MinAge(p)
+}
+
+// Inherit gives the policies in effect for a controller, from its own
+// policies and the defaults given for it, along with where each came
+// from. It's Effective with only those two sources.
+func Inherit(own, defaults Set) (Set, map[Policy]Source) {
+	return Effective(map[Source]Set{
+		SourceController: own,
+		SourceNamespace:  defaults,
+	})
+}
+
+// Effective gives the policies in effect for a controller, from the
+// policies given for it by each source, along with where each came
+// from. The sets are as given in annotations; i.e., boolean policies
+// may have values other than "true".
+//
+// A policy given on the controller takes precedence over one given
+// in the policy file, which takes precedence over a default given on
+// the namespace. Only the policies for which Inheritable is true are
+// taken from the namespace. A boolean policy given with any value
+// other than "true" (e.g., "false") is not in effect, and switches
+// off the same policy from sources lower down.
+func Effective(sets map[Source]Set) (Set, map[Policy]Source) {
+	effective := Set{}
+	sources := map[Policy]Source{}
+	seen := map[Policy]bool{}
+	for _, source := range precedence {
+		for p, v := range sets[source] {
+			if seen[p] || (source == SourceNamespace && !Inheritable(p)) {
+				continue
+			}
+			seen[p] = true
+			if Boolean(p) && v != "true" {
+				continue
+			}
+			effective[p] = v
+			sources[p] = source
+		}
+	}
+	return effective, sources
+}
+
+// Overriding gives the update with each policy it removes replaced by
+// an override, if the policy would otherwise still be in effect from
+// those inherited (e.g., from the namespace); removing it from the
+// controller alone would change nothing. A boolean policy is set to
+// "false", and a tag filter to match all tags.
+func (u Update) Overriding(inherited Set) Update {
+	result := Update{Add: Set{}, Remove: Set{}, InPolicyFile: u.InPolicyFile}
+	for p, v := range u.Add {
+		result.Add[p] = v
+	}
+	for p, v := range u.Remove {
+		if _, ok := inherited[p]; !ok {
+			result.Remove[p] = v
+			continue
+		}
+		switch {
+		case Boolean(p):
+			result.Add[p] = "false"
+		case Tag(p):
+			result.Add[p] = PatternAll.String()
+		default:
+			result.Remove[p] = v
+		}
+	}
+	return result
+}
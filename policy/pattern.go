@@ -0,0 +1,308 @@
+package policy
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/pkg/errors"
+	glob "github.com/ryanuber/go-glob"
+
+	"github.com/weaveworks/flux/image"
+)
+
+const (
+	globPrefix             = "glob:"
+	semverPrefix           = "semver:"
+	semverPrereleasePrefix = "semver-prerelease:"
+	regexpPrefix           = "regex:"
+	regexpNumericPrefix    = "regex-num:"
+	regexpLexicalPrefix    = "regex-lex:"
+)
+
+var (
+	// PatternAll matches everything.
+	PatternAll = NewGlobPattern("*")
+
+	ErrInvalidPattern = errors.New("invalid tag filter pattern")
+
+	implicitAndRegexp = regexp.MustCompile(`([0-9A-Za-z*])\s+([<>=!~^])`)
+)
+
+// Pattern provides an interface to match image tags, and to decide
+// which of two matching images is the newer.
+type Pattern interface {
+	// Matches reports whether the tag matches the pattern.
+	Matches(tag string) bool
+	// Newer reports whether image a should be considered newer than
+	// image b. Both are assumed to match the pattern.
+	Newer(a, b *image.Info) bool
+	// String returns the pattern in the form it is given in a policy,
+	// i.e., including its prefix.
+	String() string
+}
+
+// GlobPattern matches tags using shell-style globs, with `*` meaning
+// "match anything". Images are ordered by creation time.
+type GlobPattern string
+
+// SemverPattern matches tags which are semantic versions satisfying a
+// constraint, e.g., `~1.4` or `>=2.0.0 <3.0.0`. Images are ordered by
+// version, highest first.
+//
+// Pre-release versions (e.g., 1.5.0-rc.1) are not matched, unless the
+// pattern is given with the `semver-prerelease:` prefix, in which case
+// pre-releases of the versions satisfying the constraint are matched
+// too; e.g., `semver-prerelease:~1.5` matches 1.5.0-rc.1.
+type SemverPattern struct {
+	pattern     string
+	constraints *semver.Constraints
+	prerelease  bool
+}
+
+// RegexpPattern matches tags using a regular expression. If the
+// expression has a named capture group, e.g., `(?P<ts>\d+)`, images are
+// ordered by the value captured by the first such group, highest
+// first. Given with the prefix `regex-num:`, the values are compared
+// as numbers; with `regex-lex:`, as strings; and with `regex:`,
+// numerically if both values are numbers, and lexically otherwise.
+// Without a named group, images are ordered by creation time.
+//
+// The expression is not implicitly anchored, so use `^` and `$` to
+// match the whole tag. The tag `latest` is only matched if the
+// expression mentions it.
+type RegexpPattern struct {
+	pattern string
+	regexp  *regexp.Regexp
+	// index of the named group to order by, or zero if none
+	orderBy int
+	order   regexpOrder
+}
+
+// regexpOrder says how the values captured by a RegexpPattern are
+// compared.
+type regexpOrder int
+
+const (
+	// numerically if both are numbers, otherwise lexically
+	orderGuess regexpOrder = iota
+	orderNumeric
+	orderLexical
+)
+
+// NewGlobPattern returns a pattern matching tags against the glob
+// given, which should not include the `glob:` prefix.
+func NewGlobPattern(pattern string) GlobPattern {
+	return GlobPattern(pattern)
+}
+
+// NewSemverPattern returns a pattern matching tags against the
+// semantic version constraint given, which should not include the
+// `semver:` prefix.
+func NewSemverPattern(constraint string) (SemverPattern, error) {
+	// The semver library wants comparisons to be combined with
+	// commas, but it's common to see them separated by just a space,
+	// e.g., ">=2.0.0 <3.0.0", so accept that too.
+	c, err := semver.NewConstraint(implicitAndRegexp.ReplaceAllString(constraint, "$1, $2"))
+	if err != nil {
+		return SemverPattern{}, errors.Wrapf(ErrInvalidPattern, "semver constraint %q: %s", constraint, err.Error())
+	}
+	return SemverPattern{pattern: constraint, constraints: c}, nil
+}
+
+// NewSemverPrereleasePattern returns a pattern like NewSemverPattern,
+// which also matches pre-releases of the versions satisfying the
+// constraint. The constraint should not include the
+// `semver-prerelease:` prefix.
+func NewSemverPrereleasePattern(constraint string) (SemverPattern, error) {
+	p, err := NewSemverPattern(constraint)
+	p.prerelease = err == nil
+	return p, err
+}
+
+// NewRegexpPattern returns a pattern matching tags against the
+// regular expression given, which should not include the `regex:`
+// prefix.
+func NewRegexpPattern(expr string) (RegexpPattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return RegexpPattern{}, errors.Wrapf(ErrInvalidPattern, "regular expression %q: %s", expr, err.Error())
+	}
+	var orderBy int
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			orderBy = i
+			break
+		}
+	}
+	return RegexpPattern{pattern: expr, regexp: re, orderBy: orderBy}, nil
+}
+
+// NewNumericRegexpPattern returns a pattern like NewRegexpPattern,
+// which orders images by the value of the named group as a number.
+// The expression should not include the `regex-num:` prefix.
+func NewNumericRegexpPattern(expr string) (RegexpPattern, error) {
+	p, err := NewRegexpPattern(expr)
+	p.order = orderNumeric
+	return p, err
+}
+
+// NewLexicalRegexpPattern returns a pattern like NewRegexpPattern,
+// which orders images by the value of the named group as a string.
+// The expression should not include the `regex-lex:` prefix.
+func NewLexicalRegexpPattern(expr string) (RegexpPattern, error) {
+	p, err := NewRegexpPattern(expr)
+	p.order = orderLexical
+	return p, err
+}
+
+// ParsePattern parses a tag filter as it appears in a policy. Patterns
+// with no recognised prefix are treated as globs.
+func ParsePattern(pattern string) (Pattern, error) {
+	switch {
+	case strings.HasPrefix(pattern, semverPrereleasePrefix):
+		return NewSemverPrereleasePattern(strings.TrimPrefix(pattern, semverPrereleasePrefix))
+	case strings.HasPrefix(pattern, semverPrefix):
+		return NewSemverPattern(strings.TrimPrefix(pattern, semverPrefix))
+	case strings.HasPrefix(pattern, regexpNumericPrefix):
+		return NewNumericRegexpPattern(strings.TrimPrefix(pattern, regexpNumericPrefix))
+	case strings.HasPrefix(pattern, regexpLexicalPrefix):
+		return NewLexicalRegexpPattern(strings.TrimPrefix(pattern, regexpLexicalPrefix))
+	case strings.HasPrefix(pattern, regexpPrefix):
+		return NewRegexpPattern(strings.TrimPrefix(pattern, regexpPrefix))
+	case strings.HasPrefix(pattern, globPrefix):
+		return NewGlobPattern(strings.TrimPrefix(pattern, globPrefix)), nil
+	default:
+		return NewGlobPattern(pattern), nil
+	}
+}
+
+func (g GlobPattern) Matches(tag string) bool {
+	// Ignore latest if and only if it's not what the user wants.
+	if !strings.EqualFold(string(g), "latest") && strings.EqualFold(tag, "latest") {
+		return false
+	}
+	return glob.Glob(string(g), tag)
+}
+
+func (g GlobPattern) Newer(a, b *image.Info) bool {
+	return image.NewerByCreated(a, b)
+}
+
+func (g GlobPattern) String() string {
+	return globPrefix + string(g)
+}
+
+func (s SemverPattern) Matches(tag string) bool {
+	v, err := semver.NewVersion(tag)
+	if err != nil {
+		return false
+	}
+	if s.constraints.Check(v) {
+		return true
+	}
+	if s.prerelease && v.Prerelease() != "" {
+		release, err := v.SetPrerelease("")
+		return err == nil && s.constraints.Check(&release)
+	}
+	return false
+}
+
+func (s SemverPattern) Newer(a, b *image.Info) bool {
+	va, erra := semver.NewVersion(a.ID.Tag)
+	vb, errb := semver.NewVersion(b.ID.Tag)
+	switch {
+	case erra != nil && errb != nil:
+		return image.NewerByCreated(a, b)
+	case erra != nil:
+		return false
+	case errb != nil:
+		return true
+	case va.Equal(vb):
+		// e.g., "v1.0.0" and "1.0.0"
+		return image.NewerByCreated(a, b)
+	default:
+		return va.GreaterThan(vb)
+	}
+}
+
+func (s SemverPattern) String() string {
+	if s.prerelease {
+		return semverPrereleasePrefix + s.pattern
+	}
+	return semverPrefix + s.pattern
+}
+
+func (r RegexpPattern) Matches(tag string) bool {
+	// As with globs, ignore latest unless the expression asks for it.
+	if strings.EqualFold(tag, "latest") && !strings.Contains(strings.ToLower(r.pattern), "latest") {
+		return false
+	}
+	return r.regexp.MatchString(tag)
+}
+
+func (r RegexpPattern) Newer(a, b *image.Info) bool {
+	if r.orderBy == 0 {
+		return image.NewerByCreated(a, b)
+	}
+	va, vb := r.orderValue(a.ID.Tag), r.orderValue(b.ID.Tag)
+	numeric := r.order == orderNumeric || (r.order == orderGuess && isNumber(va) && isNumber(vb))
+	if numeric {
+		// A number is newer than anything that isn't a number.
+		if isNumber(va) != isNumber(vb) {
+			return isNumber(va)
+		}
+	}
+	if numeric && isNumber(va) {
+		// Compare as (arbitrarily long) numbers: a longer number is
+		// bigger, otherwise compare digit by digit.
+		va, vb = strings.TrimLeft(va, "0"), strings.TrimLeft(vb, "0")
+		if len(va) != len(vb) {
+			return len(va) > len(vb)
+		}
+	}
+	if va == vb {
+		return image.NewerByCreated(a, b)
+	}
+	return va > vb
+}
+
+func (r RegexpPattern) String() string {
+	switch r.order {
+	case orderNumeric:
+		return regexpNumericPrefix + r.pattern
+	case orderLexical:
+		return regexpLexicalPrefix + r.pattern
+	default:
+		return regexpPrefix + r.pattern
+	}
+}
+
+func (r RegexpPattern) orderValue(tag string) string {
+	m := r.regexp.FindStringSubmatch(tag)
+	if m == nil {
+		return ""
+	}
+	return m[r.orderBy]
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// GetTagPattern returns the tag filter pattern for the container given,
+// from a set of policies; or PatternAll if there is no tag filter.
+func GetTagPattern(policies Set, container string) (Pattern, error) {
+	if pattern, ok := policies.Get(TagPrefix(container)); ok {
+		return ParsePattern(pattern)
+	}
+	return PatternAll, nil
+}
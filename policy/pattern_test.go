@@ -0,0 +1,227 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux/image"
+)
+
+func TestParsePattern(t *testing.T) {
+	for _, x := range []struct {
+		pattern string
+		want    string
+		err     bool
+	}{
+		{"*", "glob:*", false},
+		{"master-*", "glob:master-*", false},
+		{"glob:master-*", "glob:master-*", false},
+		{"semver:~1.4", "semver:~1.4", false},
+		{"semver:>=2.0.0 <3.0.0", "semver:>=2.0.0 <3.0.0", false},
+		{"semver:not a version", "", true},
+		{"semver-prerelease:~1.5", "semver-prerelease:~1.5", false},
+		{"semver-prerelease:not a version", "", true},
+		{"regex:^master-[0-9]+$", "regex:^master-[0-9]+$", false},
+		{"regex:^master-(", "", true},
+		{"regex-num:^master-(?P<build>[0-9]+)$", "regex-num:^master-(?P<build>[0-9]+)$", false},
+		{"regex-lex:^(?P<date>[0-9-]+)$", "regex-lex:^(?P<date>[0-9-]+)$", false},
+		{"regex-num:^master-(", "", true},
+	} {
+		p, err := ParsePattern(x.pattern)
+		if x.err {
+			if err == nil {
+				t.Errorf("expected error parsing %q, got pattern %q", x.pattern, p)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", x.pattern, err)
+			continue
+		}
+		if p.String() != x.want {
+			t.Errorf("parsing %q: expected %q, got %q", x.pattern, x.want, p.String())
+		}
+	}
+}
+
+func TestGlobPatternMatches(t *testing.T) {
+	for _, x := range []struct {
+		pattern string
+		tag     string
+		match   bool
+	}{
+		{"*", "1.0", true},
+		{"*", "latest", false},
+		{"latest", "latest", true},
+		{"master-*", "master-abc123", true},
+		{"master-*", "dev-abc123", false},
+	} {
+		if got := NewGlobPattern(x.pattern).Matches(x.tag); got != x.match {
+			t.Errorf("glob %q against %q: expected %v, got %v", x.pattern, x.tag, x.match, got)
+		}
+	}
+}
+
+func TestSemverPatternMatches(t *testing.T) {
+	for _, x := range []struct {
+		constraint string
+		tag        string
+		match      bool
+	}{
+		{"~1.4", "1.4.0", true},
+		{"~1.4", "v1.4.7", true},
+		{"~1.4", "1.5.0", false},
+		{"~1.4", "latest", false},
+		{"~1.4", "master-abc123", false},
+		{">=2.0.0 <3.0.0", "2.9.1", true},
+		{">=2.0.0 <3.0.0", "3.0.0", false},
+		// pre-releases only match if the constraint asks for them
+		{">=1.4.0", "1.5.0-rc.1", false},
+		{">=1.4.0-0", "1.5.0-rc.1", true},
+	} {
+		p, err := NewSemverPattern(x.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Matches(x.tag); got != x.match {
+			t.Errorf("semver %q against %q: expected %v, got %v", x.constraint, x.tag, x.match, got)
+		}
+	}
+}
+
+func TestSemverPrereleasePatternMatches(t *testing.T) {
+	for _, x := range []struct {
+		constraint string
+		tag        string
+		match      bool
+	}{
+		{"~1.5", "1.5.0", true},
+		{"~1.5", "1.5.0-rc.1", true},
+		{"~1.5", "v1.5.2-beta", true},
+		{"~1.5", "1.6.0-rc.1", false},
+		{"~1.5", "1.4.9", false},
+		{"~1.5", "latest", false},
+		{">=2.0.0 <3.0.0", "2.1.0-alpha.1", true},
+		{">=2.0.0 <3.0.0", "3.0.0-rc.1", false},
+	} {
+		p, err := NewSemverPrereleasePattern(x.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Matches(x.tag); got != x.match {
+			t.Errorf("semver-prerelease %q against %q: expected %v, got %v", x.constraint, x.tag, x.match, got)
+		}
+	}
+}
+
+func TestPatternNewer(t *testing.T) {
+	now := time.Now()
+	info := func(tag string, created time.Time) *image.Info {
+		return &image.Info{ID: image.Ref{Name: image.Name{Image: "foo"}, Tag: tag}, CreatedAt: created}
+	}
+
+	older, newer := info("1.10.0", now.Add(-time.Hour)), info("1.9.0", now)
+	if !PatternAll.Newer(newer, older) || PatternAll.Newer(older, newer) {
+		t.Errorf("expected glob pattern to order by creation time")
+	}
+
+	p, err := NewSemverPattern(">=1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Newer(older, newer) || p.Newer(newer, older) {
+		t.Errorf("expected semver pattern to order by version")
+	}
+
+	pre, err := NewSemverPrereleasePattern("~1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc1, rc2, release := info("1.5.0-rc.1", now), info("1.5.0-rc.2", now.Add(-time.Hour)), info("1.5.0", now.Add(-2*time.Hour))
+	if !pre.Newer(rc2, rc1) || !pre.Newer(release, rc2) || pre.Newer(rc1, release) {
+		t.Errorf("expected pre-releases to be ordered before the release they precede")
+	}
+}
+
+func TestRegexpPattern(t *testing.T) {
+	info := func(tag string, created time.Time) *image.Info {
+		return &image.Info{ID: image.Ref{Name: image.Name{Image: "foo"}, Tag: tag}, CreatedAt: created}
+	}
+	now := time.Now()
+
+	p, err := NewRegexpPattern(`^master-[0-9]+-[0-9a-f]+$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, match := range map[string]bool{
+		"master-100-abc123": true,
+		"master-abc123":     false,
+		"dev-100-abc123":    false,
+	} {
+		if got := p.Matches(tag); got != match {
+			t.Errorf("regex %q against %q: expected %v, got %v", p, tag, match, got)
+		}
+	}
+	// latest is only matched if asked for
+	for expr, match := range map[string]bool{
+		`.*`:                  false,
+		`^(latest|[0-9]+)$`:   true,
+		`(?i)^LATEST$`:        true,
+		`^master-[0-9]+$|^.+`: false,
+	} {
+		re, err := NewRegexpPattern(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := re.Matches("latest"); got != match {
+			t.Errorf("regex %q against %q: expected %v, got %v", expr, "latest", match, got)
+		}
+	}
+
+	// without a named group, order by creation
+	if !p.Newer(info("master-9-abc", now), info("master-10-def", now.Add(-time.Hour))) {
+		t.Errorf("expected regex without named group to order by creation time")
+	}
+
+	numeric, err := NewRegexpPattern(`^master-(?P<build>[0-9]+)-[0-9a-f]+$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rebuilt images are newer, but have lower build numbers
+	build9, build10 := info("master-9-abc", now), info("master-10-def", now.Add(-time.Hour))
+	if !numeric.Newer(build10, build9) || numeric.Newer(build9, build10) {
+		t.Errorf("expected regex with numeric group to order by number")
+	}
+	// equal numbers fall back to creation time
+	if !numeric.Newer(info("master-010-abc", now), info("master-10-def", now.Add(-time.Hour))) {
+		t.Errorf("expected equal numbers to be ordered by creation time")
+	}
+
+	lexical, err := NewRegexpPattern(`^(?P<date>[0-9]{4}-[0-9]{2}-[0-9]{2})-.*$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lexical.Newer(info("2018-01-10-abc", now.Add(-time.Hour)), info("2017-12-31-def", now)) {
+		t.Errorf("expected regex with non-numeric group to order lexically")
+	}
+
+	// The order can be given rather than guessed
+	forceLexical, err := NewLexicalRegexpPattern(`^master-(?P<build>[0-9]+)-[0-9a-f]+$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !forceLexical.Newer(build9, build10) || forceLexical.Newer(build10, build9) {
+		t.Errorf("expected regex-lex to order numbers lexically")
+	}
+	forceNumeric, err := NewNumericRegexpPattern(`^v(?P<version>[0-9a-z]+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v9, v10, vdev := info("v9", now), info("v10", now.Add(-time.Hour)), info("vdev", now)
+	if !forceNumeric.Newer(v10, v9) || forceNumeric.Newer(v9, v10) {
+		t.Errorf("expected regex-num to order numbers numerically")
+	}
+	if !forceNumeric.Newer(v9, vdev) || forceNumeric.Newer(vdev, v9) {
+		t.Errorf("expected regex-num to order numbers before anything else")
+	}
+}
@@ -3,6 +3,9 @@ package policy
 import (
 	"encoding/json"
 	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux"
 )
@@ -14,18 +17,23 @@ const (
 	LockedMsg  = Policy("locked_msg")
 	Automated  = Policy("automated")
 	TagAll     = Policy("tag_all")
+	PinDigest  = Policy("pin_digest")
 )
 
+// LockedUntil is when a lock expires, in RFC3339 format; a lock
+// without it lasts until it's removed.
+const LockedUntil = Policy("locked_until")
+
 // Policy is an string, denoting the current deployment policy of a service,
 // e.g. automated, or locked.
 type Policy string
 
 func Boolean(policy Policy) bool {
 	switch policy {
-	case Locked, Automated, Ignore:
+	case Locked, Automated, Ignore, PinDigest:
 		return true
 	}
-	return false
+	return LockedContainer(policy)
 }
 
 func TagPrefix(container string) Policy {
@@ -36,11 +44,91 @@ func Tag(policy Policy) bool {
 	return strings.HasPrefix(string(policy), "tag.")
 }
 
+// LockedPrefix gives the policy for locking just the container
+// given, rather than the whole controller; e.g., to keep a sidecar
+// where it is while the rest of the controller is released.
+func LockedPrefix(container string) Policy {
+	return Policy("locked." + container)
+}
+
+func LockedContainer(policy Policy) bool {
+	return strings.HasPrefix(string(policy), "locked.")
+}
+
+// IsContainerLocked reports whether the container given is locked,
+// from a set of policies.
+func IsContainerLocked(policies Set, container string) bool {
+	return policies.Contains(LockedPrefix(container))
+}
+
+// MinAgePrefix gives the policy for the minimum age of images that
+// will be automatically released to the container given.
+func MinAgePrefix(container string) Policy {
+	return Policy("min-age." + container)
+}
+
+func MinAge(policy Policy) bool {
+	return strings.HasPrefix(string(policy), "min-age.")
+}
+
+var ErrInvalidMinAge = errors.New("invalid minimum image age; expected a duration like 30m or 2h")
+
+// ParseMinAge parses the value of a min-age policy.
+func ParseMinAge(value string) (time.Duration, error) {
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, errors.Wrap(ErrInvalidMinAge, value)
+	}
+	return age, nil
+}
+
+// GetMinAge returns the minimum age of images that will be
+// automatically released to the container given, from a set of
+// policies; or zero if there's no minimum.
+func GetMinAge(policies Set, container string) (time.Duration, error) {
+	if value, ok := policies.Get(MinAgePrefix(container)); ok {
+		return ParseMinAge(value)
+	}
+	return 0, nil
+}
+
+var ErrInvalidLockedUntil = errors.New("invalid lock expiry; expected a time in RFC3339 format, e.g., 2006-01-02T15:04:05Z")
+
+// GetLockedUntil returns the time at which the lock on a controller
+// expires, from its set of policies; or false if it's not locked, or
+// has no expiry.
+func GetLockedUntil(policies Set) (time.Time, bool, error) {
+	if !policies.Contains(Locked) {
+		return time.Time{}, false, nil
+	}
+	value, ok := policies.Get(LockedUntil)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, errors.Wrap(ErrInvalidLockedUntil, value)
+	}
+	return until, true, nil
+}
+
+// LockExpired reports whether a controller has a lock that has
+// expired by the time given. A lock with an expiry that can't be
+// parsed is taken not to have expired, since it's safer to leave it
+// locked.
+func LockExpired(policies Set, now time.Time) bool {
+	until, ok, err := GetLockedUntil(policies)
+	return err == nil && ok && !now.Before(until)
+}
+
 type Updates map[flux.ResourceID]Update
 
 type Update struct {
 	Add    Set `json:"add"`
 	Remove Set `json:"remove"`
+	// InPolicyFile says to make the changes in the policy file,
+	// rather than in the annotations of the resource's manifest.
+	InPolicyFile bool `json:"inPolicyFile,omitempty"`
 }
 
 type Set map[Policy]string
@@ -138,6 +226,18 @@ func (s ResourceMap) Without(other ResourceMap) ResourceMap {
 	return newMap
 }
 
+// WithoutExpiredLocks gives the resources that don't have a lock
+// that's expired by the time given.
+func (s ResourceMap) WithoutExpiredLocks(now time.Time) ResourceMap {
+	newMap := ResourceMap{}
+	for k, v := range s {
+		if !LockExpired(v, now) {
+			newMap[k] = v
+		}
+	}
+	return newMap
+}
+
 func (s ResourceMap) OnlyWithPolicy(p Policy) ResourceMap {
 	newMap := ResourceMap{}
 	for k, v := range s {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestJSON(t *testing.T) {
@@ -45,3 +46,113 @@ func TestJSON(t *testing.T) {
 		t.Errorf("Parsing equivalent list did not preserve policy. Expected:\n%#v\nGot:\n%#v\n", policy, policy2)
 	}
 }
+
+func TestGetMinAge(t *testing.T) {
+	policies := Set{}.
+		Set(MinAgePrefix("web"), "30m").
+		Set(MinAgePrefix("sidecar"), "thirty minutes").
+		Set(MinAgePrefix("backwards"), "-1h")
+
+	if age, err := GetMinAge(policies, "web"); err != nil || age != 30*time.Minute {
+		t.Errorf("expected 30m, got %v (error %v)", age, err)
+	}
+	if age, err := GetMinAge(policies, "other"); err != nil || age != 0 {
+		t.Errorf("expected no minimum age, got %v (error %v)", age, err)
+	}
+	for _, container := range []string{"sidecar", "backwards"} {
+		if _, err := GetMinAge(policies, container); err == nil {
+			t.Errorf("%s: expected error", container)
+		}
+	}
+}
+
+func TestContainerLocked(t *testing.T) {
+	policies := Set{}.Add(LockedPrefix("sidecar"))
+
+	if !Boolean(LockedPrefix("sidecar")) {
+		t.Error("expected container lock to be a boolean policy")
+	}
+	if !IsContainerLocked(policies, "sidecar") {
+		t.Error("expected sidecar to be locked")
+	}
+	if IsContainerLocked(policies, "web") {
+		t.Error("expected web not to be locked")
+	}
+	if policies.Contains(Locked) {
+		t.Error("expected controller not to be locked")
+	}
+}
+
+func TestLockExpired(t *testing.T) {
+	now := time.Now()
+	for _, tst := range []struct {
+		name     string
+		policies Set
+		expired  bool
+	}{
+		{"not locked", Set{}.Set(LockedUntil, now.Add(-time.Hour).Format(time.RFC3339)), false},
+		{"no expiry", Set{}.Add(Locked), false},
+		{"expires later", Set{}.Add(Locked).Set(LockedUntil, now.Add(time.Hour).Format(time.RFC3339)), false},
+		{"expired", Set{}.Add(Locked).Set(LockedUntil, now.Add(-time.Hour).Format(time.RFC3339)), true},
+		{"unparseable", Set{}.Add(Locked).Set(LockedUntil, "yesterday"), false},
+	} {
+		if expired := LockExpired(tst.policies, now); expired != tst.expired {
+			t.Errorf("%s: expected expired to be %v, got %v", tst.name, tst.expired, expired)
+		}
+	}
+	if _, _, err := GetLockedUntil(Set{}.Add(Locked).Set(LockedUntil, "yesterday")); err == nil {
+		t.Error("expected error for unparseable expiry")
+	}
+}
+
+func TestInherit(t *testing.T) {
+	defaults := Set{}.
+		Add(Automated, Locked).
+		Set(TagPrefix("web"), "glob:1.*").
+		Set(Ignore, "false")
+	own := Set{}.
+		Set(PinDigest, "false").
+		Set(TagPrefix("web"), "semver:~2")
+
+	effective, sources := Inherit(own, defaults)
+	expected := Set{}.
+		Add(Automated).
+		Set(TagPrefix("web"), "semver:~2")
+	if !reflect.DeepEqual(expected, effective) {
+		t.Errorf("expected %v, got %v", expected, effective)
+	}
+	if sources[Automated] != SourceNamespace || sources[TagPrefix("web")] != SourceController {
+		t.Errorf("unexpected sources %v", sources)
+	}
+
+	// A boolean given as anything but "true" overrides the default
+	effective, _ = Inherit(Set{}.Set(Automated, "false"), defaults)
+	if effective.Contains(Automated) {
+		t.Errorf("expected automated to be overridden, got %v", effective)
+	}
+}
+
+func TestUpdateOverriding(t *testing.T) {
+	inherited := Set{}.
+		Add(Automated).
+		Set(TagPrefix("web"), "glob:1.*")
+	u := Update{
+		Add:    Set{}.Add(PinDigest),
+		Remove: Set{}.Add(Automated, Locked).Set(TagPrefix("web"), ""),
+	}
+
+	overriding := u.Overriding(inherited)
+	expected := Update{
+		Add: Set{}.
+			Add(PinDigest).
+			Set(Automated, "false").
+			Set(TagPrefix("web"), PatternAll.String()),
+		Remove: Set{}.Add(Locked),
+	}
+	if !reflect.DeepEqual(expected, overriding) {
+		t.Errorf("expected %+v, got %+v", expected, overriding)
+	}
+	if len(u.Add) != 1 || len(u.Remove) != 3 {
+		t.Errorf("expected the original update to be left as it was, got %+v", u)
+	}
+}
@@ -0,0 +1,231 @@
+// Package disk provides a cache.Client that keeps entries in files
+// in a directory, e.g., on a local volume. It's an alternative to
+// memcached for when running a separate cache service isn't wanted,
+// and it keeps its entries across restarts of fluxd so long as the
+// directory is kept.
+package disk
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux/registry/cache"
+)
+
+const (
+	DefaultExpiry = time.Hour
+	// DefaultGCInterval is how often expired entries are removed, if
+	// no interval is given.
+	DefaultGCInterval = 10 * time.Minute
+
+	// expiryLen is the length of the expiry time stored at the start
+	// of each entry.
+	expiryLen = 8
+)
+
+// Client is a cache client that keeps each entry in a file under a
+// directory, and periodically removes the entries that have expired.
+type Client struct {
+	dir    string
+	ttl    time.Duration
+	logger log.Logger
+
+	// mu is held while an entry is moved into place, and while an
+	// expired entry is checked and removed, so that a fresh entry
+	// isn't removed in its stead.
+	mu sync.Mutex
+
+	quit chan struct{}
+	wait sync.WaitGroup
+}
+
+// Config defines how a Client should be constructed.
+type Config struct {
+	Dir        string
+	Expiry     time.Duration
+	GCInterval time.Duration
+	Logger     log.Logger
+}
+
+// NewClient makes a Client keeping entries in the directory given,
+// creating the directory if it doesn't exist.
+func NewClient(config Config) (*Client, error) {
+	if config.Dir == "" {
+		return nil, errors.New("no directory given for the registry cache")
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "creating registry cache directory")
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+
+	c := &Client{
+		dir:    config.Dir,
+		ttl:    config.Expiry,
+		logger: config.Logger,
+		quit:   make(chan struct{}),
+	}
+	if c.ttl == 0 {
+		c.ttl = DefaultExpiry
+	}
+	interval := config.GCInterval
+	if interval == 0 {
+		interval = DefaultGCInterval
+	}
+
+	c.wait.Add(1)
+	go c.gcLoop(interval)
+	return c, nil
+}
+
+// Entries are stored the same way as they are in memcached: the
+// expiry time, then the value. Each goes in a file named for the hash
+// of its key, in a subdirectory named for the first two characters of
+// the hash so that no one directory gets too big.
+
+func (c *Client) path(k cache.Keyer) string {
+	sum := sha256.Sum256([]byte(k.Key()))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// GetKey gets the value and its expiry time from the cache. Entries
+// that have expired are reported as not cached; they are left for
+// the GC loop to remove.
+func (c *Client) GetKey(k cache.Keyer) ([]byte, time.Time, error) {
+	path := c.path(k)
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []byte{}, time.Time{}, cache.ErrNotCached
+		}
+		c.logger.Log("err", errors.Wrap(err, "reading from disk cache"))
+		return []byte{}, time.Time{}, err
+	}
+	if len(bytes) < expiryLen {
+		// Most likely left by a crash part way through writing;
+		// treat it as missing, and it'll be overwritten.
+		return []byte{}, time.Time{}, cache.ErrNotCached
+	}
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(bytes)), 0)
+	if !time.Now().Before(expiry) {
+		return []byte{}, time.Time{}, cache.ErrNotCached
+	}
+	return bytes[expiryLen:], expiry, nil
+}
+
+// SetKey sets the value at a key. The value is written to a temporary
+// file then renamed into place, so a reader never sees part of a
+// value.
+func (c *Client) SetKey(k cache.Keyer, v []byte) error {
+	if err := c.setKey(k, v); err != nil {
+		c.logger.Log("err", errors.Wrap(err, "storing in disk cache"))
+		return err
+	}
+	return nil
+}
+
+func (c *Client) setKey(k cache.Keyer, v []byte) error {
+	path := c.path(k)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	exBytes := make([]byte, expiryLen)
+	binary.BigEndian.PutUint64(exBytes, uint64(time.Now().Add(c.ttl).Unix()))
+	_, err = tmp.Write(append(exBytes, v...))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		c.mu.Lock()
+		err = os.Rename(tmp.Name(), path)
+		c.mu.Unlock()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Stop the disk cache client.
+func (c *Client) Stop() {
+	close(c.quit)
+	c.wait.Wait()
+}
+
+func (c *Client) gcLoop(interval time.Duration) {
+	defer c.wait.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.removeExpired(time.Now()); err != nil {
+				c.logger.Log("err", errors.Wrap(err, "removing expired entries from disk cache"))
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// removeExpired removes the entries that have expired as of the time
+// given, along with any temporary files left behind.
+func (c *Client) removeExpired(now time.Time) error {
+	return filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if filepath.Base(path)[0] == '.' {
+			// A temporary file; leave those still being written
+			if now.Sub(info.ModTime()) > time.Hour {
+				os.Remove(path)
+			}
+			return nil
+		}
+		return c.removeIfExpired(path, now)
+	})
+}
+
+// removeIfExpired removes the entry at the path given if it has
+// expired as of the time given. The entry is checked with the lock
+// held, so that one just written in its place is kept.
+func (c *Client) removeIfExpired(path string, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	exBytes := make([]byte, expiryLen)
+	_, err = io.ReadFull(f, exBytes)
+	f.Close()
+	if err != nil || !now.Before(time.Unix(int64(binary.BigEndian.Uint64(exBytes)), 0)) {
+		os.Remove(path)
+	}
+	return nil
+}
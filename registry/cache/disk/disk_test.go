@@ -0,0 +1,107 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux/registry/cache"
+)
+
+type testKey string
+
+func (t testKey) Key() string {
+	return string(t)
+}
+
+func setup(t *testing.T, expiry time.Duration) (*Client, func()) {
+	dir, err := ioutil.TempDir("", "flux-disk-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(Config{Dir: dir, Expiry: expiry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDisk_ReadWrite(t *testing.T) {
+	c, cleanup := setup(t, time.Hour)
+	defer cleanup()
+
+	if _, _, err := c.GetKey(testKey("missing")); err != cache.ErrNotCached {
+		t.Fatalf("expected ErrNotCached for missing key, got %v", err)
+	}
+
+	val := []byte("test bytes")
+	if err := c.SetKey(testKey("test"), val); err != nil {
+		t.Fatal(err)
+	}
+	got, expiry, err := c.GetKey(testKey("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(val) {
+		t.Fatalf("expected %q, got %q", val, got)
+	}
+	if expiry.Before(time.Now().Add(59*time.Minute)) || expiry.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("expected expiry about an hour from now, got %s", expiry)
+	}
+
+	// Setting again overwrites
+	if err := c.SetKey(testKey("test"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ = c.GetKey(testKey("test")); string(got) != "new" {
+		t.Fatalf("expected %q, got %q", "new", got)
+	}
+}
+
+func TestDisk_Expiry(t *testing.T) {
+	c, cleanup := setup(t, -time.Second)
+	defer cleanup()
+
+	if err := c.SetKey(testKey("test"), []byte("expired")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.GetKey(testKey("test")); err != cache.ErrNotCached {
+		t.Fatalf("expected ErrNotCached for expired entry, got %v", err)
+	}
+	// Reading an expired entry leaves it for the GC loop, so it
+	// can't race with a fresh entry being written in its place.
+	if _, err := os.Stat(c.path(testKey("test"))); err != nil {
+		t.Fatalf("expected expired entry to be left in place, got %v", err)
+	}
+	if err := c.removeExpired(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.path(testKey("test"))); !os.IsNotExist(err) {
+		t.Fatalf("expected expired entry to be removed, got %v", err)
+	}
+}
+
+func TestDisk_RemoveExpired(t *testing.T) {
+	c, cleanup := setup(t, time.Hour)
+	defer cleanup()
+
+	if err := c.SetKey(testKey("test"), []byte("test bytes")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.removeExpired(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.GetKey(testKey("test")); err != nil {
+		t.Fatalf("expected entry to be kept before it expires, got %v", err)
+	}
+	if err := c.removeExpired(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.path(testKey("test"))); !os.IsNotExist(err) {
+		t.Fatalf("expected expired entry to be removed, got %v", err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/weaveworks/flux/image"
 	"github.com/weaveworks/flux/registry"
+	"github.com/weaveworks/flux/registry/middleware"
 )
 
 const refreshWhenExpiryWithin = time.Minute
@@ -212,6 +213,11 @@ func (w *Warmer) warm(ctx context.Context, logger log.Logger, id image.Name, cre
 						// This was due to a context timeout, don't bother logging
 						return
 					}
+					if middleware.IsBackoff(err) {
+						// The registry asked us to back off; this will be
+						// tried again next time around, so don't log each tag
+						return
+					}
 					logger.Log("err", errors.Wrap(err, "requesting manifests"))
 					return
 				}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"reflect"
 	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
 	"github.com/docker/distribution/manifest/schema1"
 	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/registry/client"
@@ -37,6 +39,14 @@ type ClientFactory interface {
 type Remote struct {
 	transport http.RoundTripper
 	repo      image.CanonicalName
+	// platform is used to choose from the images in a manifest list
+	platform Platform
+	// scheme is the URL scheme to use for the registry; if empty,
+	// it's https
+	scheme string
+	// host is the host to get metadata from, if not the repo's
+	// registry (e.g., a mirror of it)
+	host string
 }
 
 // Adapt to docker distribution `reference.Named`.
@@ -52,9 +62,21 @@ func (n named) Name() string {
 	return n.Image
 }
 
+func (a *Remote) baseURL() string {
+	scheme := a.scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	host := a.host
+	if host == "" {
+		host = a.repo.Domain
+	}
+	return scheme + "://" + host
+}
+
 // Return the tags for this repository.
 func (a *Remote) Tags(ctx context.Context) ([]string, error) {
-	repository, err := client.NewRepository(named{a.repo}, "https://"+a.repo.Domain, a.transport)
+	repository, err := client.NewRepository(named{a.repo}, a.baseURL(), a.transport)
 	if err != nil {
 		return nil, err
 	}
@@ -64,7 +86,7 @@ func (a *Remote) Tags(ctx context.Context) ([]string, error) {
 // Manifest fetches the metadata for an image reference; currently
 // assumed to be in the same repo as that provided to `NewRemote(...)`
 func (a *Remote) Manifest(ctx context.Context, ref string) (image.Info, error) {
-	repository, err := client.NewRepository(named{a.repo}, "https://"+a.repo.Domain, a.transport)
+	repository, err := client.NewRepository(named{a.repo}, a.baseURL(), a.transport)
 	if err != nil {
 		return image.Info{}, err
 	}
@@ -74,15 +96,16 @@ func (a *Remote) Manifest(ctx context.Context, ref string) (image.Info, error) {
 	}
 	var manifestDigest digest.Digest
 	digestOpt := client.ReturnContentDigest(&manifestDigest)
-	manifest, fetchErr := manifests.Get(ctx, digest.Digest(ref), digestOpt, distribution.WithTagOption{ref})
-
-interpret:
-	if fetchErr != nil {
+	manifest, err := manifests.Get(ctx, digest.Digest(ref), digestOpt, distribution.WithTagOption{ref})
+	if err != nil {
 		return image.Info{}, err
 	}
 
+	// The digest is that of the manifest the reference points at; for
+	// a manifest list, that's the list, so it's good for any platform.
 	info := image.Info{ID: a.repo.ToRef(ref), Digest: manifestDigest.String()}
 
+interpret:
 	// TODO(michael): can we type switch? Not sure how dependable the
 	// underlying types are.
 	switch deserialised := manifest.(type) {
@@ -105,35 +128,78 @@ interpret:
 		info.CreatedAt = v1.Created
 	case *schema2.DeserializedManifest:
 		var man schema2.Manifest = deserialised.Manifest
-		configBytes, err := repository.Blobs(ctx).Get(ctx, man.Config.Digest)
-		if err != nil {
+		if err = configInfo(ctx, repository, man.Config.Digest, &info); err != nil {
 			return image.Info{}, err
 		}
-
-		var config struct {
-			Arch    string    `json:"architecture"`
-			Created time.Time `json:"created"`
-			OS      string    `json:"os"`
-		}
-		if err = json.Unmarshal(configBytes, &config); err != nil {
+	case *ocischema.DeserializedManifest:
+		var man ocischema.Manifest = deserialised.Manifest
+		if err = configInfo(ctx, repository, man.Config.Digest, &info); err != nil {
 			return image.Info{}, err
 		}
-		// This _is_ what Docker uses as its Image ID.
-		info.ImageID = man.Config.Digest.String()
-		info.CreatedAt = config.Created
 	case *manifestlist.DeserializedManifestList:
+		// This is either a Docker manifest list or an OCI image index
+		if info.Platforms != nil {
+			return image.Info{}, errors.New("manifest list refers to another manifest list")
+		}
 		var list manifestlist.ManifestList = deserialised.ManifestList
-		// TODO(michael): is it valid to just pick the first one that matches?
-		for _, m := range list.Manifests {
-			if m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
-				manifest, fetchErr = manifests.Get(ctx, m.Digest, digestOpt)
-				goto interpret
+		// Record the image for each platform, and pick the first
+		// for the platform we're looking at.
+		info.Platforms = map[string]string{}
+		var chosen *manifestlist.ManifestDescriptor
+		for i, m := range list.Manifests {
+			info.Platforms[platformOf(m.Platform).String()] = m.Digest.String()
+			if chosen == nil && a.platform.matches(m.Platform) {
+				chosen = &list.Manifests[i]
 			}
 		}
-		return image.Info{}, errors.New("no suitable manifest (linux amd64) in manifestlist")
+		if chosen == nil {
+			return image.Info{}, fmt.Errorf("no suitable manifest (%s) in manifestlist", a.platform)
+		}
+		manifest, err = manifests.Get(ctx, chosen.Digest)
+		if err != nil {
+			return image.Info{}, err
+		}
+		goto interpret
 	default:
 		t := reflect.TypeOf(manifest)
 		return image.Info{}, errors.New("unknown manifest type: " + t.String())
 	}
 	return info, nil
 }
+
+// OCI images may give the time they were created as a label, rather
+// than in the config itself.
+const createdLabel = "org.opencontainers.image.created"
+
+// configInfo fills in the image info from the config blob of a
+// schema2 or OCI image, which have the same fields of interest.
+func configInfo(ctx context.Context, repository distribution.Repository, dgst digest.Digest, info *image.Info) error {
+	configBytes, err := repository.Blobs(ctx).Get(ctx, dgst)
+	if err != nil {
+		return err
+	}
+
+	var config struct {
+		Arch    string    `json:"architecture"`
+		Created time.Time `json:"created"`
+		OS      string    `json:"os"`
+		Config  struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err = json.Unmarshal(configBytes, &config); err != nil {
+		return err
+	}
+	// This _is_ what Docker uses as its Image ID.
+	info.ImageID = dgst.String()
+	info.CreatedAt = config.Created
+	if info.CreatedAt.IsZero() {
+		if created, err := time.Parse(time.RFC3339, config.Config.Labels[createdLabel]); err == nil {
+			info.CreatedAt = created
+		}
+	}
+	if len(config.Config.Labels) > 0 {
+		info.Labels = config.Config.Labels
+	}
+	return nil
+}
@@ -15,9 +15,24 @@ import (
 )
 
 type RemoteClientFactory struct {
-	Logger           log.Logger
-	Limiters         *middleware.RateLimiters
-	Trace            bool
+	Logger   log.Logger
+	Limiters *middleware.RateLimiters
+	Trace    bool
+	// CredentialHelpers, if given, are used for hosts there are no
+	// other credentials for.
+	CredentialHelpers *CredentialHelpers
+	// ECR, if given, is used to get tokens for AWS ECR hosts.
+	ECR *ECRTokens
+	// Platform is used to choose from the images in a manifest list;
+	// if not given, it's DefaultPlatform.
+	Platform Platform
+	// Hosts gives the configuration for connecting to particular
+	// registry hosts, e.g., those with a private CA.
+	Hosts HostConfigs
+	// Mirrors gives mirrors to look up image metadata in, before the
+	// registry itself.
+	Mirrors          Mirrors
+	transports       map[string]http.RoundTripper
 	challengeManager challenge.Manager
 	mx               sync.Mutex
 }
@@ -38,7 +53,50 @@ func (t *logging) RoundTrip(req *http.Request) (*http.Response, error) {
 }
 
 func (f *RemoteClientFactory) ClientFor(repo image.CanonicalName, creds Credentials) (Client, error) {
-	tx := f.Limiters.RoundTripper(http.DefaultTransport, repo.Domain)
+	mirrors := f.Mirrors[repo.Domain]
+	if len(mirrors) == 0 {
+		origin, err := f.remoteFor(repo, repo.Domain, creds)
+		if err != nil {
+			return nil, err
+		}
+		return NewInstrumentedClient(origin), nil
+	}
+
+	// Mirrors are looked in first, and the registry itself last. A
+	// mirror that can't be reached is skipped; the registry itself
+	// isn't contacted until it's needed, so that it being unreachable
+	// doesn't stop the mirrors being used.
+	client := &mirroredClient{}
+	if f.Trace {
+		client.logger = f.Logger
+	}
+	for _, mirror := range mirrors {
+		remote, err := f.remoteFor(repo, mirror, creds)
+		if err != nil {
+			f.Logger.Log("mirror", mirror, "err", err)
+			continue
+		}
+		client.clients = append(client.clients, remote)
+		client.hosts = append(client.hosts, mirror)
+	}
+	origin := &lazyClient{new: func() (Client, error) {
+		return f.remoteFor(repo, repo.Domain, creds)
+	}}
+	client.clients = append(client.clients, origin)
+	client.hosts = append(client.hosts, repo.Domain)
+	return NewInstrumentedClient(client), nil
+}
+
+// remoteFor makes a client for the repo, which gets image metadata
+// from the host given; that's the repo's own registry, or a mirror
+// of it. The image info it gives is always for the canonical name.
+func (f *RemoteClientFactory) remoteFor(repo image.CanonicalName, host string, creds Credentials) (*Remote, error) {
+	hostConfig := f.Hosts[host]
+	base, err := f.transportFor(host, hostConfig)
+	if err != nil {
+		return nil, err
+	}
+	tx := f.Limiters.RoundTripper(base, host)
 	if f.Trace {
 		tx = &logging{f.Logger, tx}
 	}
@@ -51,8 +109,8 @@ func (f *RemoteClientFactory) ClientFor(repo image.CanonicalName, creds Credenti
 	manager := f.challengeManager
 
 	pingURL := url.URL{
-		Scheme: "https",
-		Host:   repo.Domain,
+		Scheme: hostConfig.scheme(),
+		Host:   host,
 		Path:   "/v2/",
 	}
 	// Before we know how to authorise, need to establish which
@@ -75,11 +133,35 @@ func (f *RemoteClientFactory) ClientFor(repo image.CanonicalName, creds Credenti
 		}
 	}
 
+	creds.helpers = f.CredentialHelpers
+	creds.ecr = f.ECR
 	handler := auth.NewTokenHandler(tx, &store{creds}, repo.Image, "pull")
 	tx = transport.NewTransport(tx, auth.NewAuthorizer(manager, handler))
 
-	client := &Remote{transport: tx, repo: repo}
-	return NewInstrumentedClient(client), nil
+	platform := f.Platform
+	if platform == (Platform{}) {
+		platform = DefaultPlatform
+	}
+	return &Remote{transport: tx, repo: repo, platform: platform, scheme: hostConfig.scheme(), host: host}, nil
+}
+
+// transportFor gives the transport to use for a registry host, making
+// it the first time it's needed so that connections are reused.
+func (f *RemoteClientFactory) transportFor(host string, config HostConfig) (http.RoundTripper, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if tx, ok := f.transports[host]; ok {
+		return tx, nil
+	}
+	tx, err := config.transport()
+	if err != nil {
+		return nil, err
+	}
+	if f.transports == nil {
+		f.transports = map[string]http.RoundTripper{}
+	}
+	f.transports[host] = tx
+	return tx, nil
 }
 
 // credentialStore adapts our Credentials type to be an
@@ -0,0 +1,356 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/weaveworks/flux/image"
+)
+
+// fakeRegistry serves manifests and blobs for a single repository,
+// enough for a Remote to fetch image metadata from it.
+type fakeRegistry struct {
+	repo      string
+	manifests map[string]fakeContent // by tag or digest
+	blobs     map[string]fakeContent // by digest
+}
+
+type fakeContent struct {
+	mediaType string
+	body      []byte
+}
+
+func newFakeRegistry(repo string) *fakeRegistry {
+	return &fakeRegistry{
+		repo:      repo,
+		manifests: map[string]fakeContent{},
+		blobs:     map[string]fakeContent{},
+	}
+}
+
+// addManifest adds a manifest under its digest and any tags given,
+// and returns the digest.
+func (f *fakeRegistry) addManifest(mediaType string, manifest interface{}, tags ...string) digest.Digest {
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		panic(err)
+	}
+	dgst := digest.FromBytes(body)
+	content := fakeContent{mediaType: mediaType, body: body}
+	f.manifests[dgst.String()] = content
+	for _, tag := range tags {
+		f.manifests[tag] = content
+	}
+	return dgst
+}
+
+func (f *fakeRegistry) addBlob(mediaType string, blob interface{}) (digest.Digest, int64) {
+	body, err := json.Marshal(blob)
+	if err != nil {
+		panic(err)
+	}
+	dgst := digest.FromBytes(body)
+	f.blobs[dgst.String()] = fakeContent{mediaType: mediaType, body: body}
+	return dgst, int64(len(body))
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := "/v2/" + f.repo + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	var content fakeContent
+	var ok bool
+	switch parts[0] {
+	case "tags":
+		if parts[1] != "list" {
+			break
+		}
+		var tags []string
+		for ref := range f.manifests {
+			if _, err := digest.Parse(ref); err != nil {
+				tags = append(tags, ref)
+			}
+		}
+		body, _ := json.Marshal(map[string]interface{}{"name": f.repo, "tags": tags})
+		content, ok = fakeContent{mediaType: "application/json", body: body}, true
+	case "manifests":
+		content, ok = f.manifests[parts[1]]
+	case "blobs":
+		content, ok = f.blobs[parts[1]]
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", content.mediaType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(content.body)))
+	w.Header().Set("Docker-Content-Digest", digest.FromBytes(content.body).String())
+	if r.Method != "HEAD" {
+		w.Write(content.body)
+	}
+}
+
+// remoteFor makes a Remote for the repository served by the fake
+// registry.
+func (f *fakeRegistry) remoteFor(t *testing.T, platform Platform) (*Remote, func()) {
+	server := httptest.NewTLSServer(f)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := image.CanonicalName{Name: image.Name{Domain: u.Host, Image: f.repo}}
+	return &Remote{transport: server.Client().Transport, repo: repo, platform: platform}, server.Close
+}
+
+// addImage adds a schema2 image created at the time given, and
+// returns the digests of its manifest and config.
+func (f *fakeRegistry) addImage(created time.Time, platform Platform, tags ...string) (digest.Digest, digest.Digest) {
+	configDigest, configSize := f.addBlob(schema2.MediaTypeImageConfig, map[string]interface{}{
+		"created":      created,
+		"os":           platform.OS,
+		"architecture": platform.Architecture,
+	})
+	manifestDigest := f.addManifest(schema2.MediaTypeManifest, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     schema2.MediaTypeManifest,
+		"config": map[string]interface{}{
+			"mediaType": schema2.MediaTypeImageConfig,
+			"digest":    configDigest,
+			"size":      configSize,
+		},
+		"layers": []interface{}{},
+	}, tags...)
+	return manifestDigest, configDigest
+}
+
+func TestRemote_ManifestList(t *testing.T) {
+	reg := newFakeRegistry("weaveworks/helloworld")
+	amd64Time := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	arm64Time := time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)
+	arm64 := Platform{OS: "linux", Architecture: "arm64"}
+	amd64Digest, _ := reg.addImage(amd64Time, DefaultPlatform)
+	arm64Digest, arm64Config := reg.addImage(arm64Time, arm64)
+	listDigest := reg.addManifest(manifestlist.MediaTypeManifestList, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     manifestlist.MediaTypeManifestList,
+		"manifests": []interface{}{
+			map[string]interface{}{
+				"mediaType": schema2.MediaTypeManifest,
+				"digest":    amd64Digest,
+				"size":      len(reg.manifests[amd64Digest.String()].body),
+				"platform":  map[string]string{"os": "linux", "architecture": "amd64"},
+			},
+			map[string]interface{}{
+				"mediaType": schema2.MediaTypeManifest,
+				"digest":    arm64Digest,
+				"size":      len(reg.manifests[arm64Digest.String()].body),
+				"platform":  map[string]string{"os": "linux", "architecture": "arm64", "variant": "v8"},
+			},
+		},
+	}, "multi")
+
+	expectedPlatforms := map[string]string{
+		"linux/amd64":    amd64Digest.String(),
+		"linux/arm64/v8": arm64Digest.String(),
+	}
+
+	for _, v := range []struct {
+		platform Platform
+		created  time.Time
+	}{
+		{DefaultPlatform, amd64Time},
+		{arm64, arm64Time},
+		{Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, arm64Time},
+	} {
+		remote, done := reg.remoteFor(t, v.platform)
+		info, err := remote.Manifest(context.Background(), "multi")
+		done()
+		if err != nil {
+			t.Fatalf("%s: %v", v.platform, err)
+		}
+		if !info.CreatedAt.Equal(v.created) {
+			t.Errorf("%s: expected created time %s, got %s", v.platform, v.created, info.CreatedAt)
+		}
+		if info.Digest != listDigest.String() {
+			t.Errorf("%s: expected digest of manifest list %s, got %s", v.platform, listDigest, info.Digest)
+		}
+		if len(info.Platforms) != len(expectedPlatforms) {
+			t.Errorf("%s: expected platforms %v, got %v", v.platform, expectedPlatforms, info.Platforms)
+		}
+		for p, d := range expectedPlatforms {
+			if info.Platforms[p] != d {
+				t.Errorf("%s: expected digest %s for %s, got %s", v.platform, d, p, info.Platforms[p])
+			}
+		}
+		if v.platform.Architecture == "arm64" && info.ImageID != arm64Config.String() {
+			t.Errorf("%s: expected image ID %s, got %s", v.platform, arm64Config, info.ImageID)
+		}
+	}
+
+	remote, done := reg.remoteFor(t, Platform{OS: "windows", Architecture: "amd64"})
+	defer done()
+	if _, err := remote.Manifest(context.Background(), "multi"); err == nil {
+		t.Error("expected error for platform not in manifest list")
+	}
+}
+
+func TestRemote_Manifest(t *testing.T) {
+	reg := newFakeRegistry("weaveworks/helloworld")
+	created := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	manifestDigest, configDigest := reg.addImage(created, DefaultPlatform, "single")
+
+	remote, done := reg.remoteFor(t, DefaultPlatform)
+	defer done()
+	info, err := remote.Manifest(context.Background(), "single")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.CreatedAt.Equal(created) || info.Digest != manifestDigest.String() || info.ImageID != configDigest.String() {
+		t.Errorf("unexpected image info %+v", info)
+	}
+	if info.Platforms != nil {
+		t.Errorf("expected no platforms for an image without a manifest list, got %v", info.Platforms)
+	}
+
+	if _, err := remote.Manifest(context.Background(), "missing"); err == nil {
+		t.Error("expected error for missing tag")
+	}
+}
+
+const (
+	ociManifest = "application/vnd.oci.image.manifest.v1+json"
+	ociConfig   = "application/vnd.oci.image.config.v1+json"
+	ociIndex    = "application/vnd.oci.image.index.v1+json"
+)
+
+// addOCIImage adds an OCI image with the config given, and returns
+// the digests of its manifest and config.
+func (f *fakeRegistry) addOCIImage(config map[string]interface{}, tags ...string) (digest.Digest, digest.Digest) {
+	configDigest, configSize := f.addBlob(ociConfig, config)
+	manifestDigest := f.addManifest(ociManifest, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifest,
+		"config": map[string]interface{}{
+			"mediaType": ociConfig,
+			"digest":    configDigest,
+			"size":      configSize,
+		},
+		"layers": []interface{}{},
+	}, tags...)
+	return manifestDigest, configDigest
+}
+
+func TestRemote_OCI(t *testing.T) {
+	reg := newFakeRegistry("weaveworks/helloworld")
+	created := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	labelled := time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC)
+	labels := map[string]string{
+		"org.opencontainers.image.created":  labelled.Format(time.RFC3339),
+		"org.opencontainers.image.revision": "a000001",
+	}
+
+	// An image with the created time in its config
+	manifestDigest, configDigest := reg.addOCIImage(map[string]interface{}{
+		"created":      created,
+		"os":           "linux",
+		"architecture": "amd64",
+		"config":       map[string]interface{}{"Labels": labels},
+	}, "oci")
+	// An image with the created time only as a label
+	labelledDigest, _ := reg.addOCIImage(map[string]interface{}{
+		"os":           "linux",
+		"architecture": "arm64",
+		"config":       map[string]interface{}{"Labels": labels},
+	}, "oci-labelled")
+	// An index of both
+	indexDigest := reg.addManifest(ociIndex, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociIndex,
+		"manifests": []interface{}{
+			map[string]interface{}{
+				"mediaType": ociManifest,
+				"digest":    manifestDigest,
+				"size":      len(reg.manifests[manifestDigest.String()].body),
+				"platform":  map[string]string{"os": "linux", "architecture": "amd64"},
+			},
+			map[string]interface{}{
+				"mediaType": ociManifest,
+				"digest":    labelledDigest,
+				"size":      len(reg.manifests[labelledDigest.String()].body),
+				"platform":  map[string]string{"os": "linux", "architecture": "arm64"},
+			},
+		},
+	}, "oci-index")
+
+	for _, v := range []struct {
+		ref      string
+		platform Platform
+		digest   digest.Digest
+		created  time.Time
+	}{
+		{"oci", DefaultPlatform, manifestDigest, created},
+		{"oci-labelled", DefaultPlatform, labelledDigest, labelled},
+		{"oci-index", DefaultPlatform, indexDigest, created},
+		{"oci-index", Platform{OS: "linux", Architecture: "arm64"}, indexDigest, labelled},
+	} {
+		remote, done := reg.remoteFor(t, v.platform)
+		info, err := remote.Manifest(context.Background(), v.ref)
+		done()
+		if err != nil {
+			t.Fatalf("%s (%s): %v", v.ref, v.platform, err)
+		}
+		if info.Digest != v.digest.String() {
+			t.Errorf("%s (%s): expected digest %s, got %s", v.ref, v.platform, v.digest, info.Digest)
+		}
+		if !info.CreatedAt.Equal(v.created) {
+			t.Errorf("%s (%s): expected created time %s, got %s", v.ref, v.platform, v.created, info.CreatedAt)
+		}
+		if len(info.Labels) != len(labels) || info.Labels["org.opencontainers.image.revision"] != "a000001" {
+			t.Errorf("%s (%s): expected labels %v, got %v", v.ref, v.platform, labels, info.Labels)
+		}
+		if v.ref == "oci" && info.ImageID != configDigest.String() {
+			t.Errorf("%s: expected image ID %s, got %s", v.ref, configDigest, info.ImageID)
+		}
+		if v.ref == "oci-index" && len(info.Platforms) != 2 {
+			t.Errorf("%s: expected two platforms, got %v", v.ref, info.Platforms)
+		}
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	for s, expected := range map[string]Platform{
+		"linux/amd64":  DefaultPlatform,
+		"linux/arm/v7": {OS: "linux", Architecture: "arm", Variant: "v7"},
+	} {
+		p, err := ParsePlatform(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+		if p != expected || p.String() != s {
+			t.Errorf("%s: expected %+v, got %+v", s, expected, p)
+		}
+	}
+	for _, s := range []string{"linux", "linux/", "/amd64", "linux/arm/v7/extra"} {
+		if _, err := ParsePlatform(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
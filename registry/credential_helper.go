@@ -0,0 +1,244 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// Docker credential helpers are programs named
+// `docker-credential-<helper>`, which answer requests given as the
+// first argument. For `get`, the server URL is given on stdin, and
+// the credentials are written to stdout as JSON; for `list`, the
+// server URLs the helper has credentials for are written to stdout,
+// as a JSON map of server URL to username. See
+// https://github.com/docker/docker-credential-helpers.
+
+const (
+	credentialHelperPrefix = "docker-credential-"
+	// This is what the helpers print when asked for credentials they
+	// don't have.
+	credentialsNotFound = "credentials not found in native keychain"
+	// The credentials found (or not) for a host are kept this long,
+	// so that the helpers aren't run for every request.
+	credentialHelperCacheTTL = time.Minute
+)
+
+// A helper that takes longer than this is killed, so that looking for
+// images doesn't wait on it forever.
+var credentialHelperTimeout = 10 * time.Second
+
+type credentialHelperEntry struct {
+	// host is the host, or a glob pattern matching hosts, for which
+	// the helper gives credentials. If empty, the helper is asked
+	// which hosts it has credentials for.
+	host    string
+	program string
+}
+
+// CredentialHelpers gives credentials for registry hosts by running
+// Docker credential helpers. This lets credentials come from, e.g.,
+// the cloud provider's helper, without needing image pull secrets.
+type CredentialHelpers struct {
+	Logger  log.Logger
+	entries []credentialHelperEntry
+
+	mx    sync.Mutex
+	cache map[string]*helperCreds
+}
+
+type helperCreds struct {
+	// mx is held while the helpers are run for the host, so that
+	// requests for the same host wait for the result, but those for
+	// other hosts don't.
+	mx      sync.Mutex
+	creds   creds
+	found   bool
+	expires time.Time
+}
+
+// ParseCredentialHelpers makes CredentialHelpers from specs of the
+// form `<host>=<helper>`, or just `<helper>` to use the helper for
+// any host it lists credentials for. The host may be a glob pattern,
+// e.g., `*.dkr.ecr.us-east-1.amazonaws.com`. The helper is either
+// the suffix of a program `docker-credential-<helper>` on the PATH,
+// or a path to a program.
+func ParseCredentialHelpers(specs []string) (*CredentialHelpers, error) {
+	helpers := &CredentialHelpers{}
+	for _, spec := range specs {
+		var host, helper string
+		if i := strings.Index(spec, "="); i >= 0 {
+			host, helper = spec[:i], spec[i+1:]
+			if host == "" {
+				return nil, fmt.Errorf("credential helper %q: no host given before '='", spec)
+			}
+			if _, err := path.Match(host, ""); err != nil {
+				return nil, errors.Wrapf(err, "credential helper %q", spec)
+			}
+		} else {
+			helper = spec
+		}
+		if helper == "" {
+			return nil, fmt.Errorf("credential helper %q: no helper given", spec)
+		}
+		program := helper
+		if !strings.Contains(helper, "/") {
+			program = credentialHelperPrefix + helper
+		}
+		helpers.entries = append(helpers.entries, credentialHelperEntry{host: host, program: program})
+	}
+	return helpers, nil
+}
+
+// credsFor gives the credentials for the host from the first helper
+// that has them, and reports whether any did. The result is kept for
+// a while, so the helpers are run at most once in that time for each
+// host.
+func (h *CredentialHelpers) credsFor(host string) (creds, bool) {
+	if h == nil {
+		return creds{}, false
+	}
+
+	h.mx.Lock()
+	if h.cache == nil {
+		h.cache = map[string]*helperCreds{}
+	}
+	cached, ok := h.cache[host]
+	if !ok {
+		cached = &helperCreds{}
+		h.cache[host] = cached
+	}
+	h.mx.Unlock()
+
+	cached.mx.Lock()
+	defer cached.mx.Unlock()
+	now := time.Now()
+	if now.Before(cached.expires) {
+		return cached.creds, cached.found
+	}
+	cached.creds, cached.found = h.runHelpers(host)
+	cached.expires = now.Add(credentialHelperCacheTTL)
+	return cached.creds, cached.found
+}
+
+// runHelpers runs the first helper that has credentials for the host
+// given, and reports whether any did.
+func (h *CredentialHelpers) runHelpers(host string) (creds, bool) {
+	for _, entry := range h.entries {
+		serverURL := host
+		if entry.host == "" {
+			listed, err := listCredentialHelper(entry.program)
+			if err != nil {
+				h.log("helper", entry.program, "err", err)
+				continue
+			}
+			var found bool
+			for server := range listed {
+				if hostOf(server) == host {
+					serverURL, found = server, true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		} else if ok, _ := path.Match(entry.host, host); !ok {
+			continue
+		}
+
+		cred, err := getCredentialHelper(entry.program, serverURL)
+		if err != nil {
+			h.log("helper", entry.program, "host", host, "err", err)
+			continue
+		}
+		if cred != (creds{}) {
+			return cred, true
+		}
+	}
+	return creds{}, false
+}
+
+func (h *CredentialHelpers) log(keyvals ...interface{}) {
+	if h.Logger != nil {
+		h.Logger.Log(keyvals...)
+	}
+}
+
+// getCredentialHelper asks a helper for the credentials for a server
+// URL. If the helper has none, it returns empty credentials rather
+// than an error.
+func getCredentialHelper(program, serverURL string) (creds, error) {
+	out, err := runCredentialHelper(program, "get", serverURL)
+	if err != nil {
+		if strings.Contains(string(out), credentialsNotFound) {
+			return creds{}, nil
+		}
+		return creds{}, err
+	}
+	var result struct {
+		ServerURL string
+		Username  string
+		Secret    string
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return creds{}, errors.Wrap(err, "parsing output of credential helper")
+	}
+	return creds{username: result.Username, password: result.Secret}, nil
+}
+
+// listCredentialHelper asks a helper for the server URLs it has
+// credentials for, with the username for each.
+func listCredentialHelper(program string) (map[string]string, error) {
+	out, err := runCredentialHelper(program, "list", "")
+	if err != nil {
+		return nil, err
+	}
+	listed := map[string]string{}
+	if err := json.Unmarshal(out, &listed); err != nil {
+		return nil, errors.Wrap(err, "parsing output of credential helper")
+	}
+	return listed, nil
+}
+
+func runCredentialHelper(program, command, input string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, program, command)
+	cmd.Stdin = strings.NewReader(input)
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.Errorf("running %s %s: timed out after %s", program, command, credentialHelperTimeout)
+		}
+		out := bytes.TrimSpace(stdout.Bytes())
+		if len(out) > 0 {
+			return out, errors.Wrapf(err, "running %s %s: %s", program, command, out)
+		}
+		return out, errors.Wrapf(err, "running %s %s", program, command)
+	}
+	return stdout.Bytes(), nil
+}
+
+// hostOf gives the host from a server URL as used by credential
+// helpers, which may or may not have a scheme or path.
+func hostOf(serverURL string) string {
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "https://" + serverURL
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,177 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A fake credential helper, which has credentials for one host.
+const fakeCredentialHelper = `#!/bin/sh
+case "$1" in
+get)
+  read server
+  case "$server" in
+  registry.example.com|https://registry.example.com)
+    echo '{"ServerURL":"https://registry.example.com","Username":"helperuser","Secret":"helpersecret"}'
+    ;;
+  *)
+    echo "credentials not found in native keychain"
+    exit 1
+    ;;
+  esac
+  ;;
+list)
+  echo '{"https://registry.example.com":"helperuser"}'
+  ;;
+*)
+  exit 1
+  ;;
+esac
+`
+
+func setupCredentialHelper(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "flux-credential-helper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	program := filepath.Join(dir, "docker-credential-fake")
+	if err := ioutil.WriteFile(program, []byte(fakeCredentialHelper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return program, func() { os.RemoveAll(dir) }
+}
+
+func TestCredentialHelpers(t *testing.T) {
+	program, cleanup := setupCredentialHelper(t)
+	defer cleanup()
+
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", filepath.Dir(program)+string(os.PathListSeparator)+oldPath)
+	defer os.Setenv("PATH", oldPath)
+
+	for _, v := range []struct {
+		name  string
+		specs []string
+		host  string
+		found bool
+	}{
+		{"listed host", []string{"fake"}, "registry.example.com", true},
+		{"unlisted host", []string{"fake"}, "other.example.com", false},
+		{"unmapped host", []string{"other.example.com=fake"}, "registry.example.com", false},
+		{"helper without creds", []string{"other.example.com=fake"}, "other.example.com", false},
+		{"mapped host", []string{"registry.example.com=fake"}, "registry.example.com", true},
+		{"path to helper", []string{"*.example.com=" + program}, "registry.example.com", true},
+		{"missing helper", []string{"nonexistent", "fake"}, "registry.example.com", true},
+	} {
+		helpers, err := ParseCredentialHelpers(v.specs)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		cred, found := helpers.credsFor(v.host)
+		if found != v.found {
+			t.Errorf("%s: expected found to be %v, got %v", v.name, v.found, found)
+			continue
+		}
+		if found && (cred.username != "helperuser" || cred.password != "helpersecret") {
+			t.Errorf("%s: unexpected credentials %+v", v.name, cred)
+		}
+	}
+}
+
+func TestCredentialHelpers_Precedence(t *testing.T) {
+	program, cleanup := setupCredentialHelper(t)
+	defer cleanup()
+
+	helpers, err := ParseCredentialHelpers([]string{"registry.example.com=" + program})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Credentials from image pull secrets come first
+	creds, err := ParseCredentials([]byte(`{"registry.example.com": {"auth": "` + okCreds + `"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds.helpers = helpers
+	if c := creds.credsFor("registry.example.com"); c.username != user {
+		t.Errorf("expected credentials from pull secret, got %+v", c)
+	}
+
+	creds = NoCredentials()
+	creds.helpers = helpers
+	if c := creds.credsFor("registry.example.com"); c.username != "helperuser" {
+		t.Errorf("expected credentials from helper, got %+v", c)
+	}
+}
+
+func TestCredentialHelpers_Cached(t *testing.T) {
+	program, cleanup := setupCredentialHelper(t)
+	defer cleanup()
+	// Wrap the helper so that each run is recorded
+	runs := filepath.Join(filepath.Dir(program), "runs")
+	counting := filepath.Join(filepath.Dir(program), "counting")
+	script := "#!/bin/sh\necho \"$1\" >> " + runs + "\nexec " + program + " \"$@\"\n"
+	if err := ioutil.WriteFile(counting, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	helpers, err := ParseCredentialHelpers([]string{counting})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if c, found := helpers.credsFor("registry.example.com"); !found || c.username != "helperuser" {
+			t.Fatalf("expected credentials from helper, got %+v", c)
+		}
+		if _, found := helpers.credsFor("other.example.com"); found {
+			t.Fatal("expected no credentials for other.example.com")
+		}
+	}
+	out, err := ioutil.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One list and one get for the first host; one list for the other
+	if got := strings.Fields(string(out)); strings.Join(got, ",") != "list,get,list" {
+		t.Errorf("expected helper to be run once for each host, got runs %v", got)
+	}
+}
+
+func TestCredentialHelpers_Timeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-credential-helper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program := filepath.Join(dir, "docker-credential-hung")
+	if err := ioutil.WriteFile(program, []byte("#!/bin/sh\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(timeout time.Duration) { credentialHelperTimeout = timeout }(credentialHelperTimeout)
+	credentialHelperTimeout = 100 * time.Millisecond
+
+	helpers, err := ParseCredentialHelpers([]string{"registry.example.com=" + program})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, found := helpers.credsFor("registry.example.com"); found {
+		t.Error("expected no credentials from hung helper")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected hung helper to be killed, but waited %s", elapsed)
+	}
+}
+
+func TestParseCredentialHelpers_Errors(t *testing.T) {
+	for _, spec := range []string{"=fake", "registry.example.com=", "[=fake", ""} {
+		if _, err := ParseCredentialHelpers([]string{spec}); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
@@ -17,6 +17,13 @@ type creds struct {
 // Credentials to a (Docker) registry.
 type Credentials struct {
 	m map[string]creds
+	// helpers, if set, are asked for credentials for hosts that
+	// aren't in m.
+	helpers *CredentialHelpers
+	// ecr, if set, gets tokens for ECR hosts. These are preferred to
+	// those in m, since ECR tokens expire, and image pull secrets
+	// given for ECR go stale.
+	ecr *ECRTokens
 }
 
 // NoCredentials returns a usable but empty credentials object.
@@ -87,9 +94,15 @@ func ParseCredentials(b []byte) (Credentials, error) {
 
 // For yields an authenticator for a specific host.
 func (cs Credentials) credsFor(host string) creds {
+	if cred, found := cs.ecr.credsFor(host); found && cred != (creds{}) {
+		return cred
+	}
 	if cred, found := cs.m[host]; found {
 		return cred
 	}
+	if cred, found := cs.helpers.credsFor(host); found {
+		return cred
+	}
 	if host == "gcr.io" {
 		if cred, err := GetGCPOauthToken(); err == nil {
 			return cred
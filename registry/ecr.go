@@ -0,0 +1,343 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// The token is refreshed this long before it expires, so that it
+	// doesn't expire while in use.
+	ecrTokenRefreshMargin = 15 * time.Minute
+	// After failing to get a token, don't try again for this long,
+	// so that every image in the registry doesn't make a request.
+	ecrRetryInterval = time.Minute
+
+	ecrTarget  = "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken"
+	ecrService = "ecr"
+
+	awsMetadataURL        = "http://169.254.169.254/latest"
+	awsContainerCredsHost = "http://169.254.170.2"
+)
+
+// ECR registry hosts look like
+// <account>.dkr.ecr.<region>.amazonaws.com(.cn)
+var ecrHostRegexp = regexp.MustCompile(`^([0-9]{12})\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// The metadata services are local, so should answer quickly, if
+// they're there at all.
+var metadataClient = &http.Client{Timeout: 5 * time.Second}
+
+// ecrClient is used for requests to the ECR API, which shouldn't hold
+// up looking for images for long.
+var ecrClient = &http.Client{Timeout: 30 * time.Second}
+
+// ECRTokens gets authorisation tokens for AWS Elastic Container
+// Registry hosts, using the AWS credentials of the pod (from the
+// environment, the ECS container credentials endpoint, or the EC2
+// instance metadata), and keeps each until shortly before it expires.
+type ECRTokens struct {
+	// Endpoint, if given, is the URL of the ECR API to use for every
+	// region, rather than the regional AWS endpoint.
+	Endpoint string
+	Logger   log.Logger
+
+	mx     sync.Mutex
+	tokens map[string]*ecrToken
+
+	// awsMx is held while getting the AWS credentials, so they're
+	// only got once at a time.
+	awsMx sync.Mutex
+	aws   awsCredentials
+}
+
+type ecrToken struct {
+	// mx is held while the token is being got, so that requests for
+	// the same host wait for it, but those for other hosts don't.
+	mx    sync.Mutex
+	creds creds
+	// refresh is when the token should next be got
+	refresh time.Time
+}
+
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// credsFor gets the credentials for an ECR host, and reports whether
+// the host is an ECR host.
+func (e *ECRTokens) credsFor(host string) (creds, bool) {
+	if e == nil {
+		return creds{}, false
+	}
+	m := ecrHostRegexp.FindStringSubmatch(host)
+	if m == nil {
+		return creds{}, false
+	}
+	account, region, suffix := m[1], m[2], m[3]
+
+	e.mx.Lock()
+	if e.tokens == nil {
+		e.tokens = map[string]*ecrToken{}
+	}
+	token, ok := e.tokens[host]
+	if !ok {
+		token = &ecrToken{}
+		e.tokens[host] = token
+	}
+	e.mx.Unlock()
+
+	token.mx.Lock()
+	defer token.mx.Unlock()
+	now := time.Now()
+	if now.Before(token.refresh) {
+		return token.creds, true
+	}
+
+	cred, expiresAt, err := e.getToken(account, region, suffix, now)
+	if err != nil {
+		if e.Logger != nil {
+			e.Logger.Log("host", host, "err", errors.Wrap(err, "getting ECR authorization token"))
+		}
+		token.creds, token.refresh = creds{}, now.Add(ecrRetryInterval)
+		return creds{}, true
+	}
+	token.creds, token.refresh = cred, expiresAt.Add(-ecrTokenRefreshMargin)
+	return cred, true
+}
+
+func (e *ECRTokens) getToken(account, region, suffix string, now time.Time) (creds, time.Time, error) {
+	aws, err := e.awsCredentials(now)
+	if err != nil {
+		return creds{}, time.Time{}, err
+	}
+
+	endpoint := e.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://api.ecr.%s.amazonaws.com%s/", region, suffix)
+	}
+	body, err := json.Marshal(map[string][]string{"registryIds": {account}})
+	if err != nil {
+		return creds{}, time.Time{}, err
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return creds{}, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", ecrTarget)
+	signAWSRequest(req, body, aws, region, ecrService, now)
+
+	res, err := ecrClient.Do(req)
+	if err != nil {
+		return creds{}, time.Time{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return creds{}, time.Time{}, fmt.Errorf("unexpected status from ECR: %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+
+	var result struct {
+		AuthorizationData []struct {
+			AuthorizationToken string  `json:"authorizationToken"`
+			ExpiresAt          float64 `json:"expiresAt"`
+		} `json:"authorizationData"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return creds{}, time.Time{}, err
+	}
+	if len(result.AuthorizationData) == 0 {
+		return creds{}, time.Time{}, errors.New("no authorization data in response from ECR")
+	}
+	data := result.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+	if err != nil {
+		return creds{}, time.Time{}, err
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return creds{}, time.Time{}, errors.New("malformed authorization token from ECR")
+	}
+	return creds{username: parts[0], password: parts[1]}, time.Unix(int64(data.ExpiresAt), 0), nil
+}
+
+// awsCredentials gets the AWS credentials of the pod, reusing those
+// from before if they won't expire soon.
+func (e *ECRTokens) awsCredentials(now time.Time) (awsCredentials, error) {
+	if os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		return awsCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token:           os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	e.awsMx.Lock()
+	defer e.awsMx.Unlock()
+	if e.aws.AccessKeyID != "" && now.Add(ecrTokenRefreshMargin).Before(e.aws.Expiration) {
+		return e.aws, nil
+	}
+
+	var aws awsCredentials
+	var err error
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		aws, err = getAWSCredentials(awsContainerCredsHost+uri, nil)
+	} else {
+		aws, err = getInstanceCredentials()
+	}
+	if err != nil {
+		return awsCredentials{}, errors.Wrap(err, "getting AWS credentials")
+	}
+	e.aws = aws
+	return aws, nil
+}
+
+// getInstanceCredentials gets the credentials for the role of the
+// EC2 instance from the instance metadata.
+func getInstanceCredentials() (awsCredentials, error) {
+	header := http.Header{}
+	// Use a session token (IMDSv2) if we can get one; otherwise, try
+	// without.
+	req, err := http.NewRequest("PUT", awsMetadataURL+"/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	if res, err := metadataClient.Do(req); err == nil {
+		token, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			header.Set("X-aws-ec2-metadata-token", string(token))
+		}
+	}
+
+	roleURL := awsMetadataURL + "/meta-data/iam/security-credentials/"
+	role, err := getMetadata(roleURL, header)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+	return getAWSCredentials(roleURL+role, header)
+}
+
+func getAWSCredentials(url string, header http.Header) (awsCredentials, error) {
+	body, err := getMetadata(url, header)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var aws awsCredentials
+	if err := json.Unmarshal([]byte(body), &aws); err != nil {
+		return awsCredentials{}, err
+	}
+	if aws.AccessKeyID == "" {
+		return awsCredentials{}, errors.New("no access key in AWS credentials")
+	}
+	return aws, nil
+}
+
+func getMetadata(url string, header http.Header) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status from metadata service: %s", res.Status)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	return string(body), err
+}
+
+// signAWSRequest signs a request with AWS Signature Version 4. See
+// https://docs.aws.amazon.com/general/latest/gr/signature-version-4.html
+func signAWSRequest(req *http.Request, body []byte, aws awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if aws.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", aws.Token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(req.Header.Get(k))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hashHex(body),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := []byte("AWS4" + aws.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		aws.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,162 @@
+package registry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const ecrHost = "123456789012.dkr.ecr.eu-west-1.amazonaws.com"
+
+func setAWSEnv() func() {
+	old := map[string]string{}
+	for k, v := range map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKIDEXAMPLE",
+		"AWS_SECRET_ACCESS_KEY": "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		"AWS_SESSION_TOKEN":     "",
+	} {
+		old[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k, v := range old {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+func TestECRTokens(t *testing.T) {
+	defer setAWSEnv()()
+
+	var requests int32
+	expiresIn := 12 * time.Hour
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if r.Header.Get("X-Amz-Target") != ecrTarget {
+			t.Errorf("unexpected target %q", r.Header.Get("X-Amz-Target"))
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/eu-west-1/ecr/aws4_request") {
+			t.Errorf("unexpected authorization header %q", auth)
+		}
+		token := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("AWS:password%d", n)))
+		fmt.Fprintf(w, `{"authorizationData":[{"authorizationToken":%q,"expiresAt":%d,"proxyEndpoint":"https://%s"}]}`,
+			token, time.Now().Add(expiresIn).Unix(), ecrHost)
+	}))
+	defer server.Close()
+
+	tokens := &ECRTokens{Endpoint: server.URL}
+	cs := NoCredentials()
+	cs.ecr = tokens
+
+	if c := cs.credsFor(ecrHost); c.username != "AWS" || c.password != "password1" {
+		t.Fatalf("unexpected credentials %+v", c)
+	}
+	// The token is kept until shortly before it expires
+	if c := cs.credsFor(ecrHost); c.password != "password1" || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("expected token to be reused, got %+v after %d requests", c, requests)
+	}
+	// Other hosts don't use ECR
+	if c := cs.credsFor("docker.io"); c != (creds{}) || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("expected no credentials for docker.io, got %+v after %d requests", c, requests)
+	}
+
+	// A token that will expire soon is refreshed
+	tokens.tokens[ecrHost].refresh = time.Now().Add(-time.Second)
+	if c := cs.credsFor(ecrHost); c.password != "password2" {
+		t.Fatalf("expected token to be refreshed, got %+v", c)
+	}
+}
+
+func TestECRTokens_SlowHost(t *testing.T) {
+	defer setAWSEnv()()
+
+	const slowHost = "210987654321.dkr.ecr.eu-west-1.amazonaws.com"
+	slowRequested, unblock := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "210987654321") {
+			close(slowRequested)
+			<-unblock
+		}
+		token := base64.StdEncoding.EncodeToString([]byte("AWS:password"))
+		fmt.Fprintf(w, `{"authorizationData":[{"authorizationToken":%q,"expiresAt":%d}]}`, token, time.Now().Add(time.Hour).Unix())
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	tokens := &ECRTokens{Endpoint: server.URL}
+	go tokens.credsFor(slowHost)
+	<-slowRequested
+
+	// Getting a token for one host doesn't wait for another host's
+	done := make(chan creds)
+	go func() {
+		c, _ := tokens.credsFor(ecrHost)
+		done <- c
+	}()
+	select {
+	case c := <-done:
+		if c.password != "password" {
+			t.Errorf("unexpected credentials %+v", c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("getting a token waited for another host")
+	}
+}
+
+func TestECRTokens_PreferredToSecrets(t *testing.T) {
+	defer setAWSEnv()()
+
+	fail := int32(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			http.Error(w, "no", http.StatusForbidden)
+			return
+		}
+		token := base64.StdEncoding.EncodeToString([]byte("AWS:fresh"))
+		fmt.Fprintf(w, `{"authorizationData":[{"authorizationToken":%q,"expiresAt":%d}]}`, token, time.Now().Add(time.Hour).Unix())
+	}))
+	defer server.Close()
+
+	cs, err := ParseCredentials([]byte(fmt.Sprintf(tmpl, ecrHost, okCreds)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.ecr = &ECRTokens{Endpoint: server.URL}
+
+	// If there's no token, the image pull secret is used
+	if c := cs.credsFor(ecrHost); c.username != user {
+		t.Fatalf("expected credentials from pull secret, got %+v", c)
+	}
+	atomic.StoreInt32(&fail, 0)
+	cs.ecr = &ECRTokens{Endpoint: server.URL}
+	if c := cs.credsFor(ecrHost); c.password != "fresh" {
+		t.Fatalf("expected ECR token, got %+v", c)
+	}
+}
+
+// This is the "get-vanilla" example from the AWS Signature Version 4
+// test suite.
+func TestSignAWSRequest(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	signAWSRequest(req, nil, awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}, "us-east-1", "service", now)
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
@@ -0,0 +1,112 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HostConfig says how to connect to a registry host, where that's
+// not simply HTTPS verified against the system's CAs.
+type HostConfig struct {
+	// CAs is a PEM bundle of certificates of extra CAs to trust for
+	// the host, e.g., a private CA.
+	CAs []byte
+	// InsecureSkipVerify means the host's certificate isn't verified
+	// at all.
+	InsecureSkipVerify bool
+	// PlainHTTP means the host is connected to with HTTP rather than
+	// HTTPS.
+	PlainHTTP bool
+}
+
+// HostConfigs gives the connection configuration for each registry
+// host that needs some, keyed by host (including the port, if it's
+// given in image names).
+type HostConfigs map[string]HostConfig
+
+// ParseHostConfigs makes HostConfigs from lists of hosts with CA
+// bundle files, given as `<host>=<file>`; hosts for which not to
+// verify certificates; and hosts to use plain HTTP for.
+func ParseHostConfigs(caBundles, insecureSkipVerify, plainHTTP []string) (HostConfigs, error) {
+	configs := HostConfigs{}
+	for _, spec := range caBundles {
+		i := strings.Index(spec, "=")
+		if i <= 0 || i == len(spec)-1 {
+			return nil, fmt.Errorf("CA bundle %q is not of the form <host>=<file>", spec)
+		}
+		host, file := spec[:i], spec[i+1:]
+		bundle, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading CA bundle for %s", host)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s for %s", file, host)
+		}
+		config := configs[host]
+		config.CAs = append(config.CAs, bundle...)
+		configs[host] = config
+	}
+	for _, host := range insecureSkipVerify {
+		config := configs[host]
+		config.InsecureSkipVerify = true
+		configs[host] = config
+	}
+	for _, host := range plainHTTP {
+		config := configs[host]
+		config.PlainHTTP = true
+		configs[host] = config
+	}
+	// Check the bundles now, rather than when first used
+	for host, config := range configs {
+		if _, err := config.transport(); err != nil {
+			return nil, errors.Wrapf(err, "registry host %s", host)
+		}
+	}
+	return configs, nil
+}
+
+func (c HostConfig) scheme() string {
+	if c.PlainHTTP {
+		return "http"
+	}
+	return "https"
+}
+
+// transport makes an HTTP transport for connecting to the host. It's
+// like http.DefaultTransport, but with the TLS configuration wanted.
+func (c HostConfig) transport() (http.RoundTripper, error) {
+	if len(c.CAs) == 0 && !c.InsecureSkipVerify {
+		return http.DefaultTransport, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if len(c.CAs) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(c.CAs) {
+			return nil, errors.New("no certificates found in CA bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}, nil
+}
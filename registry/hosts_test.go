@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	stdlog "log"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/registry/middleware"
+)
+
+func clientFor(t *testing.T, serverURL string, hosts HostConfigs) (Client, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := &RemoteClientFactory{
+		Logger:   log.NewNopLogger(),
+		Limiters: &middleware.RateLimiters{RPS: 100, Burst: 10},
+		Hosts:    hosts,
+	}
+	return factory.ClientFor(image.CanonicalName{Name: image.Name{Domain: u.Host, Image: "weaveworks/helloworld"}}, NoCredentials())
+}
+
+func manifestFrom(t *testing.T, serverURL string, hosts HostConfigs) error {
+	client, err := clientFor(t, serverURL, hosts)
+	if err != nil {
+		return err
+	}
+	_, err = client.Manifest(context.Background(), "latest")
+	return err
+}
+
+func TestHostConfigs(t *testing.T) {
+	reg := newFakeRegistry("weaveworks/helloworld")
+	reg.addImage(time.Now(), DefaultPlatform, "latest")
+
+	tlsServer := httptest.NewUnstartedServer(reg)
+	// Don't log the handshake errors expected below
+	tlsServer.Config.ErrorLog = stdlog.New(ioutil.Discard, "", 0)
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+	tlsHost := tlsServer.Listener.Addr().String()
+	plainServer := httptest.NewServer(reg)
+	defer plainServer.Close()
+	plainHost := plainServer.Listener.Addr().String()
+
+	dir, err := ioutil.TempDir("", "flux-registry-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(emptyFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without any configuration, the certificate isn't trusted, and
+	// HTTPS isn't spoken by the plain HTTP server.
+	if err := manifestFrom(t, tlsServer.URL, nil); err == nil {
+		t.Error("expected error for untrusted certificate")
+	}
+	if err := manifestFrom(t, plainServer.URL, nil); err == nil {
+		t.Error("expected error for plain HTTP server")
+	}
+
+	for _, v := range []struct {
+		name                string
+		url                 string
+		ca, insecure, plain []string
+	}{
+		{"CA bundle", tlsServer.URL, []string{tlsHost + "=" + caFile}, nil, nil},
+		{"skip verify", tlsServer.URL, nil, []string{tlsHost}, nil},
+		{"plain HTTP", plainServer.URL, nil, nil, []string{plainHost}},
+	} {
+		hosts, err := ParseHostConfigs(v.ca, v.insecure, v.plain)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		if err := manifestFrom(t, v.url, hosts); err != nil {
+			t.Errorf("%s: %v", v.name, err)
+		}
+	}
+
+	for _, ca := range [][]string{
+		{tlsHost},
+		{tlsHost + "=" + filepath.Join(dir, "missing.pem")},
+		{tlsHost + "=" + emptyFile},
+	} {
+		if _, err := ParseHostConfigs(ca, nil, nil); err == nil {
+			t.Errorf("expected error for CA bundle %v", ca)
+		}
+	}
+}
@@ -2,17 +2,91 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/time/rate"
 )
 
+const (
+	// minRPS is the least the rate for a host is reduced to.
+	minRPS = 0.1
+	// After a host asks us to slow down, the rate is reduced at most
+	// once in this interval, so that a burst of responses to
+	// requests already made doesn't reduce it all the way at once.
+	reduceInterval = time.Second
+	// The rate is increased when there have been no requests to slow
+	// down in this interval, by recoverFraction of the full rate.
+	recoverInterval = 30 * time.Second
+	recoverFraction = 0.1
+	// maxBackoff caps how long a Retry-After header can make us back
+	// off for.
+	maxBackoff = time.Hour
+)
+
+// Observer is told about changes to the rate limiting for each host,
+// e.g., so they can be reported as metrics.
+type Observer interface {
+	// RateChanged is called with the effective rate for a host,
+	// when it changes.
+	RateChanged(host string, rps float64)
+	// BackoffUntil is called when a host asks us to back off until
+	// the time given.
+	BackoffUntil(host string, until time.Time)
+	// Throttled is called when a host responds with a status that
+	// asks us to slow down (429, or 5xx).
+	Throttled(host string, status int)
+}
+
+// RateLimiters limits the rate of requests to each host to RPS, with
+// bursts of up to Burst requests. When a host responds with 429 (Too
+// Many Requests) or a 5xx status, the rate for that host is halved,
+// then recovered slowly while it responds normally; and if it gives
+// a Retry-After header, no requests are made to the host until then.
 type RateLimiters struct {
 	RPS, Burst int
-	perHost    map[string]*rate.Limiter
-	mu         sync.Mutex
+	// Observer, if given, is told about changes to the rate limiting.
+	Observer Observer
+	perHost  map[string]*hostLimiter
+	mu       sync.Mutex
+}
+
+type hostLimiter struct {
+	host     string
+	rl       *rate.Limiter
+	observer Observer
+	full     rate.Limit
+
+	mu           sync.Mutex
+	backoffUntil time.Time
+	lastReduced  time.Time
+	lastChanged  time.Time
+}
+
+// BackoffError is returned for a request that isn't made because the
+// host asked us to back off.
+type BackoffError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *BackoffError) Error() string {
+	return fmt.Sprintf("backing off from %s until %s, as asked", e.Host, e.Until.Format(time.RFC3339))
+}
+
+// IsBackoff reports whether an error is (or wraps) a BackoffError.
+func IsBackoff(err error) bool {
+	err = errors.Cause(err)
+	if urlErr, ok := err.(*url.Error); ok {
+		err = errors.Cause(urlErr.Err)
+	}
+	_, ok := err.(*BackoffError)
+	return ok
 }
 
 // Limit returns a RoundTripper for a particular host. We expect to do
@@ -22,31 +96,127 @@ func (limiters *RateLimiters) RoundTripper(rt http.RoundTripper, host string) ht
 	defer limiters.mu.Unlock()
 
 	if limiters.perHost == nil {
-		limiters.perHost = map[string]*rate.Limiter{}
+		limiters.perHost = map[string]*hostLimiter{}
 	}
 	if _, ok := limiters.perHost[host]; !ok {
 		rl := rate.NewLimiter(rate.Limit(limiters.RPS), limiters.Burst)
-		limiters.perHost[host] = rl
+		limiters.perHost[host] = &hostLimiter{
+			host:     host,
+			rl:       rl,
+			observer: limiters.Observer,
+			full:     rate.Limit(limiters.RPS),
+		}
+		if limiters.Observer != nil {
+			limiters.Observer.RateChanged(host, float64(limiters.RPS))
+		}
 	}
 	return &RoundTripRateLimiter{
-		rl: limiters.perHost[host],
-		tx: rt,
+		limiter: limiters.perHost[host],
+		tx:      rt,
 	}
 }
 
 type RoundTripRateLimiter struct {
-	rl *rate.Limiter
-	tx http.RoundTripper
+	limiter *hostLimiter
+	tx      http.RoundTripper
 }
 
 func (t *RoundTripRateLimiter) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := t.limiter.checkBackoff(time.Now()); err != nil {
+		return nil, err
+	}
 	// Wait errors out if the request cannot be processed within
 	// the deadline. This is preemptive, instead of waiting the
 	// entire duration.
-	if err := t.rl.Wait(r.Context()); err != nil {
+	if err := t.limiter.rl.Wait(r.Context()); err != nil {
 		return nil, errors.Wrap(err, "rate limited")
 	}
-	return t.tx.RoundTrip(r)
+	res, err := t.tx.RoundTrip(r)
+	if err == nil {
+		t.limiter.observe(res, time.Now())
+	}
+	return res, err
+}
+
+func (h *hostLimiter) checkBackoff(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if now.Before(h.backoffUntil) {
+		return &BackoffError{Host: h.host, Until: h.backoffUntil}
+	}
+	return nil
+}
+
+// observe adjusts the rate (and backoff) for the host according to
+// the response.
+func (h *hostLimiter) observe(res *http.Response, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	throttled := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	if !throttled {
+		limit := h.rl.Limit()
+		if limit < h.full && now.Sub(h.lastChanged) >= recoverInterval {
+			limit += h.full * recoverFraction
+			if limit > h.full {
+				limit = h.full
+			}
+			h.setLimit(limit, now)
+		}
+		return
+	}
+
+	if h.observer != nil {
+		h.observer.Throttled(h.host, res.StatusCode)
+	}
+	if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After"), now); ok {
+		if retryAfter > maxBackoff {
+			retryAfter = maxBackoff
+		}
+		if until := now.Add(retryAfter); until.After(h.backoffUntil) {
+			h.backoffUntil = until
+			if h.observer != nil {
+				h.observer.BackoffUntil(h.host, until)
+			}
+		}
+	}
+	if now.Sub(h.lastReduced) >= reduceInterval {
+		limit := h.rl.Limit() / 2
+		if limit < minRPS {
+			limit = minRPS
+		}
+		h.lastReduced = now
+		h.setLimit(limit, now)
+	}
+}
+
+func (h *hostLimiter) setLimit(limit rate.Limit, now time.Time) {
+	h.lastChanged = now
+	h.rl.SetLimitAt(now, limit)
+	if h.observer != nil {
+		h.observer.RateChanged(h.host, float64(limit))
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is
+// either a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if !t.After(now) {
+			return 0, false
+		}
+		return t.Sub(now), true
+	}
+	return 0, false
 }
 
 type ContextRoundTripper struct {
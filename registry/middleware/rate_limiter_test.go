@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type respondWith struct {
+	status     int
+	retryAfter string
+}
+
+func (r *respondWith) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	if r.retryAfter != "" {
+		rec.Header().Set("Retry-After", r.retryAfter)
+	}
+	rec.WriteHeader(r.status)
+	return rec.Result(), nil
+}
+
+type recordObserver struct {
+	rates     []float64
+	until     time.Time
+	throttled []int
+}
+
+func (o *recordObserver) RateChanged(host string, rps float64) {
+	o.rates = append(o.rates, rps)
+}
+
+func (o *recordObserver) BackoffUntil(host string, until time.Time) {
+	o.until = until
+}
+
+func (o *recordObserver) Throttled(host string, status int) {
+	o.throttled = append(o.throttled, status)
+}
+
+func limiterFor(limiters *RateLimiters, resp *respondWith) (http.RoundTripper, *hostLimiter) {
+	rt := limiters.RoundTripper(resp, "registry.example.com")
+	return rt, limiters.perHost["registry.example.com"]
+}
+
+func get(t *testing.T, rt http.RoundTripper) error {
+	req, err := http.NewRequest("GET", "https://registry.example.com/v2/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rt.RoundTrip(req.WithContext(context.Background()))
+	return err
+}
+
+func TestRateLimiter_ReduceAndRecover(t *testing.T) {
+	obs := &recordObserver{}
+	limiters := &RateLimiters{RPS: 100, Burst: 10, Observer: obs}
+	resp := &respondWith{status: http.StatusTooManyRequests}
+	rt, h := limiterFor(limiters, resp)
+
+	if err := get(t, rt); err != nil {
+		t.Fatal(err)
+	}
+	if h.rl.Limit() != 50 {
+		t.Errorf("expected rate to be halved to 50 after 429, got %v", h.rl.Limit())
+	}
+	// A burst of responses within the interval only reduces once
+	if err := get(t, rt); err != nil {
+		t.Fatal(err)
+	}
+	if h.rl.Limit() != 50 {
+		t.Errorf("expected rate to stay at 50 within reduce interval, got %v", h.rl.Limit())
+	}
+	if len(obs.throttled) != 2 || obs.throttled[0] != http.StatusTooManyRequests {
+		t.Errorf("expected two throttled responses to be observed, got %v", obs.throttled)
+	}
+
+	// Reduce all the way down to the floor
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		now = now.Add(reduceInterval)
+		h.observe(&http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}, now)
+	}
+	if h.rl.Limit() != minRPS {
+		t.Errorf("expected rate to bottom out at %v, got %v", minRPS, h.rl.Limit())
+	}
+
+	// Normal responses don't recover the rate until the interval has passed
+	ok := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	h.observe(ok, now.Add(recoverInterval/2))
+	if h.rl.Limit() != minRPS {
+		t.Errorf("expected rate to stay at %v before recover interval, got %v", minRPS, h.rl.Limit())
+	}
+	for i := 1; i <= 15; i++ {
+		h.observe(ok, now.Add(time.Duration(i)*recoverInterval))
+	}
+	if h.rl.Limit() != h.full {
+		t.Errorf("expected rate to recover to %v, got %v", h.full, h.rl.Limit())
+	}
+	if last := obs.rates[len(obs.rates)-1]; last != 100 {
+		t.Errorf("expected last observed rate to be 100, got %v", last)
+	}
+}
+
+func TestRateLimiter_RetryAfter(t *testing.T) {
+	obs := &recordObserver{}
+	limiters := &RateLimiters{RPS: 100, Burst: 10, Observer: obs}
+	resp := &respondWith{status: http.StatusTooManyRequests, retryAfter: "60"}
+	rt, h := limiterFor(limiters, resp)
+
+	if err := get(t, rt); err != nil {
+		t.Fatal(err)
+	}
+	if obs.until.IsZero() {
+		t.Fatal("expected backoff to be observed")
+	}
+
+	resp.status, resp.retryAfter = http.StatusOK, ""
+	err := get(t, rt)
+	if !IsBackoff(err) {
+		t.Fatalf("expected backoff error, got %v", err)
+	}
+	if !IsBackoff(errors.Wrap(err, "fetching manifest")) {
+		t.Error("expected wrapped backoff error to be recognised")
+	}
+	if err.(*BackoffError).Until != obs.until {
+		t.Errorf("expected backoff until %s, got %s", obs.until, err.(*BackoffError).Until)
+	}
+
+	// Once the backoff has passed, requests are made again
+	if err := h.checkBackoff(obs.until.Add(time.Second)); err != nil {
+		t.Errorf("expected no backoff after %s, got %v", obs.until, err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, v := range []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Mon, 01 Jan 2018 12:05:00 GMT", 5 * time.Minute, true},
+		{"Mon, 01 Jan 2018 11:55:00 GMT", 0, false},
+		{"soon", 0, false},
+	} {
+		got, ok := parseRetryAfter(v.value, now)
+		if ok != v.ok || got != v.want {
+			t.Errorf("parseRetryAfter(%q): expected %v, %v; got %v, %v", v.value, v.want, v.ok, got, ok)
+		}
+	}
+}
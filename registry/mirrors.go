@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux/image"
+)
+
+// Mirrors gives, for a registry host, the hosts of mirrors (e.g.,
+// pull-through caches) to look up image metadata in before the
+// registry itself, in the order they should be tried.
+type Mirrors map[string][]string
+
+// ParseMirrors makes Mirrors from specs of the form
+// `<registry>=<mirror>`. A registry may be given more than once, to
+// give several mirrors, which are tried in the order given.
+func ParseMirrors(specs []string) (Mirrors, error) {
+	mirrors := Mirrors{}
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i <= 0 || i == len(spec)-1 {
+			return nil, fmt.Errorf("registry mirror %q is not of the form <registry>=<mirror>", spec)
+		}
+		// Use the registry as it appears in canonical names, so that
+		// e.g., docker.io means Docker Hub.
+		registry := image.Name{Domain: spec[:i]}.Registry()
+		mirrors[registry] = append(mirrors[registry], spec[i+1:])
+	}
+	return mirrors, nil
+}
+
+// mirroredClient looks up image metadata in each of the clients in
+// turn, until one succeeds. The last client is for the registry
+// itself, so its result (or error) is the final word.
+type mirroredClient struct {
+	clients []Client
+	hosts   []string
+	logger  log.Logger
+}
+
+func (c *mirroredClient) Tags(ctx context.Context) ([]string, error) {
+	var err error
+	for i, client := range c.clients {
+		var tags []string
+		if tags, err = client.Tags(ctx); err == nil {
+			return tags, nil
+		}
+		c.fallback(i, err)
+	}
+	return nil, err
+}
+
+func (c *mirroredClient) Manifest(ctx context.Context, ref string) (image.Info, error) {
+	var err error
+	for i, client := range c.clients {
+		var info image.Info
+		if info, err = client.Manifest(ctx, ref); err == nil {
+			return info, nil
+		}
+		c.fallback(i, err)
+	}
+	return image.Info{}, err
+}
+
+func (c *mirroredClient) fallback(i int, err error) {
+	if i < len(c.clients)-1 && c.logger != nil {
+		c.logger.Log("mirror", c.hosts[i], "err", err, "fallback", c.hosts[i+1])
+	}
+}
+
+// lazyClient makes the client it stands for the first time it's
+// used, e.g., so that a registry isn't contacted when its mirrors
+// have everything asked for. If making the client fails, it's tried
+// again next time.
+type lazyClient struct {
+	new    func() (Client, error)
+	mu     sync.Mutex
+	client Client
+}
+
+func (c *lazyClient) get() (Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		client, err := c.new()
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+	}
+	return c.client, nil
+}
+
+func (c *lazyClient) Tags(ctx context.Context) ([]string, error) {
+	client, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	return client.Tags(ctx)
+}
+
+func (c *lazyClient) Manifest(ctx context.Context, ref string) (image.Info, error) {
+	client, err := c.get()
+	if err != nil {
+		return image.Info{}, err
+	}
+	return client.Manifest(ctx, ref)
+}
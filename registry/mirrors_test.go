@@ -0,0 +1,181 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/registry/middleware"
+)
+
+// countRequests counts the requests for manifests and tags.
+type countRequests struct {
+	handler http.Handler
+	count   int
+}
+
+func (c *countRequests) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.URL.Path, "/manifests/") || strings.Contains(r.URL.Path, "/tags/") {
+		c.count++
+	}
+	c.handler.ServeHTTP(w, r)
+}
+
+func TestMirrors(t *testing.T) {
+	origin := newFakeRegistry("weaveworks/helloworld")
+	originCreated := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	origin.addImage(originCreated, DefaultPlatform, "cached", "uncached")
+	mirror := newFakeRegistry("weaveworks/helloworld")
+	mirror.addImage(originCreated, DefaultPlatform, "cached")
+
+	originCounter := &countRequests{handler: origin}
+	originServer := httptest.NewServer(originCounter)
+	defer originServer.Close()
+	mirrorCounter := &countRequests{handler: mirror}
+	mirrorServer := httptest.NewServer(mirrorCounter)
+	defer mirrorServer.Close()
+	originHost := originServer.Listener.Addr().String()
+	mirrorHost := mirrorServer.Listener.Addr().String()
+
+	// A mirror that isn't there, which should be skipped
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missingHost := listener.Addr().String()
+	listener.Close()
+
+	hosts, err := ParseHostConfigs(nil, nil, []string{originHost, mirrorHost, missingHost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirrors, err := ParseMirrors([]string{originHost + "=" + missingHost, originHost + "=" + mirrorHost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := &RemoteClientFactory{
+		Logger:   log.NewNopLogger(),
+		Limiters: &middleware.RateLimiters{RPS: 100, Burst: 10},
+		Hosts:    hosts,
+		Mirrors:  mirrors,
+	}
+	repo := image.CanonicalName{Name: image.Name{Domain: originHost, Image: "weaveworks/helloworld"}}
+	client, err := factory.ClientFor(repo, NoCredentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// In the mirror
+	info, err := client.Manifest(ctx, "cached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mirrorCounter.count != 1 || originCounter.count != 0 {
+		t.Errorf("expected manifest from mirror only, got %d requests to mirror, %d to origin", mirrorCounter.count, originCounter.count)
+	}
+	// The image info is for the canonical name, so it's the same
+	// whichever host it came from
+	if info.ID.CanonicalName() != repo {
+		t.Errorf("expected image info for %s, got %s", repo, info.ID.CanonicalName())
+	}
+
+	// Not in the mirror
+	info, err = client.Manifest(ctx, "uncached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if originCounter.count != 1 {
+		t.Errorf("expected fallback to origin, got %d requests to origin", originCounter.count)
+	}
+	if info.ID.CanonicalName() != repo || !info.CreatedAt.Equal(originCreated) {
+		t.Errorf("unexpected image info from origin %+v", info)
+	}
+
+	tags, err := client.Tags(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tags)
+	if strings.Join(tags, ",") != "cached" {
+		t.Errorf("expected tags from mirror, got %v", tags)
+	}
+
+	// Not in either
+	if _, err := client.Manifest(ctx, "missing"); err == nil {
+		t.Error("expected error for image in neither mirror nor origin")
+	}
+}
+
+func TestMirrors_OriginUnreachable(t *testing.T) {
+	mirror := newFakeRegistry("weaveworks/helloworld")
+	mirror.addImage(time.Now(), DefaultPlatform, "cached")
+	mirrorServer := httptest.NewServer(mirror)
+	defer mirrorServer.Close()
+	mirrorHost := mirrorServer.Listener.Addr().String()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	originHost := listener.Addr().String()
+	listener.Close()
+
+	hosts, err := ParseHostConfigs(nil, nil, []string{originHost, mirrorHost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirrors, err := ParseMirrors([]string{originHost + "=" + mirrorHost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := &RemoteClientFactory{
+		Logger:   log.NewNopLogger(),
+		Limiters: &middleware.RateLimiters{RPS: 100, Burst: 10},
+		Hosts:    hosts,
+		Mirrors:  mirrors,
+	}
+	repo := image.CanonicalName{Name: image.Name{Domain: originHost, Image: "weaveworks/helloworld"}}
+	client, err := factory.ClientFor(repo, NoCredentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := client.Manifest(ctx, "cached"); err != nil {
+		t.Errorf("expected manifest from mirror, got error %v", err)
+	}
+	if _, err := client.Tags(ctx); err != nil {
+		t.Errorf("expected tags from mirror, got error %v", err)
+	}
+	// Falling back to the origin fails, since it can't be reached
+	if _, err := client.Manifest(ctx, "uncached"); err == nil {
+		t.Error("expected error for image not in mirror, with origin unreachable")
+	}
+}
+
+func TestParseMirrors(t *testing.T) {
+	mirrors, err := ParseMirrors([]string{"docker.io=mirror1.example.com", "docker.io=mirror2.example.com", "quay.io=quay-mirror.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(mirrors["index.docker.io"], ","); got != "mirror1.example.com,mirror2.example.com" {
+		t.Errorf("expected mirrors for Docker Hub in order given, got %q", got)
+	}
+	if got := strings.Join(mirrors["quay.io"], ","); got != "quay-mirror.example.com" {
+		t.Errorf("expected mirror for quay.io, got %q", got)
+	}
+	for _, spec := range []string{"docker.io", "=mirror.example.com", "docker.io="} {
+		if _, err := ParseMirrors([]string{spec}); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
@@ -52,8 +52,9 @@ func (m *Registry) GetRepository(id image.Name) ([]image.Info, error) {
 }
 
 func (m *Registry) GetImage(id image.Ref) (image.Info, error) {
+	// Images are looked up by tag, as in the cache
 	for _, i := range m.Images {
-		if i.ID.String() == id.String() {
+		if i.ID.String() == id.WithDigest("").String() {
 			return i, nil
 		}
 	}
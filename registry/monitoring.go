@@ -12,12 +12,16 @@ import (
 
 	"github.com/weaveworks/flux/image"
 	fluxmetrics "github.com/weaveworks/flux/metrics"
+	"github.com/weaveworks/flux/registry/middleware"
 )
 
 const (
 	LabelRequestKind    = "kind"
 	RequestKindTags     = "tags"
 	RequestKindMetadata = "metadata"
+
+	LabelHost       = "host"
+	LabelStatusCode = "status_code"
 )
 
 var (
@@ -34,6 +38,24 @@ var (
 		Name:      "fetch_duration_seconds",
 		Help:      "Duration of remote image metadata requests, in seconds",
 	}, []string{LabelRequestKind, fluxmetrics.LabelSuccess})
+	rateLimit = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "registry",
+		Name:      "rate_limit_rps",
+		Help:      "Effective rate limit for requests to a registry host, in requests per second.",
+	}, []string{LabelHost})
+	backoffUntil = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "registry",
+		Name:      "backoff_until_timestamp_seconds",
+		Help:      "Time until which no requests are made to a registry host, as it asked with Retry-After, as a Unix timestamp.",
+	}, []string{LabelHost})
+	throttledResponses = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "registry",
+		Name:      "throttled_responses_total",
+		Help:      "Responses from a registry host asking for fewer requests (429 or 5xx).",
+	}, []string{LabelHost, LabelStatusCode})
 )
 
 type instrumentedRegistry struct {
@@ -93,3 +115,21 @@ func (m *instrumentedClient) Tags(ctx context.Context) (res []string, err error)
 	).Observe(time.Since(start).Seconds())
 	return
 }
+
+// RateLimitMetrics reports the rate limiting of requests to registry
+// hosts as metrics. Give it as the Observer for the rate limiters.
+var RateLimitMetrics middleware.Observer = rateLimitMetrics{}
+
+type rateLimitMetrics struct{}
+
+func (rateLimitMetrics) RateChanged(host string, rps float64) {
+	rateLimit.With(LabelHost, host).Set(rps)
+}
+
+func (rateLimitMetrics) BackoffUntil(host string, until time.Time) {
+	backoffUntil.With(LabelHost, host).Set(float64(until.Unix()))
+}
+
+func (rateLimitMetrics) Throttled(host string, status int) {
+	throttledResponses.With(LabelHost, host, LabelStatusCode, strconv.Itoa(status)).Add(1)
+}
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+)
+
+// Platform is the operating system and CPU architecture (and,
+// optionally, the variant of the architecture) of the images to
+// look at, when an image has a manifest list with an image for
+// each of several platforms.
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// DefaultPlatform is the platform used if none is given.
+var DefaultPlatform = Platform{OS: "linux", Architecture: "amd64"}
+
+// ParsePlatform parses a platform given as `<os>/<arch>` or
+// `<os>/<arch>/<variant>`, e.g., `linux/arm64` or `linux/arm/v7`.
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Platform{}, fmt.Errorf("platform %q is not of the form <os>/<arch>[/<variant>]", s)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return Platform{}, fmt.Errorf("platform %q is not of the form <os>/<arch>[/<variant>]", s)
+		}
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// matches reports whether an entry in a manifest list is for this
+// platform. If no variant is given, an entry for any variant will do.
+func (p Platform) matches(spec manifestlist.PlatformSpec) bool {
+	return spec.OS == p.OS && spec.Architecture == p.Architecture &&
+		(p.Variant == "" || spec.Variant == p.Variant)
+}
+
+// platformOf gives the platform of an entry in a manifest list.
+func platformOf(spec manifestlist.PlatformSpec) Platform {
+	return Platform{OS: spec.OS, Architecture: spec.Architecture, Variant: spec.Variant}
+}
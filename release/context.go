@@ -15,18 +15,20 @@ import (
 )
 
 type ReleaseContext struct {
-	cluster   cluster.Cluster
-	manifests cluster.Manifests
-	repo      *git.Checkout
-	registry  registry.Registry
+	cluster    cluster.Cluster
+	manifests  cluster.Manifests
+	repo       *git.Checkout
+	registry   registry.Registry
+	pinDigests bool
 }
 
-func NewReleaseContext(c cluster.Cluster, m cluster.Manifests, reg registry.Registry, repo *git.Checkout) *ReleaseContext {
+func NewReleaseContext(c cluster.Cluster, m cluster.Manifests, reg registry.Registry, repo *git.Checkout, pinDigests bool) *ReleaseContext {
 	return &ReleaseContext{
-		cluster:   c,
-		manifests: m,
-		repo:      repo,
-		registry:  reg,
+		cluster:    c,
+		manifests:  m,
+		repo:       repo,
+		registry:   reg,
+		pinDigests: pinDigests,
 	}
 }
 
@@ -38,16 +40,32 @@ func (rc *ReleaseContext) Manifests() cluster.Manifests {
 	return rc.manifests
 }
 
+func (rc *ReleaseContext) PinDigests() bool {
+	return rc.pinDigests
+}
+
+// WriteUpdates writes the updates given to the manifest files. Where
+// a file defines more than one of the controllers updated, the bytes
+// calculated for each build on those before it, so the last are
+// written.
 func (rc *ReleaseContext) WriteUpdates(updates []*update.ControllerUpdate) error {
 	rc.repo.Lock()
 	defer rc.repo.Unlock()
 	err := func() error {
+		var paths []string
+		files := map[string][]byte{}
 		for _, update := range updates {
-			fi, err := os.Stat(update.ManifestPath)
+			if _, ok := files[update.ManifestPath]; !ok {
+				paths = append(paths, update.ManifestPath)
+			}
+			files[update.ManifestPath] = update.ManifestBytes
+		}
+		for _, path := range paths {
+			fi, err := os.Stat(path)
 			if err != nil {
 				return err
 			}
-			if err = ioutil.WriteFile(update.ManifestPath, update.ManifestBytes, fi.Mode()); err != nil {
+			if err = ioutil.WriteFile(path, files[path], fi.Mode()); err != nil {
 				return err
 			}
 		}
@@ -70,6 +88,12 @@ func (rc *ReleaseContext) SelectServices(results update.Result, filters ...updat
 		return nil, err
 	}
 
+	if needsLabels(filters) {
+		if err := rc.addLabels(defined); err != nil {
+			return nil, err
+		}
+	}
+
 	var ids []flux.ResourceID
 	definedMap := map[flux.ResourceID]*update.ControllerUpdate{}
 	for _, s := range defined {
@@ -126,6 +150,32 @@ func (rc *ReleaseContext) SelectServices(results update.Result, filters ...updat
 	return filteredUpdates, nil
 }
 
+func needsLabels(filters []update.ControllerFilter) bool {
+	for _, f := range filters {
+		if lf, ok := f.(update.LabelFilter); ok && lf.NeedsLabels() {
+			return true
+		}
+	}
+	return false
+}
+
+// addLabels fills in the labels of the controllers given, from their
+// manifests, so that they can be selected by label.
+func (rc *ReleaseContext) addLabels(defined []*update.ControllerUpdate) error {
+	rc.repo.RLock()
+	defer rc.repo.RUnlock()
+	resources, err := rc.manifests.LoadManifests(rc.repo.ManifestDir())
+	if err != nil {
+		return err
+	}
+	for _, u := range defined {
+		if res, ok := resources[u.ResourceID.String()]; ok {
+			u.Labels = res.Labels()
+		}
+	}
+	return nil
+}
+
 func (rc *ReleaseContext) FindDefinedServices() ([]*update.ControllerUpdate, error) {
 	rc.repo.RLock()
 	defer rc.repo.RUnlock()
@@ -135,19 +185,23 @@ func (rc *ReleaseContext) FindDefinedServices() ([]*update.ControllerUpdate, err
 	}
 
 	var defined []*update.ControllerUpdate
-	for id, paths := range services {
-		switch len(paths) {
+	for id, locations := range services {
+		switch len(locations) {
 		case 1:
-			def, err := ioutil.ReadFile(paths[0])
+			def, err := ioutil.ReadFile(locations[0].Path)
 			if err != nil {
 				return nil, err
 			}
 			defined = append(defined, &update.ControllerUpdate{
 				ResourceID:    id,
-				ManifestPath:  paths[0],
+				ManifestPath:  locations[0].Path,
 				ManifestBytes: def,
 			})
 		default:
+			var paths []string
+			for _, l := range locations {
+				paths = append(paths, l.String())
+			}
 			return nil, fmt.Errorf("multiple resource files found for service %s: %s", id, strings.Join(paths, ", "))
 		}
 	}
@@ -0,0 +1,237 @@
+package release
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/registry"
+	"github.com/weaveworks/flux/update"
+)
+
+var (
+	ErrPlanNotFound  = errors.New("release plan not found")
+	ErrInvalidPlanID = errors.New("invalid release plan ID")
+
+	planIDRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+)
+
+// Plan is the outcome of a dry-run release, kept so that the release
+// can be executed later exactly as it was reported. It records what
+// the outcome depended on -- the revision of the repo, and the images
+// looked at -- so that executing it can be refused if either has
+// changed since.
+type Plan struct {
+	ID string
+	// Spec is the update as it was planned, i.e., with the release
+	// kind "plan".
+	Spec     update.Spec
+	Revision string
+	// Images is a fingerprint of the image metadata consulted when
+	// calculating the release.
+	Images    string
+	Result    update.Result
+	CreatedAt time.Time
+}
+
+// PlanStore keeps release plans as files in a directory, one per
+// plan, named for the plan ID. Plans older than the store's TTL are
+// treated as not found, and removed when the store is opened or
+// another plan is saved.
+type PlanStore struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewPlanStore makes a PlanStore keeping plans in the directory
+// given, creating the directory if it doesn't exist, and removing any
+// plans in it that have expired. A TTL of zero keeps plans until they
+// are applied.
+func NewPlanStore(dir string, ttl time.Duration) (*PlanStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "creating release plan directory")
+	}
+	s := &PlanStore{dir: dir, ttl: ttl}
+	if err := s.removeExpired(time.Now()); err != nil {
+		return nil, errors.Wrap(err, "removing expired release plans")
+	}
+	return s, nil
+}
+
+func (s *PlanStore) path(id string) (string, error) {
+	if !planIDRegexp.MatchString(id) {
+		return "", errors.Wrap(ErrInvalidPlanID, id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *PlanStore) Save(plan Plan) error {
+	path, err := s.path(plan.ID)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, bytes, 0600); err != nil {
+		return err
+	}
+	// The plan is saved regardless of whether others could be
+	// cleared out; they'll be tried again with the next one.
+	s.removeExpired(time.Now())
+	return nil
+}
+
+// Get returns the plan with the ID given, or ErrPlanNotFound if
+// there's no such plan or it has expired.
+func (s *PlanStore) Get(id string) (Plan, error) {
+	var plan Plan
+	path, err := s.path(id)
+	if err != nil {
+		return plan, err
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return plan, errors.Wrap(ErrPlanNotFound, id)
+	}
+	if err != nil {
+		return plan, err
+	}
+	if err = json.Unmarshal(bytes, &plan); err != nil {
+		return plan, err
+	}
+	if s.expired(plan.CreatedAt, time.Now()) {
+		os.Remove(path)
+		return Plan{}, errors.Wrap(ErrPlanNotFound, id)
+	}
+	return plan, nil
+}
+
+func (s *PlanStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *PlanStore) expired(created, now time.Time) bool {
+	return s.ttl > 0 && now.Sub(created) > s.ttl
+}
+
+// removeExpired removes the plans saved longer ago than the TTL, as
+// of the time given.
+func (s *PlanStore) removeExpired(now time.Time) error {
+	if s.ttl <= 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		if s.expired(f.ModTime(), now) {
+			if err := os.Remove(filepath.Join(s.dir, f.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ExecuteSpec returns the changes given as they'd be executed, rather
+// than planned, or false if they are not a kind of release that can
+// be planned and executed later.
+func ExecuteSpec(c Changes) (Changes, bool) {
+	switch s := c.(type) {
+	case update.ReleaseSpec:
+		s.Kind = update.ReleaseKindExecute
+		s.SavePlan = false
+		return s, true
+	}
+	return nil, false
+}
+
+// SameResult reports whether two release results are the same, once
+// serialised; plans are kept as JSON, so this is what can be compared
+// with a result calculated afresh.
+func SameResult(a, b update.Result) bool {
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+	return erra == nil && errb == nil && string(ja) == string(jb)
+}
+
+// ImageRecorder is a registry.Registry that keeps track of the
+// metadata it's asked for, so that it can be fingerprinted.
+type ImageRecorder struct {
+	registry.Registry
+	mu   sync.Mutex
+	seen map[string]interface{}
+}
+
+func NewImageRecorder(reg registry.Registry) *ImageRecorder {
+	return &ImageRecorder{
+		Registry: reg,
+		seen:     map[string]interface{}{},
+	}
+}
+
+func (r *ImageRecorder) record(key string, value interface{}, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.seen[key] = err.Error()
+		return
+	}
+	r.seen[key] = value
+}
+
+func (r *ImageRecorder) GetRepository(name image.Name) ([]image.Info, error) {
+	images, err := r.Registry.GetRepository(name)
+	// The order of images isn't significant, and needn't be stable,
+	// so sort a copy of them for the fingerprint.
+	sorted := make([]image.Info, len(images))
+	copy(sorted, images)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID.String() < sorted[j].ID.String()
+	})
+	r.record("repository "+name.String(), sorted, err)
+	return images, err
+}
+
+func (r *ImageRecorder) GetImage(id image.Ref) (image.Info, error) {
+	info, err := r.Registry.GetImage(id)
+	r.record("image "+id.String(), info, err)
+	return info, err
+}
+
+// Fingerprint returns a digest of the image metadata asked for so
+// far.
+func (r *ImageRecorder) Fingerprint() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Maps are serialised with their keys sorted, so this is stable.
+	bytes, err := json.Marshal(r.seen)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
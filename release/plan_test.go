@@ -0,0 +1,69 @@
+package release
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux/update"
+)
+
+func TestPlanStore_Expiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-plans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewPlanStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := update.Spec{
+		Type: update.Images,
+		Spec: update.ReleaseSpec{
+			ServiceSpecs: []update.ResourceSpec{update.ResourceSpecAll},
+			ImageSpec:    update.ImageSpecLatest,
+			Kind:         update.ReleaseKindPlan,
+		},
+	}
+	now := time.Now().UTC()
+	for _, plan := range []Plan{
+		{ID: "fresh", Spec: spec, CreatedAt: now},
+		{ID: "stale", Spec: spec, CreatedAt: now.Add(-2 * time.Hour)},
+	} {
+		if err := store.Save(plan); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.Get("fresh"); err != nil {
+		t.Errorf("expected fresh plan to be found, got %v", err)
+	}
+	if _, err := store.Get("stale"); errors.Cause(err) != ErrPlanNotFound {
+		t.Errorf("expected stale plan to be not found, got %v", err)
+	}
+
+	// A plan left over from before a restart is cleared out when the
+	// store is opened again.
+	if err := store.Save(Plan{ID: "leftover", Spec: spec, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	old := now.Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "leftover.json"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPlanStore(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "leftover.json")); !os.IsNotExist(err) {
+		t.Errorf("expected expired plan to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "fresh.json")); err != nil {
+		t.Errorf("expected fresh plan to be kept, got %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package release
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 	"github.com/weaveworks/flux/git"
 	"github.com/weaveworks/flux/git/gittest"
 	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/policy"
 	registryMock "github.com/weaveworks/flux/registry/mock"
 	"github.com/weaveworks/flux/update"
 )
@@ -164,6 +168,61 @@ func Test_FilterLogic(t *testing.T) {
 					Error:  update.NotIncluded,
 				},
 			},
+		}, {
+			Name: "selected by label",
+			Spec: update.ReleaseSpec{
+				ServiceSpecs: []update.ResourceSpec{"<team=greetings>"},
+				ImageSpec:    update.ImageSpecLatest,
+				Kind:         update.ReleaseKindExecute,
+				Excludes:     []flux.ResourceID{},
+			},
+			Expected: update.Result{
+				flux.MustParseResourceID("default:deployment/helloworld"): update.ControllerResult{
+					Status: update.ReleaseStatusSuccess,
+					PerContainer: []update.ContainerUpdate{
+						update.ContainerUpdate{
+							Container: helloContainer,
+							Current:   oldRef,
+							Target:    newHwRef,
+						},
+						update.ContainerUpdate{
+							Container: sidecarContainer,
+							Current:   sidecarRef,
+							Target:    newSidecarRef,
+						},
+					},
+				},
+				flux.MustParseResourceID("default:deployment/locked-service"): update.ControllerResult{
+					Status: update.ReleaseStatusSkipped,
+					Error:  update.Locked,
+				},
+				flux.MustParseResourceID("default:deployment/test-service"): update.ControllerResult{
+					Status: update.ReleaseStatusIgnored,
+					Error:  update.NotIncluded,
+				},
+			},
+		}, {
+			Name: "selected by namespace",
+			Spec: update.ReleaseSpec{
+				ServiceSpecs: []update.ResourceSpec{"payments:<all>"},
+				ImageSpec:    update.ImageSpecLatest,
+				Kind:         update.ReleaseKindExecute,
+				Excludes:     []flux.ResourceID{},
+			},
+			Expected: update.Result{
+				flux.MustParseResourceID("default:deployment/helloworld"): update.ControllerResult{
+					Status: update.ReleaseStatusIgnored,
+					Error:  update.NotIncluded,
+				},
+				flux.MustParseResourceID("default:deployment/locked-service"): update.ControllerResult{
+					Status: update.ReleaseStatusIgnored,
+					Error:  update.NotIncluded,
+				},
+				flux.MustParseResourceID("default:deployment/test-service"): update.ControllerResult{
+					Status: update.ReleaseStatusIgnored,
+					Error:  update.NotIncluded,
+				},
+			},
 		}, {
 			Name: "excluded",
 			Spec: update.ReleaseSpec{
@@ -226,6 +285,40 @@ func Test_FilterLogic(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name: "multiple images",
+			Spec: update.ReleaseSpec{
+				ServiceSpecs: []update.ResourceSpec{update.ResourceSpecAll},
+				ImageSpecs:   []update.ImageSpec{update.ImageSpecFromRef(newHwRef), update.ImageSpecFromRef(canonSidecarRef)},
+				Kind:         update.ReleaseKindExecute,
+				Excludes:     []flux.ResourceID{},
+			},
+			Expected: update.Result{
+				flux.MustParseResourceID("default:deployment/helloworld"): update.ControllerResult{
+					Status: update.ReleaseStatusSuccess,
+					PerContainer: []update.ContainerUpdate{
+						update.ContainerUpdate{
+							Container: helloContainer,
+							Current:   oldRef,
+							Target:    newHwRef,
+						},
+						update.ContainerUpdate{
+							Container: sidecarContainer,
+							Current:   sidecarRef,
+							Target:    newSidecarRef,
+						},
+					},
+				},
+				flux.MustParseResourceID("default:deployment/locked-service"): update.ControllerResult{
+					Status: update.ReleaseStatusIgnored,
+					Error:  update.DifferentImage,
+				},
+				flux.MustParseResourceID("default:deployment/test-service"): update.ControllerResult{
+					Status: update.ReleaseStatusIgnored,
+					Error:  update.NotInCluster,
+				},
+			},
+		},
 		// skipped if: not ignored AND (locked or not found in cluster)
 		// else: service is pending.
 		{
@@ -426,6 +519,151 @@ func Test_ImageStatus(t *testing.T) {
 	}
 }
 
+const stagingHelloworld = `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: helloworld
+  namespace: staging
+spec:
+  template:
+    metadata:
+      labels:
+        name: helloworld
+    spec:
+      containers:
+      - name: greeter
+        image: quay.io/weaveworks/helloworld:master-a000002
+      - name: sidecar
+        image: weaveworks/sidecar:master-a000002
+`
+
+func Test_Promote(t *testing.T) {
+	stagingHwSvc := cluster.Controller{
+		ID: flux.MustParseResourceID("staging:deployment/helloworld"),
+		Containers: cluster.ContainersOrExcuse{
+			Containers: []cluster.Container{
+				cluster.Container{
+					Name:  helloContainer,
+					Image: newHwRef.String(),
+				},
+				cluster.Container{
+					Name:  sidecarContainer,
+					Image: newSidecarRef.String(),
+				},
+			},
+		},
+	}
+	mockCluster := &cluster.Mock{
+		SomeServicesFunc: func([]flux.ResourceID) ([]cluster.Controller, error) {
+			return []cluster.Controller{
+				hwSvc,
+				lockedSvc,
+				testSvc,
+				stagingHwSvc,
+			}, nil
+		},
+	}
+
+	for _, tst := range []struct {
+		Name     string
+		Spec     update.PromoteSpec
+		Expected update.Result
+	}{
+		{
+			Name: "promote namespace",
+			Spec: update.PromoteSpec{
+				From: "staging",
+				To:   "default",
+				Kind: update.ReleaseKindExecute,
+			},
+			Expected: update.Result{
+				flux.MustParseResourceID("default:deployment/helloworld"): update.ControllerResult{
+					Status: update.ReleaseStatusSuccess,
+					PerContainer: []update.ContainerUpdate{
+						update.ContainerUpdate{
+							Container: helloContainer,
+							Current:   oldRef,
+							Target:    newHwRef,
+						},
+						update.ContainerUpdate{
+							Container: sidecarContainer,
+							Current:   sidecarRef,
+							Target:    newSidecarRef,
+						},
+					},
+				},
+				flux.MustParseResourceID("default:deployment/locked-service"): update.ControllerResult{
+					Status: update.ReleaseStatusSkipped,
+					Error:  update.Locked,
+				},
+				flux.MustParseResourceID("default:deployment/test-service"): update.ControllerResult{
+					Status: update.ReleaseStatusSkipped,
+					Error:  update.NotInSource,
+				},
+				flux.MustParseResourceID("staging:deployment/helloworld"): update.ControllerResult{
+					Status: update.ReleaseStatusIgnored,
+					Error:  update.NotIncluded,
+				},
+			},
+		}, {
+			Name: "promote controller",
+			Spec: update.PromoteSpec{
+				From: "staging:deployment/helloworld",
+				To:   "default:deployment/helloworld",
+				Kind: update.ReleaseKindExecute,
+			},
+			Expected: update.Result{
+				flux.MustParseResourceID("default:deployment/helloworld"): update.ControllerResult{
+					Status: update.ReleaseStatusSuccess,
+					PerContainer: []update.ContainerUpdate{
+						update.ContainerUpdate{
+							Container: helloContainer,
+							Current:   oldRef,
+							Target:    newHwRef,
+						},
+						update.ContainerUpdate{
+							Container: sidecarContainer,
+							Current:   sidecarRef,
+							Target:    newSidecarRef,
+						},
+					},
+				},
+				flux.MustParseResourceID("default:deployment/locked-service"): update.ControllerResult{
+					Status: update.ReleaseStatusIgnored,
+					Error:  update.NotIncluded,
+				},
+				flux.MustParseResourceID("default:deployment/test-service"): update.ControllerResult{
+					Status: update.ReleaseStatusIgnored,
+					Error:  update.NotIncluded,
+				},
+				flux.MustParseResourceID("staging:deployment/helloworld"): update.ControllerResult{
+					Status: update.ReleaseStatusIgnored,
+					Error:  update.NotIncluded,
+				},
+			},
+		},
+	} {
+		checkout, cleanup := setup(t)
+		defer cleanup()
+		if err := ioutil.WriteFile(filepath.Join(checkout.ManifestDir(), "staging-helloworld-deploy.yaml"), []byte(stagingHelloworld), 0666); err != nil {
+			t.Fatal(err)
+		}
+		ctx := &ReleaseContext{
+			cluster:   mockCluster,
+			manifests: mockManifests,
+			registry:  mockRegistry,
+			repo:      checkout,
+		}
+		results, err := Release(ctx, tst.Spec, log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tst.Expected, results) {
+			t.Errorf("%s - expected:\n%#v, got:\n%#v", tst.Name, tst.Expected, results)
+		}
+	}
+}
+
 func testRelease(t *testing.T, name string, ctx *ReleaseContext, spec update.ReleaseSpec, expected update.Result) {
 	results, err := Release(ctx, spec, log.NewNopLogger())
 	if err != nil {
@@ -435,3 +673,281 @@ func testRelease(t *testing.T, name string, ctx *ReleaseContext, spec update.Rel
 		t.Errorf("%s - expected:\n%#v, got:\n%#v", name, expected, results)
 	}
 }
+
+func Test_PinDigests(t *testing.T) {
+	const (
+		oldDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		newDigest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+	pinnedHwSvc := cluster.Controller{
+		ID: hwSvcID,
+		Containers: cluster.ContainersOrExcuse{
+			Containers: []cluster.Container{
+				cluster.Container{
+					Name:  helloContainer,
+					Image: newHwRef.WithDigest(oldDigest).String(),
+				},
+				cluster.Container{
+					Name:  sidecarContainer,
+					Image: newSidecarRef.String(),
+				},
+			},
+		},
+	}
+	pinnedRegistry := &registryMock.Registry{
+		Images: []image.Info{
+			{
+				ID:        newHwRef,
+				Digest:    newDigest,
+				CreatedAt: timeNow,
+			},
+			{
+				ID:        canonSidecarRef,
+				Digest:    newDigest,
+				CreatedAt: timeNow,
+			},
+		},
+	}
+	spec := update.ReleaseSpec{
+		ServiceSpecs: []update.ResourceSpec{hwSvcSpec},
+		ImageSpec:    update.ImageSpecLatest,
+		Kind:         update.ReleaseKindExecute,
+	}
+
+	for _, tst := range []struct {
+		Name     string
+		Running  cluster.Controller
+		Expected []update.ContainerUpdate
+	}{
+		{
+			Name:    "new tags",
+			Running: hwSvc,
+			Expected: []update.ContainerUpdate{
+				update.ContainerUpdate{
+					Container: helloContainer,
+					Current:   oldRef,
+					Target:    newHwRef.WithDigest(newDigest),
+				},
+				update.ContainerUpdate{
+					Container: sidecarContainer,
+					Current:   sidecarRef,
+					Target:    newSidecarRef.WithDigest(newDigest),
+				},
+			},
+		}, {
+			Name:    "retagged image",
+			Running: pinnedHwSvc,
+			Expected: []update.ContainerUpdate{
+				update.ContainerUpdate{
+					Container: helloContainer,
+					Current:   newHwRef.WithDigest(oldDigest),
+					Target:    newHwRef.WithDigest(newDigest),
+				},
+				update.ContainerUpdate{
+					Container: sidecarContainer,
+					Current:   newSidecarRef,
+					Target:    newSidecarRef.WithDigest(newDigest),
+				},
+			},
+		},
+	} {
+		running := tst.Running
+		mockCluster := &cluster.Mock{
+			SomeServicesFunc: func([]flux.ResourceID) ([]cluster.Controller, error) {
+				return []cluster.Controller{running}, nil
+			},
+		}
+		checkout, cleanup := setup(t)
+		defer cleanup()
+		ctx := &ReleaseContext{
+			cluster:    mockCluster,
+			manifests:  mockManifests,
+			registry:   pinnedRegistry,
+			repo:       checkout,
+			pinDigests: true,
+		}
+		testRelease(t, tst.Name, ctx, spec, update.Result{
+			hwSvcID: update.ControllerResult{
+				Status:       update.ReleaseStatusSuccess,
+				PerContainer: tst.Expected,
+			},
+			lockedSvcID: update.ControllerResult{
+				Status: update.ReleaseStatusIgnored,
+				Error:  update.NotIncluded,
+			},
+			testSvc.ID: update.ControllerResult{
+				Status: update.ReleaseStatusIgnored,
+				Error:  update.NotIncluded,
+			},
+		})
+
+		def, err := ioutil.ReadFile(filepath.Join(checkout.ManifestDir(), "helloworld-deploy.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range tst.Expected {
+			if !strings.Contains(string(def), "image: "+u.Target.String()) {
+				t.Errorf("%s - expected manifest to have image %s, got:\n%s", tst.Name, u.Target, def)
+			}
+		}
+	}
+}
+
+func Test_LockedContainers(t *testing.T) {
+	spec := update.ReleaseSpec{
+		ServiceSpecs: []update.ResourceSpec{hwSvcSpec},
+		ImageSpec:    update.ImageSpecLatest,
+		Kind:         update.ReleaseKindExecute,
+	}
+	mockCluster := &cluster.Mock{
+		SomeServicesFunc: func([]flux.ResourceID) ([]cluster.Controller, error) {
+			return []cluster.Controller{hwSvc}, nil
+		},
+	}
+
+	for _, tst := range []struct {
+		Name     string
+		Locked   []string
+		Expected update.ControllerResult
+	}{
+		{
+			Name:   "sidecar locked",
+			Locked: []string{sidecarContainer},
+			Expected: update.ControllerResult{
+				Status: update.ReleaseStatusSuccess,
+				PerContainer: []update.ContainerUpdate{
+					update.ContainerUpdate{
+						Container: helloContainer,
+						Current:   oldRef,
+						Target:    newHwRef,
+					},
+				},
+			},
+		}, {
+			Name:   "all containers locked",
+			Locked: []string{helloContainer, sidecarContainer},
+			Expected: update.ControllerResult{
+				Status: update.ReleaseStatusSkipped,
+				Error:  update.ContainersLocked,
+			},
+		},
+	} {
+		checkout, cleanup := setup(t)
+		defer cleanup()
+
+		path := filepath.Join(checkout.ManifestDir(), "helloworld-deploy.yaml")
+		def, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var locks policy.Update
+		for _, container := range tst.Locked {
+			locks.Add = locks.Add.Add(policy.LockedPrefix(container))
+		}
+		if def, err = mockManifests.UpdatePolicies(def, hwSvcID, locks); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, def, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := &ReleaseContext{
+			cluster:   mockCluster,
+			manifests: mockManifests,
+			registry:  mockRegistry,
+			repo:      checkout,
+		}
+		testRelease(t, tst.Name, ctx, spec, update.Result{
+			hwSvcID: tst.Expected,
+			lockedSvcID: update.ControllerResult{
+				Status: update.ReleaseStatusIgnored,
+				Error:  update.NotIncluded,
+			},
+			testSvc.ID: update.ControllerResult{
+				Status: update.ReleaseStatusIgnored,
+				Error:  update.NotIncluded,
+			},
+		})
+	}
+}
+
+func Test_MultipleControllersInFile(t *testing.T) {
+	const multiDoc = `---
+# first
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: first
+  namespace: default
+spec:
+  template:
+    spec:
+      containers:
+      - name: greeter
+        image: quay.io/weaveworks/helloworld:master-a000001
+---
+# second
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: second
+  namespace: default
+spec:
+  template:
+    spec:
+      containers:
+      - name: greeter
+        image: quay.io/weaveworks/helloworld:master-a000001
+`
+	firstID := flux.MustParseResourceID("default:deployment/first")
+	secondID := flux.MustParseResourceID("default:deployment/second")
+	controller := func(id flux.ResourceID) cluster.Controller {
+		return cluster.Controller{
+			ID: id,
+			Containers: cluster.ContainersOrExcuse{
+				Containers: []cluster.Container{{Name: helloContainer, Image: oldImage}},
+			},
+		}
+	}
+	mockCluster := &cluster.Mock{
+		SomeServicesFunc: func([]flux.ResourceID) ([]cluster.Controller, error) {
+			return []cluster.Controller{controller(firstID), controller(secondID)}, nil
+		},
+	}
+
+	checkout, cleanup := setup(t)
+	defer cleanup()
+	path := filepath.Join(checkout.ManifestDir(), "multi.yaml")
+	if err := ioutil.WriteFile(path, []byte(multiDoc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	ctx := &ReleaseContext{
+		cluster:   mockCluster,
+		manifests: mockManifests,
+		registry:  mockRegistry,
+		repo:      checkout,
+	}
+	spec := update.ReleaseSpec{
+		ServiceSpecs: []update.ResourceSpec{update.MakeResourceSpec(firstID), update.MakeResourceSpec(secondID)},
+		ImageSpec:    update.ImageSpecLatest,
+		Kind:         update.ReleaseKindExecute,
+	}
+	results, err := Release(ctx, spec, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []flux.ResourceID{firstID, secondID} {
+		if results[id].Status != update.ReleaseStatusSuccess {
+			t.Errorf("expected %s to be released, got %+v", id, results[id])
+		}
+	}
+
+	def, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Replace(multiDoc, oldImage, newHwRef.String(), -1)
+	if string(def) != expected {
+		t.Errorf("expected both controllers in the file to be updated:\n%s\ngot:\n%s", expected, def)
+	}
+}
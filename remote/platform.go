@@ -75,6 +75,10 @@ type PlatformV9 interface {
 	NotifyChange(context.Context, Change) error
 }
 
+// PlatformV10 was a change to argument domains (ResourceSpec was
+// broadened to include selectors, e.g., all the controllers in a
+// namespace, or with certain labels)
+
 // Platform is the SPI for the daemon; i.e., it's all the things we
 // have to ask to the daemon, rather than the service.
 type Platform interface {
@@ -0,0 +1,59 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"net/rpc"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/job"
+	"github.com/weaveworks/flux/remote"
+	"github.com/weaveworks/flux/update"
+)
+
+// RPCClientV10 has the same methods as version 9, but resource specs
+// may also be selectors (e.g., all the controllers in a namespace, or
+// with particular labels), which earlier versions can't interpret.
+type RPCClientV10 struct {
+	*RPCClientV9
+}
+
+var _ remote.Platform = &RPCClientV10{}
+
+func NewClientV10(conn io.ReadWriteCloser) *RPCClientV10 {
+	return &RPCClientV10{NewClientV9(conn)}
+}
+
+func (p *RPCClientV10) ListImages(ctx context.Context, spec update.ResourceSpec) ([]flux.ImageStatus, error) {
+	var resp ListImagesResponse
+	if err := requireServiceSpecKinds(spec, supportedKindsV8); err != nil {
+		return resp.Result, remote.UnsupportedResourceKind(err)
+	}
+
+	err := p.client.Call("RPCServer.ListImages", spec, &resp)
+	if err != nil {
+		if _, ok := err.(rpc.ServerError); !ok && err != nil {
+			err = remote.FatalError{Err: err}
+		}
+	} else if resp.ApplicationError != nil {
+		err = resp.ApplicationError
+	}
+	return resp.Result, err
+}
+
+func (p *RPCClientV10) UpdateManifests(ctx context.Context, u update.Spec) (job.ID, error) {
+	var resp UpdateManifestsResponse
+	if err := requireSpecKinds(u, supportedKindsV8); err != nil {
+		return resp.Result, remote.UnsupportedResourceKind(err)
+	}
+
+	err := p.client.Call("RPCServer.UpdateManifests", u, &resp)
+	if err != nil {
+		if _, ok := err.(rpc.ServerError); !ok && err != nil {
+			err = remote.FatalError{Err: err}
+		}
+	} else if resp.ApplicationError != nil {
+		err = resp.ApplicationError
+	}
+	return resp.Result, err
+}
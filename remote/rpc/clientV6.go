@@ -75,6 +75,9 @@ func (p *RPCClientV6) ListImages(ctx context.Context, spec update.ResourceSpec)
 	if err := requireServiceSpecKinds(spec, supportedKindsV6); err != nil {
 		return images, remote.UpgradeNeededError(err)
 	}
+	if err := requireNoServiceSpecSelector(spec); err != nil {
+		return images, remote.UpgradeNeededError(err)
+	}
 
 	err := p.client.Call("RPCServer.ListImages", spec, &images)
 	if _, ok := err.(rpc.ServerError); !ok && err != nil {
@@ -91,6 +94,15 @@ func (p *RPCClientV6) UpdateManifests(ctx context.Context, u update.Spec) (job.I
 	if err := requireSpecKinds(u, supportedKindsV6); err != nil {
 		return result, remote.UpgradeNeededError(err)
 	}
+	if err := requireNoSelectors(u); err != nil {
+		return result, remote.UpgradeNeededError(err)
+	}
+	if err := requireNoPolicyFile(u); err != nil {
+		return result, remote.UpgradeNeededError(err)
+	}
+	if err := requireNoPlans(u); err != nil {
+		return result, remote.UpgradeNeededError(err)
+	}
 
 	err := p.client.Call("RPCServer.UpdateManifests", u, &result)
 	if _, ok := err.(rpc.ServerError); !ok && err != nil {
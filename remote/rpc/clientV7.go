@@ -62,6 +62,9 @@ func (p *RPCClientV7) ListImages(ctx context.Context, spec update.ResourceSpec)
 	if err := requireServiceSpecKinds(spec, supportedKindsV7); err != nil {
 		return resp.Result, remote.UpgradeNeededError(err)
 	}
+	if err := requireNoServiceSpecSelector(spec); err != nil {
+		return resp.Result, remote.UpgradeNeededError(err)
+	}
 
 	err := p.client.Call("RPCServer.ListImages", spec, &resp)
 	if err != nil {
@@ -79,6 +82,15 @@ func (p *RPCClientV7) UpdateManifests(ctx context.Context, u update.Spec) (job.I
 	if err := requireSpecKinds(u, supportedKindsV7); err != nil {
 		return resp.Result, remote.UpgradeNeededError(err)
 	}
+	if err := requireNoSelectors(u); err != nil {
+		return resp.Result, remote.UpgradeNeededError(err)
+	}
+	if err := requireNoPolicyFile(u); err != nil {
+		return resp.Result, remote.UpgradeNeededError(err)
+	}
+	if err := requireNoPlans(u); err != nil {
+		return resp.Result, remote.UpgradeNeededError(err)
+	}
 	err := p.client.Call("RPCServer.UpdateManifests", u, &resp)
 	if err != nil {
 		if _, ok := err.(rpc.ServerError); !ok && err != nil {
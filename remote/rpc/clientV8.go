@@ -32,6 +32,9 @@ func (p *RPCClientV8) ListImages(ctx context.Context, spec update.ResourceSpec)
 	if err := requireServiceSpecKinds(spec, supportedKindsV8); err != nil {
 		return resp.Result, remote.UnsupportedResourceKind(err)
 	}
+	if err := requireNoServiceSpecSelector(spec); err != nil {
+		return resp.Result, remote.UpgradeNeededError(err)
+	}
 
 	err := p.client.Call("RPCServer.ListImages", spec, &resp)
 	if err != nil {
@@ -49,6 +52,15 @@ func (p *RPCClientV8) UpdateManifests(ctx context.Context, u update.Spec) (job.I
 	if err := requireSpecKinds(u, supportedKindsV8); err != nil {
 		return resp.Result, remote.UnsupportedResourceKind(err)
 	}
+	if err := requireNoSelectors(u); err != nil {
+		return resp.Result, remote.UpgradeNeededError(err)
+	}
+	if err := requireNoPolicyFile(u); err != nil {
+		return resp.Result, remote.UpgradeNeededError(err)
+	}
+	if err := requireNoPlans(u); err != nil {
+		return resp.Result, remote.UpgradeNeededError(err)
+	}
 
 	err := p.client.Call("RPCServer.UpdateManifests", u, &resp)
 	if err != nil {
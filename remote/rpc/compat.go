@@ -46,6 +46,55 @@ func requireSpecKinds(s update.Spec, kinds []string) error {
 	return nil
 }
 
+// requireNoServiceSpecSelector checks the resource spec is not a
+// selector, since those were introduced in version 10.
+func requireNoServiceSpecSelector(ss update.ResourceSpec) error {
+	if ss.IsSelector() {
+		return fmt.Errorf("Selecting controllers is not supported: %s", ss)
+	}
+	return nil
+}
+
+func requireNoSelectors(s update.Spec) error {
+	if s, ok := s.Spec.(update.ReleaseSpec); ok {
+		for _, ss := range s.ServiceSpecs {
+			if err := requireNoServiceSpecSelector(ss); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// requireNoPolicyFile checks the spec doesn't ask for policies to be
+// written to the policy file, since that was introduced in version
+// 10; an earlier daemon would write them to the manifests instead.
+func requireNoPolicyFile(s update.Spec) error {
+	if s, ok := s.Spec.(policy.Updates); ok {
+		for id, u := range s {
+			if u.InPolicyFile {
+				return fmt.Errorf("Updating the policy file is not supported: %s", id)
+			}
+		}
+	}
+	return nil
+}
+
+// requireNoPlans checks the spec neither saves a release plan nor
+// applies one, since plans were introduced in version 10; an earlier
+// daemon would not know the spec, or would drop the plan.
+func requireNoPlans(s update.Spec) error {
+	switch s := s.Spec.(type) {
+	case update.ReleaseSpec:
+		if s.SavePlan {
+			return fmt.Errorf("Saving release plans is not supported")
+		}
+	case update.ApplyPlanSpec:
+		return fmt.Errorf("Applying a saved release plan is not supported: %s", s.ID)
+	}
+	return nil
+}
+
 func contains(ss []string, s string) bool {
 	for _, x := range ss {
 		if x == s {
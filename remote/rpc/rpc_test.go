@@ -7,7 +7,12 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/weaveworks/flux"
+	fluxerr "github.com/weaveworks/flux/errors"
+	"github.com/weaveworks/flux/job"
+	"github.com/weaveworks/flux/policy"
 	"github.com/weaveworks/flux/remote"
+	"github.com/weaveworks/flux/update"
 )
 
 func pipes() (io.ReadWriteCloser, io.ReadWriteCloser) {
@@ -30,11 +35,150 @@ func TestRPC(t *testing.T) {
 			t.Fatal(err)
 		}
 		go server.ServeConn(serverConn)
-		return NewClientV9(clientConn)
+		return NewClientV10(clientConn)
 	}
 	remote.PlatformTestBattery(t, wrap)
 }
 
+// Selectors were introduced in version 10; earlier clients must not
+// send them, since daemons of those versions can't interpret them.
+func TestRPCSelectors(t *testing.T) {
+	ctx := context.Background()
+	spec := update.Spec{
+		Type: update.Images,
+		Spec: update.ReleaseSpec{
+			ServiceSpecs: []update.ResourceSpec{"payments:<team=payments>"},
+			ImageSpec:    update.ImageSpecLatest,
+		},
+	}
+
+	for _, v := range []struct {
+		version  string
+		client   func(io.ReadWriteCloser) remote.Platform
+		expectOK bool
+	}{
+		{"v9", func(c io.ReadWriteCloser) remote.Platform { return NewClientV9(c) }, false},
+		{"v10", func(c io.ReadWriteCloser) remote.Platform { return NewClientV10(c) }, true},
+	} {
+		mock := &remote.MockPlatform{UpdateManifestsAnswer: job.ID("job")}
+		clientConn, serverConn := pipes()
+		server, err := NewServer(mock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server.ServeConn(serverConn)
+		client := v.client(clientConn)
+
+		_, err = client.UpdateManifests(ctx, spec)
+		if v.expectOK && err != nil {
+			t.Errorf("%s: expected selector to be sent, got error %v", v.version, err)
+		}
+		if !v.expectOK {
+			if err, ok := err.(*fluxerr.Error); !ok || err.Type != fluxerr.User {
+				t.Errorf("%s: expected user error for selector, got %v", v.version, err)
+			}
+		}
+		_, err = client.ListImages(ctx, "<team=payments>")
+		if (err == nil) != v.expectOK {
+			t.Errorf("%s: listing images with a selector: expected ok = %v, got error %v", v.version, v.expectOK, err)
+		}
+	}
+}
+
+// Writing policies to the policy file was introduced in version 10;
+// an earlier daemon would write them to the manifests instead.
+func TestRPCPolicyFile(t *testing.T) {
+	spec := update.Spec{
+		Type: update.Policy,
+		Spec: policy.Updates{
+			flux.MustParseResourceID("default:deployment/helloworld"): policy.Update{
+				Add:          policy.Set{policy.Automated: "true"},
+				InPolicyFile: true,
+			},
+		},
+	}
+
+	for _, v := range []struct {
+		version  string
+		client   func(io.ReadWriteCloser) remote.Platform
+		expectOK bool
+	}{
+		{"v8", func(c io.ReadWriteCloser) remote.Platform { return NewClientV8(c) }, false},
+		{"v9", func(c io.ReadWriteCloser) remote.Platform { return NewClientV9(c) }, false},
+		{"v10", func(c io.ReadWriteCloser) remote.Platform { return NewClientV10(c) }, true},
+	} {
+		mock := &remote.MockPlatform{UpdateManifestsAnswer: job.ID("job")}
+		clientConn, serverConn := pipes()
+		server, err := NewServer(mock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server.ServeConn(serverConn)
+		client := v.client(clientConn)
+
+		_, err = client.UpdateManifests(context.Background(), spec)
+		if v.expectOK && err != nil {
+			t.Errorf("%s: expected policy file update to be sent, got error %v", v.version, err)
+		}
+		if !v.expectOK {
+			if err, ok := err.(*fluxerr.Error); !ok || err.Type != fluxerr.User {
+				t.Errorf("%s: expected user error for policy file update, got %v", v.version, err)
+			}
+		}
+	}
+}
+
+// Saving and applying release plans were introduced in version 10;
+// an earlier daemon would not keep the plan, or know what to apply.
+func TestRPCPlans(t *testing.T) {
+	specs := []update.Spec{
+		{
+			Type: update.Images,
+			Spec: update.ReleaseSpec{
+				ServiceSpecs: []update.ResourceSpec{update.ResourceSpecAll},
+				ImageSpec:    update.ImageSpecLatest,
+				Kind:         update.ReleaseKindPlan,
+				SavePlan:     true,
+			},
+		},
+		{
+			Type: update.ApplyPlan,
+			Spec: update.ApplyPlanSpec{ID: "6f2a4b1c-1d3e-4f5a-8b9c-0d1e2f3a4b5c"},
+		},
+	}
+
+	for _, v := range []struct {
+		version  string
+		client   func(io.ReadWriteCloser) remote.Platform
+		expectOK bool
+	}{
+		{"v8", func(c io.ReadWriteCloser) remote.Platform { return NewClientV8(c) }, false},
+		{"v9", func(c io.ReadWriteCloser) remote.Platform { return NewClientV9(c) }, false},
+		{"v10", func(c io.ReadWriteCloser) remote.Platform { return NewClientV10(c) }, true},
+	} {
+		for _, spec := range specs {
+			mock := &remote.MockPlatform{UpdateManifestsAnswer: job.ID("job")}
+			clientConn, serverConn := pipes()
+			server, err := NewServer(mock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			go server.ServeConn(serverConn)
+			client := v.client(clientConn)
+
+			_, err = client.UpdateManifests(context.Background(), spec)
+			if v.expectOK && err != nil {
+				t.Errorf("%s: expected %s spec to be sent, got error %v", v.version, spec.Type, err)
+			}
+			if !v.expectOK {
+				if err, ok := err.(*fluxerr.Error); !ok || err.Type != fluxerr.User {
+					t.Errorf("%s: expected user error for %s spec, got %v", v.version, spec.Type, err)
+				}
+			}
+		}
+	}
+}
+
 // ---
 
 type poorReader struct{}
@@ -66,7 +210,7 @@ func TestBadRPC(t *testing.T) {
 	}
 	go server.ServeConn(serverConn)
 
-	client := NewClientV9(clientConn)
+	client := NewClientV10(clientConn)
 	if err = client.Ping(ctx); err == nil {
 		t.Error("expected error from RPC system, got nil")
 	}
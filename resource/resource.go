@@ -9,6 +9,7 @@ import (
 type Resource interface {
 	ResourceID() flux.ResourceID // name, to correlate with what's in the cluster
 	Policy() policy.Set          // policy for this resource; e.g., whether it is locked, automated, ignored
+	Labels() map[string]string   // labels given to this resource, e.g., for selecting it
 	Source() string              // where did this come from (informational)
 	Bytes() []byte               // the definition, for sending to platform.Sync
 }
@@ -6,9 +6,10 @@ import (
 )
 
 type rsc struct {
-	bytes []byte
-	Kind  string
-	Meta  struct {
+	bytes  []byte
+	Kind   string
+	policy policy.Set
+	Meta   struct {
 		Namespace string
 		Name      string
 	}
@@ -30,8 +31,15 @@ func (rs rsc) ResourceID() flux.ResourceID {
 	return flux.MakeResourceID(rs.Meta.Namespace, rs.Kind, rs.Meta.Name)
 }
 
+func (rs rsc) Labels() map[string]string {
+	return nil
+}
+
 func (rs rsc) Policy() policy.Set {
 	p := policy.Set{}
+	for k, v := range rs.policy {
+		p[k] = v
+	}
 	return p
 }
 
@@ -54,3 +62,9 @@ func mockResourceWithIgnorePolicy(kind, namespace, name string) rscIgnorePolicy
 	ri.Meta.Name = name
 	return ri
 }
+
+func mockResourceWithPolicy(kind, namespace, name string, p policy.Set) rsc {
+	r := mockResourceWithoutIgnorePolicy(kind, namespace, name)
+	r.policy = p
+	return r
+}
@@ -40,20 +40,20 @@ func Sync(m cluster.Manifests, repoResources map[string]resource.Resource, clus
 	// before this cleanup cluster feature can be unleashed on the world.
 	if deletes {
 		for id, res := range otherClusterResources {
-			prepareSyncDelete(logger, repoResources, id, res, &sync)
+			prepareSyncDelete(logger, repoResources, nsClusterResources, id, res, &sync)
 		}
 		for id, res := range nsClusterResources {
-			prepareSyncDelete(logger, repoResources, id, res, &sync)
+			prepareSyncDelete(logger, repoResources, nsClusterResources, id, res, &sync)
 		}
 	}
 
 	// To avoid errors due to a non existent namespace if a resource in that namespace is created first,
 	// create Namespace objects first
 	for id, res := range nsRepoResources {
-		prepareSyncApply(logger, clusterResources, id, res, &sync)
+		prepareSyncApply(logger, clusterResources, nsRepoResources, nsClusterResources, id, res, &sync)
 	}
 	for id, res := range otherRepoResources {
-		prepareSyncApply(logger, clusterResources, id, res, &sync)
+		prepareSyncApply(logger, clusterResources, nsRepoResources, nsClusterResources, id, res, &sync)
 	}
 
 	return clus.Sync(sync)
@@ -76,11 +76,38 @@ func separateResourcesByType(resources map[string]resource.Resource) (map[string
 	return nsResources, otherResources
 }
 
-func prepareSyncDelete(logger log.Logger, repoResources map[string]resource.Resource, id string, res resource.Resource, sync *cluster.SyncDef) {
+// ignored reports whether a resource has the ignore policy in effect,
+// either given on the resource itself or as a default on the
+// namespace it's in (if that is among the namespaces given), and not
+// switched off on the resource with a value other than "true". A
+// Namespace is ignored if it has the ignore policy at all, since its
+// policies are also the defaults for what's in it.
+func ignored(res resource.Resource, namespaces map[string]resource.Resource) bool {
+	if isNamespace(res) {
+		return res.Policy().Contains(policy.Ignore)
+	}
+	resNS, _, _ := res.ResourceID().Components()
+	var defaults policy.Set
+	for _, ns := range namespaces {
+		if _, _, name := ns.ResourceID().Components(); name == resNS {
+			defaults = ns.Policy()
+			break
+		}
+	}
+	effective, _ := policy.Inherit(res.Policy(), defaults)
+	return effective.Contains(policy.Ignore)
+}
+
+func isNamespace(res resource.Resource) bool {
+	_, kind, _ := res.ResourceID().Components()
+	return kind == "namespace"
+}
+
+func prepareSyncDelete(logger log.Logger, repoResources, namespaces map[string]resource.Resource, id string, res resource.Resource, sync *cluster.SyncDef) {
 	if len(repoResources) == 0 {
 		return
 	}
-	if res.Policy().Contains(policy.Ignore) {
+	if ignored(res, namespaces) {
 		logger.Log("resource", res.ResourceID(), "ignore", "delete")
 		return
 	}
@@ -92,16 +119,16 @@ func prepareSyncDelete(logger log.Logger, repoResources map[string]resource.Reso
 	}
 }
 
-func prepareSyncApply(logger log.Logger, clusterResources map[string]resource.Resource, id string, res resource.Resource, sync *cluster.SyncDef) {
-	if res.Policy().Contains(policy.Ignore) {
+// prepareSyncApply adds an action to apply the resource, unless it's
+// ignored in the repo or in the cluster.
+func prepareSyncApply(logger log.Logger, clusterResources, repoNamespaces, clusterNamespaces map[string]resource.Resource, id string, res resource.Resource, sync *cluster.SyncDef) {
+	if ignored(res, repoNamespaces) {
 		logger.Log("resource", res.ResourceID(), "ignore", "apply")
 		return
 	}
-	if cres, ok := clusterResources[id]; ok {
-		if cres.Policy().Contains(policy.Ignore) {
-			logger.Log("resource", res.ResourceID(), "ignore", "apply")
-			return
-		}
+	if cres, ok := clusterResources[id]; ok && ignored(cres, clusterNamespaces) {
+		logger.Log("resource", res.ResourceID(), "ignore", "apply")
+		return
 	}
 	sync.Actions = append(sync.Actions, cluster.SyncAction{
 		ResourceID: id,
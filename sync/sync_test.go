@@ -20,6 +20,7 @@ import (
 	"github.com/weaveworks/flux/cluster/kubernetes/testfiles"
 	"github.com/weaveworks/flux/git"
 	"github.com/weaveworks/flux/git/gittest"
+	"github.com/weaveworks/flux/policy"
 	"github.com/weaveworks/flux/resource"
 )
 
@@ -185,7 +186,7 @@ func TestPrepareSyncDelete(t *testing.T) {
 	logger := log.NewNopLogger()
 	for _, sc := range tests {
 		sync := &cluster.SyncDef{}
-		prepareSyncDelete(logger, sc.repoRes, sc.id, sc.res, sync)
+		prepareSyncDelete(logger, sc.repoRes, nil, sc.id, sc.res, sync)
 
 		if !reflect.DeepEqual(sc.expected, sync) {
 			t.Errorf("%s: expected %+v, got %+v\n", sc.msg, sc.expected, sync)
@@ -241,7 +242,7 @@ func TestPrepareSyncApply(t *testing.T) {
 	logger := log.NewNopLogger()
 	for _, sc := range tests {
 		sync := &cluster.SyncDef{}
-		prepareSyncApply(logger, sc.clusRes, sc.id, sc.res, sync)
+		prepareSyncApply(logger, sc.clusRes, nil, nil, sc.id, sc.res, sync)
 
 		if !reflect.DeepEqual(sc.expected, sync) {
 			t.Errorf("%s: expected %+v, got %+v\n", sc.msg, sc.expected, sync)
@@ -249,6 +250,72 @@ func TestPrepareSyncApply(t *testing.T) {
 	}
 }
 
+func TestIgnored(t *testing.T) {
+	namespaces := map[string]resource.Resource{
+		"ns1": mockResourceWithIgnorePolicy("namespace", "", "ns1"),
+		"ns2": mockResourceWithoutIgnorePolicy("namespace", "", "ns2"),
+	}
+	optOut := policy.Set{policy.Ignore: "false"}
+	for _, tst := range []struct {
+		res     resource.Resource
+		ignored bool
+	}{
+		{mockResourceWithoutIgnorePolicy("deployment", "ns1", "d1"), true},
+		{mockResourceWithoutIgnorePolicy("deployment", "ns2", "d2"), false},
+		{mockResourceWithoutIgnorePolicy("deployment", "ns3", "d3"), false},
+		{mockResourceWithIgnorePolicy("deployment", "ns2", "d4"), true},
+		{mockResourceWithPolicy("deployment", "ns1", "d5", optOut), false},
+		{mockResourceWithPolicy("deployment", "ns3", "d6", optOut), false},
+		{namespaces["ns1"], true},
+		{namespaces["ns2"], false},
+		{mockResourceWithPolicy("namespace", "", "ns4", optOut), true},
+	} {
+		if ignored := ignored(tst.res, namespaces); ignored != tst.ignored {
+			t.Errorf("%s: expected ignored to be %v, got %v", tst.res.ResourceID(), tst.ignored, ignored)
+		}
+	}
+}
+
+func TestSync_IgnoreDefaults(t *testing.T) {
+	ns := mockResourceWithIgnorePolicy("namespace", "", "ns1")
+	ignoredDep := mockResourceWithoutIgnorePolicy("deployment", "ns1", "ignored")
+	optedOut := mockResourceWithPolicy("deployment", "ns1", "opted-out", policy.Set{policy.Ignore: "false"})
+	repoResources := map[string]resource.Resource{
+		"ns":        ns,
+		"ignored":   ignoredDep,
+		"opted-out": optedOut,
+	}
+	var synced cluster.SyncDef
+	clus := &cluster.Mock{
+		ExportFunc: func() ([]byte, error) { return nil, nil },
+		// The same again in the cluster, as the last sync would
+		// have left it
+		ParseManifestsFunc: func([]byte) (map[string]resource.Resource, error) { return repoResources, nil },
+		SyncFunc: func(def cluster.SyncDef) error {
+			synced = def
+			return nil
+		},
+	}
+
+	if err := Sync(clus, repoResources, clus, true, log.NewNopLogger()); err != nil {
+		t.Fatal(err)
+	}
+	applied := map[string]bool{}
+	for _, action := range synced.Actions {
+		if action.Delete != nil {
+			t.Errorf("unexpected delete of %s", action.ResourceID)
+		}
+		applied[action.ResourceID] = true
+	}
+	// The Namespace is ignored itself, as well as being the default
+	// for the resources in it.
+	for id, expected := range map[string]bool{"ns": false, "ignored": false, "opted-out": true} {
+		if applied[id] != expected {
+			t.Errorf("%s: expected applied to be %v, got %v", id, expected, applied[id])
+		}
+	}
+}
+
 // ---
 
 var gitconf = git.Config{
@@ -8,6 +8,7 @@ import (
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/cluster"
 	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/policy"
 )
 
 type Automated struct {
@@ -93,7 +94,13 @@ func (a *Automated) markSkipped(results Result) {
 func (a *Automated) calculateImageUpdates(rc ReleaseContext, candidates []*ControllerUpdate, result Result, logger log.Logger) ([]*ControllerUpdate, error) {
 	updates := []*ControllerUpdate{}
 
+	policies, err := rc.ServicesWithPolicies()
+	if err != nil {
+		return nil, err
+	}
+
 	serviceMap := a.serviceMap()
+	files := manifestFiles{}
 	for _, u := range candidates {
 		containers, err := u.Controller.ContainersOrError()
 		if err != nil {
@@ -106,6 +113,7 @@ func (a *Automated) calculateImageUpdates(rc ReleaseContext, candidates []*Contr
 
 		changes := serviceMap[u.ResourceID]
 		containerUpdates := []ContainerUpdate{}
+		var containerLocked bool
 		for _, container := range containers {
 			currentImageID, err := image.ParseRef(container.Image)
 			if err != nil {
@@ -116,9 +124,13 @@ func (a *Automated) calculateImageUpdates(rc ReleaseContext, candidates []*Contr
 				if change.Container.Name != container.Name {
 					continue
 				}
+				if policy.IsContainerLocked(policies[u.ResourceID], container.Name) {
+					containerLocked = true
+					continue
+				}
 
-				newImageID := currentImageID.WithNewTag(change.ImageID.Tag)
-				u.ManifestBytes, err = rc.Manifests().UpdateDefinition(u.ManifestBytes, container.Name, newImageID)
+				newImageID := currentImageID.WithNewTag(change.ImageID.Tag).WithDigest(change.ImageID.Digest)
+				err = files.updateDefinition(rc.Manifests(), u, container.Name, newImageID)
 				if err != nil {
 					return nil, err
 				}
@@ -131,14 +143,20 @@ func (a *Automated) calculateImageUpdates(rc ReleaseContext, candidates []*Contr
 			}
 		}
 
-		if len(containerUpdates) > 0 {
+		switch {
+		case len(containerUpdates) > 0:
 			u.Updates = containerUpdates
 			updates = append(updates, u)
 			result[u.ResourceID] = ControllerResult{
 				Status:       ReleaseStatusSuccess,
 				PerContainer: containerUpdates,
 			}
-		} else {
+		case containerLocked:
+			result[u.ResourceID] = ControllerResult{
+				Status: ReleaseStatusSkipped,
+				Error:  ContainersLocked,
+			}
+		default:
 			result[u.ResourceID] = ControllerResult{
 				Status: ReleaseStatusIgnored,
 				Error:  DoesNotUseImage,
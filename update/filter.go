@@ -6,19 +6,30 @@ import (
 )
 
 const (
-	Locked          = "locked"
-	NotIncluded     = "not included"
-	Excluded        = "excluded"
-	DifferentImage  = "a different image"
-	NotInCluster    = "not running in cluster"
-	NotInRepo       = "not found in repository"
-	ImageNotFound   = "cannot find one or more images"
-	ImageUpToDate   = "image(s) up to date"
-	DoesNotUseImage = "does not use image(s)"
+	Locked               = "locked"
+	NotIncluded          = "not included"
+	Excluded             = "excluded"
+	DifferentImage       = "a different image"
+	NotInCluster         = "not running in cluster"
+	NotInRepo            = "not found in repository"
+	ImageNotFound        = "cannot find one or more images"
+	ImageUpToDate        = "image(s) up to date"
+	DoesNotUseImage      = "does not use image(s)"
+	NotInSource          = "no corresponding controller to promote from"
+	NoContainersInSource = "no containers in common with source"
+	ContainersLocked     = "container(s) locked"
 )
 
+// LabelFilter is implemented by filters that look at the labels in
+// controllers' manifests, so that the labels can be filled in before
+// filtering.
+type LabelFilter interface {
+	ControllerFilter
+	NeedsLabels() bool
+}
+
 type SpecificImageFilter struct {
-	Img image.Ref
+	Imgs []image.Ref
 }
 
 func (f *SpecificImageFilter) Filter(u ControllerUpdate) ControllerResult {
@@ -32,10 +43,12 @@ func (f *SpecificImageFilter) Filter(u ControllerUpdate) ControllerResult {
 	// For each container in update
 	for _, c := range u.Controller.Containers.Containers {
 		cID, _ := image.ParseRef(c.Image)
-		// If container image == image in update
-		if cID.CanonicalName() == f.Img.CanonicalName() {
-			// We want to update this
-			return ControllerResult{}
+		// If container image == any image in update
+		for _, img := range f.Imgs {
+			if cID.CanonicalName() == img.CanonicalName() {
+				// We want to update this
+				return ControllerResult{}
+			}
 		}
 	}
 	return ControllerResult{
@@ -76,6 +89,50 @@ func (f *IncludeFilter) Filter(u ControllerUpdate) ControllerResult {
 	}
 }
 
+// SelectorFilter includes the controllers with any of the IDs given,
+// or picked out by any of the selectors given.
+type SelectorFilter struct {
+	IDs       []flux.ResourceID
+	Selectors []ResourceSelector
+}
+
+func (f *SelectorFilter) Filter(u ControllerUpdate) ControllerResult {
+	for _, id := range f.IDs {
+		if u.ResourceID == id {
+			return ControllerResult{}
+		}
+	}
+	for _, sel := range f.Selectors {
+		if sel.Matches(u.ResourceID, u.Labels) {
+			return ControllerResult{}
+		}
+	}
+	return ControllerResult{
+		Status: ReleaseStatusIgnored,
+		Error:  NotIncluded,
+	}
+}
+
+// NeedsLabels says the filter looks at the labels of controllers;
+// see LabelFilter.
+func (f *SelectorFilter) NeedsLabels() bool {
+	return true
+}
+
+type NamespaceFilter struct {
+	Namespace string
+}
+
+func (f *NamespaceFilter) Filter(u ControllerUpdate) ControllerResult {
+	if ns, _, _ := u.ResourceID.Components(); ns == f.Namespace {
+		return ControllerResult{}
+	}
+	return ControllerResult{
+		Status: ReleaseStatusIgnored,
+		Error:  NotIncluded,
+	}
+}
+
 type LockedFilter struct {
 	IDs []flux.ResourceID
 }
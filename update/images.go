@@ -2,15 +2,15 @@ package update
 
 import (
 	"fmt"
-	"strings"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
-	glob "github.com/ryanuber/go-glob"
 
 	"github.com/weaveworks/flux/cluster"
 	fluxerr "github.com/weaveworks/flux/errors"
 	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/policy"
 	"github.com/weaveworks/flux/registry"
 )
 
@@ -21,25 +21,60 @@ type ImageMap struct {
 }
 
 // LatestImage returns the latest releasable image for a repository for
-// which the tag matches a given pattern. A releasable image is one that
-// is not tagged "latest". (Assumes the available images are in
-// descending order of latestness.) If no such image exists, returns nil,
+// which the tag matches a given pattern. What counts as "latest" is
+// decided by the pattern; e.g., for a glob it's the most recently
+// created image, and for a semver constraint it's the highest version.
+// A releasable image is one that is not tagged "latest", unless that's
+// what the pattern asks for. If no such image exists, returns nil,
 // and the caller can decide whether that's an error or not.
-func (m ImageMap) LatestImage(repo image.Name, tagGlob string) *image.Info {
-	for _, available := range m.images[repo.CanonicalName()] {
-		tag := available.ID.Tag
-		// Ignore latest if and only if it's not what the user wants.
-		if !strings.EqualFold(tagGlob, "latest") && strings.EqualFold(tag, "latest") {
+func (m ImageMap) LatestImage(repo image.Name, pattern policy.Pattern) *image.Info {
+	latest, _ := m.LatestImageCreatedBefore(repo, pattern, time.Time{})
+	return latest
+}
+
+// LatestImageCreatedBefore returns the latest releasable image, as
+// LatestImage does, but considering only images created before the
+// cutoff given (unless it's zero). Images with no creation time are
+// not considered, since it's not known if they are old enough.
+//
+// The images passed over for being too new, that would otherwise be
+// preferred to the image returned, are also returned, so the caller
+// can look again when they are old enough.
+func (m ImageMap) LatestImageCreatedBefore(repo image.Name, pattern policy.Pattern, cutoff time.Time) (*image.Info, []image.Info) {
+	var latest *image.Info
+	var tooNew []image.Info
+	for i := range m.images[repo.CanonicalName()] {
+		available := m.images[repo.CanonicalName()][i]
+		if !pattern.Matches(available.ID.Tag) {
 			continue
 		}
-		if glob.Glob(tagGlob, tag) {
-			var im image.Info
-			im = available
-			im.ID = repo.ToRef(tag)
-			return &im
+		if !cutoff.IsZero() && (available.CreatedAt.IsZero() || !available.CreatedAt.Before(cutoff)) {
+			if !available.CreatedAt.IsZero() {
+				tooNew = append(tooNew, available)
+			}
+			continue
+		}
+		// Ties go to the image that comes first, since the available
+		// images are in descending order of creation.
+		if latest == nil || pattern.Newer(&available, latest) {
+			latest = &available
 		}
 	}
-	return nil
+
+	var passedOver []image.Info
+	for i := range tooNew {
+		if latest == nil || pattern.Newer(&tooNew[i], latest) {
+			tooNew[i].ID = repo.ToRef(tooNew[i].ID.Tag).WithDigest(tooNew[i].ID.Digest)
+			passedOver = append(passedOver, tooNew[i])
+		}
+	}
+	if latest == nil {
+		return nil, passedOver
+	}
+	var im image.Info
+	im = *latest
+	im.ID = repo.ToRef(latest.ID.Tag).WithDigest(latest.ID.Digest)
+	return &im, passedOver
 }
 
 // Available returns image.Info entries for all the images in the
@@ -49,7 +84,7 @@ func (m ImageMap) Available(repo image.Name) []image.Info {
 		infos := make([]image.Info, len(canon))
 		for i := range canon {
 			infos[i] = canon[i]
-			infos[i].ID = repo.ToRef(infos[i].ID.Tag)
+			infos[i].ID = repo.ToRef(infos[i].ID.Tag).WithDigest(infos[i].ID.Digest)
 		}
 		return infos
 	}
@@ -99,24 +134,44 @@ func exactImages(reg registry.Registry, images []image.Ref) (ImageMap, error) {
 	m := infoMap{}
 	for _, id := range images {
 		// We must check that the exact images requested actually exist. Otherwise we risk pushing invalid images to git.
-		exist, err := imageExists(reg, id)
+		info, err := reg.GetImage(id)
 		if err != nil {
-			return ImageMap{}, errors.Wrap(image.ErrInvalidImageID, err.Error())
-		}
-		if !exist {
 			return ImageMap{}, errors.Wrap(image.ErrInvalidImageID, fmt.Sprintf("image %q does not exist", id))
 		}
-		m[id.CanonicalName()] = []image.Info{{ID: id}}
+		// Keep the digest, if one was given, since that's what was
+		// asked for; otherwise, note the digest of the image now at
+		// the tag, in case it's to be pinned.
+		m[id.CanonicalName()] = []image.Info{{ID: id, Digest: info.Digest}}
 	}
 	return ImageMap{m}, nil
 }
 
-// Checks whether the given image exists in the repository.
-// Return true if exist, false otherwise
-func imageExists(reg registry.Registry, imageID image.Ref) (bool, error) {
-	_, err := reg.GetImage(imageID)
-	if err != nil {
-		return false, nil
+// TargetImage returns the image a container should be changed to, to
+// release the image given. The new image is written in the form the
+// current image appears in the manifest. It is pinned by digest if
+// the image given was itself pinned, or if pin is true. If pin is
+// false and the current image is already pinned to the image given
+// (or its digest isn't known), the current image is kept as it is,
+// so that an image pinned by hand isn't unpinned.
+func TargetImage(current image.Ref, latest *image.Info, pin bool) (image.Ref, error) {
+	target := current.WithNewTag(latest.ID.Tag)
+	switch {
+	case latest.ID.Digest != "":
+		return target.WithDigest(latest.ID.Digest), nil
+	case !pin && current.Digest != "" && current.Tag == latest.ID.Tag && (latest.Digest == "" || latest.Digest == current.Digest):
+		return current, nil
+	case !pin:
+		return target, nil
+	case latest.Digest == "":
+		return image.Ref{}, fmt.Errorf("cannot pin image %s by digest, since its digest is not known", latest.ID)
+	default:
+		return target.WithDigest(latest.Digest), nil
 	}
-	return true, nil
+}
+
+// PinDigest reports whether images should be pinned by digest, for a
+// controller with the policies given. Images are pinned if that's the
+// default, or if the controller has the pin_digest policy.
+func PinDigest(byDefault bool, policies policy.Set) bool {
+	return byDefault || policies.Contains(policy.PinDigest)
 }
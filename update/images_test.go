@@ -0,0 +1,141 @@
+package update
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/policy"
+)
+
+func mustParseName(im string) image.Name {
+	ref, err := image.ParseRef(im)
+	if err != nil {
+		panic(err)
+	}
+	return ref.Name
+}
+
+func TestLatestImage(t *testing.T) {
+	name := mustParseName("index.docker.io/weaveworks/helloworld")
+	infos := []image.Info{
+		{ID: name.ToRef("latest"), CreatedAt: time.Now()},
+		{ID: name.ToRef("2.0.0-rc.1"), CreatedAt: time.Now().Add(-time.Minute)},
+		{ID: name.ToRef("1.9.0"), CreatedAt: time.Now().Add(-time.Hour)},
+		{ID: name.ToRef("1.10.0"), CreatedAt: time.Now().Add(-2 * time.Hour)},
+		{ID: name.ToRef("master-a000001"), CreatedAt: time.Now().Add(-3 * time.Hour)},
+	}
+	m := ImageMap{infoMap{name.CanonicalName(): infos}}
+
+	for _, x := range []struct {
+		pattern string
+		want    string
+	}{
+		{"glob:*", "2.0.0-rc.1"},
+		{"glob:latest", "latest"},
+		{"glob:master-*", "master-a000001"},
+		{"glob:nomatch-*", ""},
+		{"semver:*", "1.10.0"},
+		{"semver:~1.9", "1.9.0"},
+		{"semver-prerelease:*", "2.0.0-rc.1"},
+		{"semver-prerelease:~1.9", "1.9.0"},
+		{"regex:^[0-9.]+$", "1.9.0"},
+		{"regex:^1\\.(?P<minor>[0-9]+)\\.0$", "1.10.0"},
+	} {
+		pattern, err := policy.ParsePattern(x.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest := m.LatestImage(name, pattern)
+		switch {
+		case latest == nil && x.want != "":
+			t.Errorf("pattern %q: expected %q, got no image", x.pattern, x.want)
+		case latest != nil && latest.ID.Tag != x.want:
+			t.Errorf("pattern %q: expected %q, got %q", x.pattern, x.want, latest.ID.Tag)
+		}
+	}
+}
+
+func TestTargetImage(t *testing.T) {
+	const (
+		oldDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		newDigest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+	name := mustParseName("weaveworks/helloworld")
+	current := name.ToRef("1.0")
+	pinned := current.WithDigest(oldDigest)
+
+	for _, x := range []struct {
+		name    string
+		current image.Ref
+		latest  image.Info
+		pin     bool
+		want    string
+	}{
+		{"not pinned", current, image.Info{ID: name.ToRef("1.1"), Digest: newDigest}, false, "weaveworks/helloworld:1.1"},
+		{"pinned", current, image.Info{ID: name.ToRef("1.1"), Digest: newDigest}, true, "weaveworks/helloworld:1.1@" + newDigest},
+		{"retagged", pinned, image.Info{ID: name.ToRef("1.0"), Digest: newDigest}, true, "weaveworks/helloworld:1.0@" + newDigest},
+		{"unpinned", pinned, image.Info{ID: name.ToRef("1.0"), Digest: newDigest}, false, "weaveworks/helloworld:1.0"},
+		{"pinned by hand", pinned, image.Info{ID: name.ToRef("1.0"), Digest: oldDigest}, false, "weaveworks/helloworld:1.0@" + oldDigest},
+		{"pinned by hand, digest not known", pinned, image.Info{ID: name.ToRef("1.0")}, false, "weaveworks/helloworld:1.0@" + oldDigest},
+		{"pinned by hand, new tag", pinned, image.Info{ID: name.ToRef("1.1"), Digest: newDigest}, false, "weaveworks/helloworld:1.1"},
+		{"given digest", current, image.Info{ID: name.ToRef("1.1").WithDigest(oldDigest), Digest: newDigest}, false, "weaveworks/helloworld:1.1@" + oldDigest},
+		{"no digest known", current, image.Info{ID: name.ToRef("1.1")}, true, ""},
+	} {
+		target, err := TargetImage(x.current, &x.latest, x.pin)
+		switch {
+		case x.want == "" && err == nil:
+			t.Errorf("%s: expected error, got %s", x.name, target)
+		case x.want != "" && err != nil:
+			t.Errorf("%s: %s", x.name, err)
+		case x.want != "" && target.String() != x.want:
+			t.Errorf("%s: expected %s, got %s", x.name, x.want, target)
+		}
+	}
+}
+
+func TestLatestImageCreatedBefore(t *testing.T) {
+	now := time.Now()
+	name := mustParseName("index.docker.io/weaveworks/helloworld")
+	infos := []image.Info{
+		{ID: name.ToRef("1.3.0"), CreatedAt: now.Add(-time.Minute)},
+		{ID: name.ToRef("1.2.0"), CreatedAt: now.Add(-10 * time.Minute)},
+		{ID: name.ToRef("1.1.0"), CreatedAt: now.Add(-time.Hour)},
+		{ID: name.ToRef("1.0.0"), CreatedAt: now.Add(-2 * time.Hour)},
+		{ID: name.ToRef("0.9.0")},
+	}
+	m := ImageMap{infoMap{name.CanonicalName(): infos}}
+
+	for _, x := range []struct {
+		pattern string
+		cutoff  time.Time
+		want    string
+		tooNew  []string
+	}{
+		{"semver:*", time.Time{}, "1.3.0", nil},
+		{"semver:*", now.Add(-30 * time.Minute), "1.1.0", []string{"1.3.0", "1.2.0"}},
+		{"semver:~1.2", now.Add(-30 * time.Minute), "", []string{"1.2.0"}},
+		{"semver:<1.2", now.Add(-30 * time.Minute), "1.1.0", nil},
+		{"semver:~0.9", now, "", nil},
+	} {
+		pattern, err := policy.ParsePattern(x.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest, tooNew := m.LatestImageCreatedBefore(name, pattern, x.cutoff)
+		switch {
+		case latest == nil && x.want != "":
+			t.Errorf("pattern %q: expected %q, got no image", x.pattern, x.want)
+		case latest != nil && latest.ID.Tag != x.want:
+			t.Errorf("pattern %q: expected %q, got %q", x.pattern, x.want, latest.ID.Tag)
+		}
+		var tags []string
+		for _, im := range tooNew {
+			tags = append(tags, im.ID.Tag)
+		}
+		if !reflect.DeepEqual(tags, x.tooNew) {
+			t.Errorf("pattern %q: expected %v to be too new, got %v", x.pattern, x.tooNew, tags)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package update
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/policy"
+)
+
+var (
+	ErrInvalidPromoteScope = errors.New("invalid promotion source or target; expected a namespace or a controller ID")
+
+	namespaceRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+)
+
+// PromoteScope is either a namespace, in which case all controllers
+// in the namespace are included, or a single controller ID.
+type PromoteScope string
+
+func ParsePromoteScope(s string) (PromoteScope, error) {
+	if namespaceRegexp.MatchString(s) {
+		return PromoteScope(s), nil
+	}
+	id, err := flux.ParseResourceID(s)
+	if err != nil {
+		return "", errors.Wrap(ErrInvalidPromoteScope, err.Error())
+	}
+	return PromoteScope(id.String()), nil
+}
+
+// AsID returns the controller ID, if the scope is a single
+// controller.
+func (s PromoteScope) AsID() (flux.ResourceID, bool) {
+	if namespaceRegexp.MatchString(string(s)) {
+		return flux.ResourceID{}, false
+	}
+	id, err := flux.ParseResourceID(string(s))
+	return id, err == nil
+}
+
+func (s PromoteScope) String() string {
+	return string(s)
+}
+
+func (s PromoteScope) filter() ControllerFilter {
+	if id, ok := s.AsID(); ok {
+		return &IncludeFilter{[]flux.ResourceID{id}}
+	}
+	return &NamespaceFilter{string(s)}
+}
+
+// PromoteSpec is a release that copies the images running in one set
+// of controllers -- e.g., those in a staging namespace -- to the
+// corresponding controllers in another. If the source and target are
+// namespaces, controllers correspond if they have the same kind and
+// name; if they are controller IDs, the target gets the images from
+// the source.
+type PromoteSpec struct {
+	From     PromoteScope
+	To       PromoteScope
+	Kind     ReleaseKind
+	Excludes []flux.ResourceID
+}
+
+func (s PromoteSpec) ReleaseType() ReleaseType {
+	return "promote"
+}
+
+func (s PromoteSpec) ReleaseKind() ReleaseKind {
+	return s.Kind
+}
+
+func (s PromoteSpec) CommitMessage() string {
+	return fmt.Sprintf("Promote %s to %s", s.From, s.To)
+}
+
+func (s PromoteSpec) CalculateRelease(rc ReleaseContext, logger log.Logger) ([]*ControllerUpdate, Result, error) {
+	if err := s.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	// The images to promote are those running in the source
+	// controllers; we don't report on the source controllers, so
+	// their results go to waste.
+	timer := NewStageTimer("select_services")
+	sources, err := rc.SelectServices(Result{}, s.From.filter())
+	if err != nil {
+		timer.ObserveDuration()
+		return nil, nil, err
+	}
+
+	results := Result{}
+	filters, err := s.filters(rc)
+	if err != nil {
+		timer.ObserveDuration()
+		return nil, nil, err
+	}
+	targets, err := rc.SelectServices(results, filters...)
+	timer.ObserveDuration()
+	if err != nil {
+		return nil, nil, err
+	}
+	if id, ok := s.To.AsID(); ok {
+		if _, ok := results[id]; !ok {
+			results[id] = ControllerResult{
+				Status: ReleaseStatusSkipped,
+				Error:  NotInRepo,
+			}
+		}
+	}
+
+	timer = NewStageTimer("lookup_images")
+	updates, err := s.calculateImageUpdates(rc, sources, targets, results)
+	timer.ObserveDuration()
+	if err != nil {
+		return nil, nil, err
+	}
+	return updates, results, nil
+}
+
+func (s PromoteSpec) validate() error {
+	_, fromID := s.From.AsID()
+	_, toID := s.To.AsID()
+	switch {
+	case fromID != toID:
+		return errors.Wrap(ErrInvalidPromoteScope, "source and target must both be namespaces, or both be controllers")
+	case s.From == s.To:
+		return errors.Wrap(ErrInvalidPromoteScope, "source and target are the same")
+	}
+	return nil
+}
+
+func (s PromoteSpec) filters(rc ReleaseContext) ([]ControllerFilter, error) {
+	filtList := []ControllerFilter{s.To.filter()}
+	if len(s.Excludes) > 0 {
+		filtList = append(filtList, &ExcludeFilter{s.Excludes})
+	}
+	services, err := rc.ServicesWithPolicies()
+	if err != nil {
+		return nil, err
+	}
+	lockedSet := services.OnlyWithPolicy(policy.Locked)
+	filtList = append(filtList, &LockedFilter{lockedSet.ToSlice()})
+	return filtList, nil
+}
+
+// source finds the source controller corresponding to the target
+// given, if there is one.
+func (s PromoteSpec) source(sources []*ControllerUpdate, target flux.ResourceID) *ControllerUpdate {
+	if _, ok := s.To.AsID(); ok {
+		if len(sources) == 1 {
+			return sources[0]
+		}
+		return nil
+	}
+	_, kind, name := target.Components()
+	for _, u := range sources {
+		if _, k, n := u.ResourceID.Components(); k == kind && n == name {
+			return u
+		}
+	}
+	return nil
+}
+
+// Set each container in the targets to the image used by the
+// same-named container in the corresponding source, and do the
+// replacements.
+func (s PromoteSpec) calculateImageUpdates(rc ReleaseContext, sources, targets []*ControllerUpdate, results Result) ([]*ControllerUpdate, error) {
+	policies, err := rc.ServicesWithPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []*ControllerUpdate
+	files := manifestFiles{}
+	for _, u := range targets {
+		source := s.source(sources, u.ResourceID)
+		if source == nil {
+			results[u.ResourceID] = ControllerResult{
+				Status: ReleaseStatusSkipped,
+				Error:  NotInSource,
+			}
+			continue
+		}
+
+		containers, err := u.Controller.ContainersOrError()
+		if err != nil {
+			results[u.ResourceID] = ControllerResult{
+				Status: ReleaseStatusFailed,
+				Error:  err.Error(),
+			}
+			continue
+		}
+		sourceContainers, err := source.Controller.ContainersOrError()
+		if err != nil {
+			results[u.ResourceID] = ControllerResult{
+				Status: ReleaseStatusFailed,
+				Error:  errors.Wrapf(err, "source %s", source.ResourceID).Error(),
+			}
+			continue
+		}
+		sourceImages := map[string]string{}
+		for _, c := range sourceContainers {
+			sourceImages[c.Name] = c.Image
+		}
+
+		var upToDate, containerLocked bool
+		var containerUpdates []ContainerUpdate
+		for _, container := range containers {
+			sourceImage, ok := sourceImages[container.Name]
+			if !ok {
+				continue
+			}
+			currentImageID, err := image.ParseRef(container.Image)
+			if err != nil {
+				return nil, err
+			}
+			sourceImageID, err := image.ParseRef(sourceImage)
+			if err != nil {
+				return nil, err
+			}
+
+			if currentImageID.CanonicalRef() == sourceImageID.CanonicalRef() {
+				upToDate = true
+				continue
+			}
+			if policy.IsContainerLocked(policies[u.ResourceID], container.Name) {
+				containerLocked = true
+				continue
+			}
+
+			// Keep the form the image appears in the manifest, if it's
+			// the same image repository; but keep the digest of the
+			// source image, if it's pinned.
+			newImageID := sourceImageID
+			if currentImageID.CanonicalName() == sourceImageID.CanonicalName() {
+				newImageID = currentImageID.WithNewTag(sourceImageID.Tag).WithDigest(sourceImageID.Digest)
+			}
+
+			err = files.updateDefinition(rc.Manifests(), u, container.Name, newImageID)
+			if err != nil {
+				return nil, err
+			}
+
+			containerUpdates = append(containerUpdates, ContainerUpdate{
+				Container: container.Name,
+				Current:   currentImageID,
+				Target:    newImageID,
+			})
+		}
+
+		switch {
+		case len(containerUpdates) > 0:
+			u.Updates = containerUpdates
+			updates = append(updates, u)
+			results[u.ResourceID] = ControllerResult{
+				Status:       ReleaseStatusSuccess,
+				PerContainer: containerUpdates,
+			}
+		case containerLocked:
+			results[u.ResourceID] = ControllerResult{
+				Status: ReleaseStatusSkipped,
+				Error:  ContainersLocked,
+			}
+		case upToDate:
+			results[u.ResourceID] = ControllerResult{
+				Status: ReleaseStatusSkipped,
+				Error:  ImageUpToDate,
+			}
+		default:
+			results[u.ResourceID] = ControllerResult{
+				Status: ReleaseStatusSkipped,
+				Error:  NoContainersInSource,
+			}
+		}
+	}
+	return updates, nil
+}
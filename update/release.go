@@ -21,6 +21,7 @@ const (
 
 var (
 	ErrInvalidReleaseKind = errors.New("invalid release kind")
+	ErrInvalidImageSpecs  = errors.New("invalid image specs")
 )
 
 // ReleaseKind says whether a release is to be planned only, or planned then executed
@@ -50,22 +51,84 @@ type ReleaseContext interface {
 	ServicesWithPolicies() (policy.ResourceMap, error)
 	Registry() registry.Registry
 	Manifests() cluster.Manifests
+	// PinDigests reports whether images should be pinned by digest
+	// in all controllers, rather than just those with the
+	// pin_digest policy.
+	PinDigests() bool
 }
 
 // NB: these get sent from fluxctl, so we have to maintain the json format of
 // this. Eugh.
+//
+// To release more than one image at a time, put them all in
+// ImageSpecs and leave ImageSpec empty; that way, a daemon that
+// doesn't know about ImageSpecs and gets the spec over RPC will refuse
+// the release rather than do just part of it. (Over HTTP, such
+// releases go to a route older daemons don't have.) Use Images() to
+// get the images regardless of which field they are in.
 type ReleaseSpec struct {
 	ServiceSpecs []ResourceSpec
 	ImageSpec    ImageSpec
+	ImageSpecs   []ImageSpec `json:",omitempty"`
 	Kind         ReleaseKind
 	Excludes     []flux.ResourceID
+	// SavePlan asks for a dry-run release to be kept, so it can be
+	// applied later by its ID.
+	SavePlan bool `json:",omitempty"`
+}
+
+// ReleaseImages returns the release image field(s) for the image
+// specs given, so they can be used in a ReleaseSpec in the most
+// widely understood form.
+func ReleaseImages(images ...ImageSpec) (ImageSpec, []ImageSpec) {
+	if len(images) == 1 {
+		return images[0], nil
+	}
+	return "", images
+}
+
+// Images returns all the images to be released.
+func (s ReleaseSpec) Images() []ImageSpec {
+	if len(s.ImageSpecs) > 0 {
+		return s.ImageSpecs
+	}
+	return []ImageSpec{s.ImageSpec}
+}
+
+// IsLatest reports whether the release is of the latest images,
+// rather than specific images.
+func (s ReleaseSpec) IsLatest() bool {
+	images := s.Images()
+	return len(images) == 1 && images[0] == ImageSpecLatest
+}
+
+// imageRefs returns the specific images to be released, and checks
+// they make sense together.
+func (s ReleaseSpec) imageRefs() ([]image.Ref, error) {
+	var refs []image.Ref
+	repos := map[image.CanonicalName]struct{}{}
+	for _, spec := range s.Images() {
+		if spec == ImageSpecLatest {
+			return nil, errors.Wrap(ErrInvalidImageSpecs, "cannot release latest images along with specific images")
+		}
+		ref, err := spec.AsRef()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := repos[ref.CanonicalName()]; ok {
+			return nil, errors.Wrapf(ErrInvalidImageSpecs, "more than one image given for %s", ref.Name)
+		}
+		repos[ref.CanonicalName()] = struct{}{}
+		refs = append(refs, ref)
+	}
+	return refs, nil
 }
 
 // ReleaseType gives a one-word description of the release, mainly
 // useful for labelling metrics or log messages.
 func (s ReleaseSpec) ReleaseType() ReleaseType {
 	switch {
-	case s.ImageSpec == ImageSpecLatest:
+	case s.IsLatest():
 		return "latest_images"
 	default:
 		return "specific_image"
@@ -96,12 +159,15 @@ func (s ReleaseSpec) ReleaseKind() ReleaseKind {
 }
 
 func (s ReleaseSpec) CommitMessage() string {
-	image := strings.Trim(s.ImageSpec.String(), "<>")
+	var images []string
+	for _, spec := range s.Images() {
+		images = append(images, strings.Trim(spec.String(), "<>"))
+	}
 	var services []string
 	for _, spec := range s.ServiceSpecs {
-		services = append(services, strings.Trim(spec.String(), "<>"))
+		services = append(services, spec.Description())
 	}
-	return fmt.Sprintf("Release %s to %s", image, strings.Join(services, ", "))
+	return fmt.Sprintf("Release %s to %s", strings.Join(images, ", "), strings.Join(services, ", "))
 }
 
 // Take the spec given in the job, and figure out which services are
@@ -124,29 +190,40 @@ func (s ReleaseSpec) selectServices(rc ReleaseContext, results Result) ([]*Contr
 func (s ReleaseSpec) filters(rc ReleaseContext) ([]ControllerFilter, error) {
 	// Image filter
 	var filtList []ControllerFilter
-	if s.ImageSpec != ImageSpecLatest {
-		id, err := image.ParseRef(s.ImageSpec.String())
+	if !s.IsLatest() {
+		ids, err := s.imageRefs()
 		if err != nil {
 			return nil, err
 		}
-		filtList = append(filtList, &SpecificImageFilter{id})
+		filtList = append(filtList, &SpecificImageFilter{ids})
 	}
 
 	// Service filter
 	ids := []flux.ResourceID{}
+	var selectors []ResourceSelector
 	for _, s := range s.ServiceSpecs {
 		if s == ResourceSpecAll {
 			// "<all>" Overrides any other filters
-			ids = []flux.ResourceID{}
+			ids, selectors = []flux.ResourceID{}, nil
 			break
 		}
+		if sel, ok, err := parseSelectorSpec(string(s)); ok {
+			if err != nil {
+				return nil, err
+			}
+			selectors = append(selectors, sel)
+			continue
+		}
 		id, err := flux.ParseResourceID(string(s))
 		if err != nil {
 			return nil, err
 		}
 		ids = append(ids, id)
 	}
-	if len(ids) > 0 {
+	switch {
+	case len(selectors) > 0:
+		filtList = append(filtList, &SelectorFilter{IDs: ids, Selectors: selectors})
+	case len(ids) > 0:
 		filtList = append(filtList, &IncludeFilter{ids})
 	}
 
@@ -192,18 +269,20 @@ func (s ReleaseSpec) markSkipped(results Result) {
 func (s ReleaseSpec) calculateImageUpdates(rc ReleaseContext, candidates []*ControllerUpdate, results Result, logger log.Logger) ([]*ControllerUpdate, error) {
 	// Compile an `ImageMap` of all relevant images
 	var images ImageMap
-	var singleRepo image.CanonicalName
+	releaseRepos := map[image.CanonicalName]bool{}
 	var err error
 
-	switch s.ImageSpec {
-	case ImageSpecLatest:
+	switch {
+	case s.IsLatest():
 		images, err = collectUpdateImages(rc.Registry(), candidates, logger)
 	default:
-		var ref image.Ref
-		ref, err = s.ImageSpec.AsRef()
+		var refs []image.Ref
+		refs, err = s.imageRefs()
 		if err == nil {
-			singleRepo = ref.CanonicalName()
-			images, err = exactImages(rc.Registry(), []image.Ref{ref})
+			for _, ref := range refs {
+				releaseRepos[ref.CanonicalName()] = true
+			}
+			images, err = exactImages(rc.Registry(), refs)
 		}
 	}
 
@@ -211,9 +290,15 @@ func (s ReleaseSpec) calculateImageUpdates(rc ReleaseContext, candidates []*Cont
 		return nil, err
 	}
 
+	policies, err := rc.ServicesWithPolicies()
+	if err != nil {
+		return nil, err
+	}
+
 	// Look through all the services' containers to see which have an
 	// image that could be updated.
 	var updates []*ControllerUpdate
+	files := manifestFiles{}
 	for _, u := range candidates {
 		containers, err := u.Controller.ContainersOrError()
 		if err != nil {
@@ -229,6 +314,7 @@ func (s ReleaseSpec) calculateImageUpdates(rc ReleaseContext, candidates []*Cont
 		// for the purpose of filtering the output.
 		ignoredOrSkipped := ReleaseStatusIgnored
 		var containerUpdates []ContainerUpdate
+		var containerLocked bool
 
 		for _, container := range containers {
 			currentImageID, err := image.ParseRef(container.Image)
@@ -238,9 +324,9 @@ func (s ReleaseSpec) calculateImageUpdates(rc ReleaseContext, candidates []*Cont
 				return nil, err
 			}
 
-			latestImage := images.LatestImage(currentImageID.Name, "*")
+			latestImage := images.LatestImage(currentImageID.Name, policy.PatternAll)
 			if latestImage == nil {
-				if currentImageID.CanonicalName() != singleRepo {
+				if !releaseRepos[currentImageID.CanonicalName()] {
 					ignoredOrSkipped = ReleaseStatusIgnored
 				} else {
 					ignoredOrSkipped = ReleaseStatusUnknown
@@ -248,17 +334,25 @@ func (s ReleaseSpec) calculateImageUpdates(rc ReleaseContext, candidates []*Cont
 				continue
 			}
 
-			if currentImageID == latestImage.ID {
+			// We want to update the image with respect to the form it
+			// appears in the manifest, whereas what we have is the
+			// canonical form. If pinning, images with the same tag but
+			// different digests differ, so a retagged image will be
+			// released.
+			newImageID, err := TargetImage(currentImageID, latestImage, PinDigest(rc.PinDigests(), policies[u.ResourceID]))
+			if err != nil {
+				return nil, err
+			}
+			if currentImageID == newImageID {
 				ignoredOrSkipped = ReleaseStatusSkipped
 				continue
 			}
+			if policy.IsContainerLocked(policies[u.ResourceID], container.Name) {
+				containerLocked = true
+				continue
+			}
 
-			// We want to update the image with respect to the form it
-			// appears in the manifest, whereas what we have is the
-			// canonical form.
-			newImageID := currentImageID.WithNewTag(latestImage.ID.Tag)
-
-			u.ManifestBytes, err = rc.Manifests().UpdateDefinition(u.ManifestBytes, container.Name, newImageID)
+			err = files.updateDefinition(rc.Manifests(), u, container.Name, newImageID)
 			if err != nil {
 				return nil, err
 			}
@@ -278,6 +372,11 @@ func (s ReleaseSpec) calculateImageUpdates(rc ReleaseContext, candidates []*Cont
 				Status:       ReleaseStatusSuccess,
 				PerContainer: containerUpdates,
 			}
+		case containerLocked:
+			results[u.ResourceID] = ControllerResult{
+				Status: ReleaseStatusSkipped,
+				Error:  ContainersLocked,
+			}
 		case ignoredOrSkipped == ReleaseStatusSkipped:
 			results[u.ResourceID] = ControllerResult{
 				Status: ReleaseStatusSkipped,
@@ -299,12 +398,20 @@ func (s ReleaseSpec) calculateImageUpdates(rc ReleaseContext, candidates []*Cont
 	return updates, nil
 }
 
-type ResourceSpec string // ResourceID or "<all>"
+// ResourceSpec is a ResourceID, "<all>", or a selector (see
+// MakeSelectorSpec).
+type ResourceSpec string
 
 func ParseResourceSpec(s string) (ResourceSpec, error) {
 	if s == string(ResourceSpecAll) {
 		return ResourceSpecAll, nil
 	}
+	if sel, ok, err := parseSelectorSpec(s); ok {
+		if err != nil {
+			return "", errors.Wrap(err, "invalid service spec")
+		}
+		return MakeSelectorSpec(sel), nil
+	}
 	id, err := flux.ParseResourceID(s)
 	if err != nil {
 		return "", errors.Wrap(err, "invalid service spec")
@@ -320,6 +427,27 @@ func (s ResourceSpec) AsID() (flux.ResourceID, error) {
 	return flux.ParseResourceID(string(s))
 }
 
+// AsSelector returns the selector, if the spec is a selector.
+func (s ResourceSpec) AsSelector() (ResourceSelector, bool) {
+	sel, ok, err := parseSelectorSpec(string(s))
+	return sel, ok && err == nil
+}
+
+// IsSelector reports whether the spec is a selector, whether or not
+// it's a valid one.
+func (s ResourceSpec) IsSelector() bool {
+	_, ok, _ := parseSelectorSpec(string(s))
+	return ok
+}
+
+// Description gives the spec in words, e.g., for commit messages.
+func (s ResourceSpec) Description() string {
+	if sel, ok := s.AsSelector(); ok {
+		return sel.Description()
+	}
+	return strings.Trim(s.String(), "<>")
+}
+
 func (s ResourceSpec) String() string {
 	return string(s)
 }
@@ -0,0 +1,161 @@
+package update
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+)
+
+var (
+	ErrInvalidLabelSelector = errors.New("invalid label selector; expected comma-separated <key>=<value> or <key>!=<value>")
+
+	labelKeyRegexp   = regexp.MustCompile("^[a-zA-Z0-9]([a-zA-Z0-9._/-]*[a-zA-Z0-9])?$")
+	labelValueRegexp = regexp.MustCompile("^([a-zA-Z0-9]([a-zA-Z0-9._-]*[a-zA-Z0-9])?)?$")
+)
+
+// LabelRequirement is a single clause of a label selector: that the
+// label with the key given has the value given, or (if NotEqual) that
+// it doesn't.
+type LabelRequirement struct {
+	Key      string
+	Value    string
+	NotEqual bool
+}
+
+func (r LabelRequirement) String() string {
+	if r.NotEqual {
+		return r.Key + "!=" + r.Value
+	}
+	return r.Key + "=" + r.Value
+}
+
+// LabelSelector matches resources with labels that meet all of its
+// requirements. This is a subset of the Kubernetes label selector
+// syntax, i.e., only the equality-based requirements.
+type LabelSelector []LabelRequirement
+
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var sel LabelSelector
+	for _, clause := range strings.Split(s, ",") {
+		clause = strings.TrimSpace(clause)
+		var req LabelRequirement
+		var parts []string
+		switch {
+		case strings.Contains(clause, "!="):
+			parts = strings.SplitN(clause, "!=", 2)
+			req.NotEqual = true
+		case strings.Contains(clause, "=="):
+			parts = strings.SplitN(clause, "==", 2)
+		case strings.Contains(clause, "="):
+			parts = strings.SplitN(clause, "=", 2)
+		default:
+			return nil, errors.Wrap(ErrInvalidLabelSelector, s)
+		}
+		req.Key, req.Value = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !labelKeyRegexp.MatchString(req.Key) || !labelValueRegexp.MatchString(req.Value) {
+			return nil, errors.Wrap(ErrInvalidLabelSelector, s)
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+// Matches reports whether the labels given meet the selector's
+// requirements. An absent label is taken to have the empty value.
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		if (labels[req.Key] == req.Value) == req.NotEqual {
+			return false
+		}
+	}
+	return true
+}
+
+func (s LabelSelector) String() string {
+	var clauses []string
+	for _, req := range s {
+		clauses = append(clauses, req.String())
+	}
+	return strings.Join(clauses, ",")
+}
+
+// ResourceSelector picks out controllers by namespace, by label, or
+// both. An empty namespace means all namespaces, and an empty label
+// selector means all labels.
+type ResourceSelector struct {
+	Namespace string
+	Labels    LabelSelector
+}
+
+// Matches reports whether a controller, with the ID and labels given,
+// is selected.
+func (s ResourceSelector) Matches(id flux.ResourceID, labels map[string]string) bool {
+	if ns, _, _ := id.Components(); s.Namespace != "" && ns != s.Namespace {
+		return false
+	}
+	return s.Labels.Matches(labels)
+}
+
+// Description gives the selector in words, e.g., for commit
+// messages.
+func (s ResourceSelector) Description() string {
+	what := "all"
+	if len(s.Labels) > 0 {
+		what = s.Labels.String()
+	}
+	if s.Namespace == "" {
+		return what
+	}
+	return what + " in " + s.Namespace
+}
+
+// MakeSelectorSpec returns the resource spec for a selector. The spec
+// is `<selector>` or `<namespace>:<selector>`, where the selector is
+// either a label selector or `all`; e.g., `<team=payments>`, or
+// `payments:<all>`.
+func MakeSelectorSpec(s ResourceSelector) ResourceSpec {
+	what := "all"
+	if len(s.Labels) > 0 {
+		what = s.Labels.String()
+	}
+	spec := "<" + what + ">"
+	if s.Namespace != "" {
+		spec = s.Namespace + ":" + spec
+	}
+	return ResourceSpec(spec)
+}
+
+// parseSelectorSpec parses the selector forms of a resource spec. It
+// returns false if the spec isn't in one of those forms.
+func parseSelectorSpec(s string) (ResourceSelector, bool, error) {
+	var sel ResourceSelector
+	if s == string(ResourceSpecAll) || !strings.HasSuffix(s, ">") {
+		return sel, false, nil
+	}
+	open := strings.Index(s, "<")
+	switch {
+	case open == 0:
+	case open > 1 && s[open-1] == ':' && namespaceRegexp.MatchString(s[:open-1]):
+		sel.Namespace = s[:open-1]
+	default:
+		return sel, false, nil
+	}
+	what := s[open+1 : len(s)-1]
+	if what == "all" {
+		if sel.Namespace == "" {
+			// This would be the same as <all>, so there's no use in
+			// having two ways to say it.
+			return sel, false, nil
+		}
+		return sel, true, nil
+	}
+	labels, err := ParseLabelSelector(what)
+	if err != nil {
+		return sel, true, err
+	}
+	sel.Labels = labels
+	return sel, true, nil
+}
@@ -3,6 +3,7 @@ package update
 import (
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/cluster"
+	"github.com/weaveworks/flux/image"
 )
 
 type ControllerUpdate struct {
@@ -10,7 +11,32 @@ type ControllerUpdate struct {
 	Controller    cluster.Controller
 	ManifestPath  string
 	ManifestBytes []byte
-	Updates       []ContainerUpdate
+	// Labels are those given in the manifest; they are only filled
+	// in if a filter needs them (see LabelFilter).
+	Labels  map[string]string
+	Updates []ContainerUpdate
+}
+
+// manifestFiles keeps the content of each manifest file as it is
+// updated, so that updates to controllers defined in the same file
+// build on one another.
+type manifestFiles map[string][]byte
+
+// updateDefinition sets the image for the container in the
+// controller's manifest, starting from its file as updated for any
+// other controllers, and keeps the result.
+func (files manifestFiles) updateDefinition(m cluster.Manifests, u *ControllerUpdate, container string, newImageID image.Ref) error {
+	def, ok := files[u.ManifestPath]
+	if !ok {
+		def = u.ManifestBytes
+	}
+	def, err := m.UpdateDefinition(def, u.ResourceID, container, newImageID)
+	if err != nil {
+		return err
+	}
+	u.ManifestBytes = def
+	files[u.ManifestPath] = def
+	return nil
 }
 
 type ControllerFilter interface {
@@ -8,9 +8,11 @@ import (
 )
 
 const (
-	Images = "image"
-	Policy = "policy"
-	Auto   = "auto"
+	Images    = "image"
+	Policy    = "policy"
+	Auto      = "auto"
+	Promote   = "promote"
+	ApplyPlan = "apply_plan"
 )
 
 // How did this update get triggered?
@@ -19,7 +21,7 @@ type Cause struct {
 	User    string
 }
 
-// A tagged union for all (both) kinds of update. The type is just so
+// A tagged union for all kinds of update. The type is just so
 // we know how to decode the rest of the struct.
 type Spec struct {
 	Type  string      `json:"type"`
@@ -27,6 +29,12 @@ type Spec struct {
 	Spec  interface{} `json:"spec"`
 }
 
+// ApplyPlanSpec asks for a release plan, kept from a dry run, to be
+// executed.
+type ApplyPlanSpec struct {
+	ID string
+}
+
 func (spec *Spec) UnmarshalJSON(in []byte) error {
 	var wire struct {
 		Type      string          `json:"type"`
@@ -58,6 +66,18 @@ func (spec *Spec) UnmarshalJSON(in []byte) error {
 			return err
 		}
 		spec.Spec = update
+	case Promote:
+		var update PromoteSpec
+		if err := json.Unmarshal(wire.SpecBytes, &update); err != nil {
+			return err
+		}
+		spec.Spec = update
+	case ApplyPlan:
+		var update ApplyPlanSpec
+		if err := json.Unmarshal(wire.SpecBytes, &update); err != nil {
+			return err
+		}
+		spec.Spec = update
 	default:
 		return errors.New("unknown spec type: " + wire.Type)
 	}
@@ -1,6 +1,12 @@
 package update
 
-import "testing"
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/flux"
+)
 
 func TestParseImageSpec(t *testing.T) {
 	parseSpec(t, "valid/image:tag", false)
@@ -22,3 +28,99 @@ func parseSpec(t *testing.T, image string, expectError bool) {
 		t.Fatalf("Expected string spec %q but got %q", image, string(spec))
 	}
 }
+
+func TestParseResourceSpec(t *testing.T) {
+	for _, v := range []struct {
+		spec        string
+		expected    ResourceSpec
+		isSelector  bool
+		description string
+	}{
+		{"<all>", ResourceSpecAll, false, "all"},
+		{"default:deployment/foo", "default:deployment/foo", false, "default:deployment/foo"},
+		{"payments:<all>", "payments:<all>", true, "all in payments"},
+		{"<team=payments>", "<team=payments>", true, "team=payments"},
+		{"<team == payments, tier!=web>", "<team=payments,tier!=web>", true, "team=payments,tier!=web"},
+		{"payments:<app.kubernetes.io/part-of=shop>", "payments:<app.kubernetes.io/part-of=shop>", true, "app.kubernetes.io/part-of=shop in payments"},
+	} {
+		spec, err := ParseResourceSpec(v.spec)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", v.spec, err)
+			continue
+		}
+		if spec != v.expected {
+			t.Errorf("%q: expected spec %q, got %q", v.spec, v.expected, spec)
+		}
+		if spec.IsSelector() != v.isSelector {
+			t.Errorf("%q: expected selector = %v", v.spec, v.isSelector)
+		}
+		if spec.Description() != v.description {
+			t.Errorf("%q: expected description %q, got %q", v.spec, v.description, spec.Description())
+		}
+	}
+
+	for _, bad := range []string{"", "<>", "<all", ":<all>", "<team>", "<=payments>", "<team=pay ments>", "not a namespace:<all>"} {
+		if _, err := ParseResourceSpec(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestResourceSelectorMatches(t *testing.T) {
+	sel, _ := ResourceSpec("payments:<team=payments,tier!=web>").AsSelector()
+	for _, v := range []struct {
+		id      string
+		labels  map[string]string
+		matches bool
+	}{
+		{"payments:deployment/api", map[string]string{"team": "payments"}, true},
+		{"payments:deployment/api", map[string]string{"team": "payments", "tier": "db"}, true},
+		{"payments:deployment/web", map[string]string{"team": "payments", "tier": "web"}, false},
+		{"default:deployment/api", map[string]string{"team": "payments"}, false},
+		{"payments:deployment/other", nil, false},
+	} {
+		if sel.Matches(flux.MustParseResourceID(v.id), v.labels) != v.matches {
+			t.Errorf("%s %v: expected match = %v", v.id, v.labels, v.matches)
+		}
+	}
+}
+
+func TestReleaseSpecImagesJSON(t *testing.T) {
+	// A release spec as sent by an older fluxctl
+	var old ReleaseSpec
+	if err := json.Unmarshal([]byte(`{"ServiceSpecs":["<all>"],"ImageSpec":"alpine:3.7","Kind":"execute","Excludes":null}`), &old); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(old.Images(), []ImageSpec{"alpine:3.7"}) {
+		t.Errorf("expected single image from old-style spec, got %v", old.Images())
+	}
+
+	// A single image should be sent in the form older daemons understand
+	var single ReleaseSpec
+	single.ImageSpec, single.ImageSpecs = ReleaseImages("alpine:3.7")
+	bytes, err := json.Marshal(single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wire map[string]interface{}
+	if err := json.Unmarshal(bytes, &wire); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := wire["ImageSpecs"]; ok || wire["ImageSpec"] != "alpine:3.7" {
+		t.Errorf("expected single image to be sent as ImageSpec, got %s", string(bytes))
+	}
+
+	var multi ReleaseSpec
+	multi.ImageSpec, multi.ImageSpecs = ReleaseImages("alpine:3.7", "nginx:1.13")
+	bytes, err = json.Marshal(multi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roundtrip ReleaseSpec
+	if err := json.Unmarshal(bytes, &roundtrip); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roundtrip.Images(), []ImageSpec{"alpine:3.7", "nginx:1.13"}) {
+		t.Errorf("expected both images after round trip, got %v", roundtrip.Images())
+	}
+}